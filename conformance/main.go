@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cookiejar"
+	"crypto/sha512"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/logging"
+	"github.com/hyperledger/sawtooth-sdk-go/messaging"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/processor_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+	zmq "github.com/pebbe/zmq4"
+)
+
+var logger = logging.Get()
+
+// statusNames maps the status of a process response to the names used by the vectors
+var statusNames = map[processor_pb2.TpProcessResponse_Status]string{
+	processor_pb2.TpProcessResponse_OK:                  "OK",
+	processor_pb2.TpProcessResponse_INVALID_TRANSACTION: "INVALID_TRANSACTION",
+	processor_pb2.TpProcessResponse_INTERNAL_ERROR:      "INTERNAL_ERROR",
+}
+
+// hexdigest returns the sha512 hash of the input as a hex string
+func hexdigest(data []byte) string {
+	hash := sha512.Sum512(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// validator plays the part of the validator for a single transaction processor
+type validator struct {
+	connection *messaging.ZmqConnection
+	poller     *zmq.Poller
+	processor  string // zmq identity of the registered processor
+	timeout    time.Duration
+}
+
+// newValidator binds the validator's component endpoint
+func newValidator(endpoint string, timeout time.Duration) (*validator, error) {
+	context, err := zmq.NewContext()
+	if err != nil {
+		return nil, err
+	}
+	connection, err := messaging.NewConnection(context, zmq.ROUTER, endpoint, true)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to bind %s: %v", endpoint, err)
+	}
+
+	poller := zmq.NewPoller()
+	poller.Add(connection.Socket(), zmq.POLLIN)
+
+	return &validator{
+		connection: connection,
+		poller:     poller,
+		timeout:    timeout,
+	}, nil
+}
+
+// recv waits up to timeout for the next message of the processor
+func (v *validator) recv(timeout time.Duration) (string, *validator_pb2.Message, error) {
+	// A negative timeout would wait forever
+	if timeout <= 0 {
+		return "", nil, fmt.Errorf("timeout")
+	}
+
+	polled, err := v.poller.Poll(timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(polled) == 0 {
+		return "", nil, fmt.Errorf("timeout")
+	}
+
+	return v.connection.RecvMsg()
+}
+
+// reply sends the response to a request of the processor
+func (v *validator) reply(id string, t validator_pb2.Message_MessageType, response proto.Message, corrID string) error {
+	data, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	return v.connection.SendMsgTo(id, t, data, corrID)
+}
+
+// register accepts the registration of a processor
+func (v *validator) register(id string, msg *validator_pb2.Message) error {
+	request := &processor_pb2.TpRegisterRequest{}
+	if err := proto.Unmarshal(msg.Content, request); err != nil {
+		return err
+	}
+	logger.Infof("Processor %s registered for %s %s", id, request.Family, request.Version)
+
+	v.processor = id
+
+	return v.reply(id, validator_pb2.Message_TP_REGISTER_RESPONSE, &processor_pb2.TpRegisterResponse{
+		Status: processor_pb2.TpRegisterResponse_OK,
+	}, msg.CorrelationId)
+}
+
+// waitForProcessor waits until a processor registers
+func (v *validator) waitForProcessor(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for v.processor == "" {
+		id, msg, err := v.recv(time.Until(deadline))
+		if err != nil {
+			return fmt.Errorf("No processor registered: %v", err)
+		}
+		if msg.MessageType == validator_pb2.Message_TP_REGISTER_REQUEST {
+			if err := v.register(id, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// run sends the vector's transaction to the processor, answers its requests against the vector's state and
+// compares the outcome with the expected one
+func (v *validator) run(vec *vector, expected *outcome) error {
+	payload := []byte(vec.Transaction.Payload)
+	header := &transaction_pb2.TransactionHeader{
+		BatcherPublicKey: vec.SignerPublicKey,
+		FamilyName:       vec.Transaction.FamilyName,
+		FamilyVersion:    vec.Transaction.FamilyVersion,
+		Inputs:           vec.Transaction.Inputs,
+		Nonce:            vec.Name,
+		Outputs:          vec.Transaction.Outputs,
+		PayloadSha512:    hexdigest(payload),
+		SignerPublicKey:  vec.SignerPublicKey,
+	}
+	contextID := hexdigest([]byte(vec.Name + time.Now().String()))
+
+	// Both SDKs accept the expanded header, which is the only style the Go SDK's protos know
+	request := &processor_pb2.TpProcessRequest{
+		Header:    header,
+		Payload:   payload,
+		Signature: contextID,
+		ContextId: contextID,
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	context := cookiejar.NewValidatorContext(header, contextID, vec.initialState())
+	corrID, err := v.connection.SendNewMsgTo(v.processor, validator_pb2.Message_TP_PROCESS_REQUEST, data)
+	if err != nil {
+		return err
+	}
+
+	// Serve the processor until it responds to the transaction
+	deadline := time.Now().Add(v.timeout)
+	for {
+		id, msg, err := v.recv(time.Until(deadline))
+		if err != nil {
+			return fmt.Errorf("no response from the processor: %v", err)
+		}
+
+		switch msg.MessageType {
+		case validator_pb2.Message_TP_REGISTER_REQUEST:
+			// Processors register once for every version and may connect with several threads
+			if err := v.register(id, msg); err != nil {
+				return err
+			}
+		case validator_pb2.Message_TP_PROCESS_RESPONSE:
+			if msg.CorrelationId != corrID {
+				continue
+			}
+
+			response := &processor_pb2.TpProcessResponse{}
+			if err := proto.Unmarshal(msg.Content, response); err != nil {
+				return err
+			}
+			return vec.check(expected, response, context.Receipt())
+		case validator_pb2.Message_TP_STATE_GET_REQUEST, validator_pb2.Message_TP_STATE_SET_REQUEST,
+			validator_pb2.Message_TP_STATE_DELETE_REQUEST, validator_pb2.Message_TP_EVENT_ADD_REQUEST,
+			validator_pb2.Message_TP_RECEIPT_ADD_DATA_REQUEST:
+			responseType, response, err := context.Handle(msg.MessageType, msg.Content)
+			if err != nil {
+				return err
+			}
+			if err := v.reply(id, responseType, response, msg.CorrelationId); err != nil {
+				return err
+			}
+		default:
+			logger.Debugf("Ignoring message %v", msg.MessageType)
+		}
+	}
+}
+
+func main() {
+	bind := flag.String("bind", "tcp://0.0.0.0:4004", "endpoint the processor connects to")
+	dir := flag.String("vectors", "vectors", "directory holding the conformance vectors")
+	registerTimeout := flag.Duration("register-timeout", 2*time.Minute, "how long to wait for the processor to register")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for the processor to process a transaction")
+	flag.Parse()
+
+	vectors, err := loadVectors(*dir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if len(vectors) == 0 {
+		fmt.Printf("No vectors found in %s\n", *dir)
+		os.Exit(2)
+	}
+
+	v, err := newValidator(*bind, *timeout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := v.waitForProcessor(*registerTimeout); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	failed := 0
+	for _, vec := range vectors {
+		if err := v.run(vec, &vec.Expected); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", vec.Name, err)
+		} else {
+			fmt.Printf("PASS %s\n", vec.Name)
+		}
+	}
+
+	fmt.Printf("%d of %d vectors passed\n", len(vectors)-failed, len(vectors))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
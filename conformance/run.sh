@@ -0,0 +1,16 @@
+#!/bin/sh
+# Runs the conformance vectors against every transaction processor and fails when one of them diverges
+
+cd "$(dirname "$0")/.." || exit 1
+
+# The compose command, e.g. COMPOSE="docker compose" where only the compose plugin is installed
+COMPOSE=${COMPOSE:-docker-compose}
+
+for processor in goprocessor pyprocessor; do
+    echo "Running the conformance vectors against $processor"
+    CJ_PROCESSOR=$processor $COMPOSE -f docker-compose-conformance.yaml up \
+        --build --abort-on-container-exit --exit-code-from validator
+    status=$?
+    CJ_PROCESSOR=$processor $COMPOSE -f docker-compose-conformance.yaml down
+    [ $status -eq 0 ] || exit $status
+done
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"cookiejar"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/processor_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+)
+
+// vectorAttribute is a single attribute of an expected event
+type vectorAttribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// vectorEvent is an event the processor is expected to add
+type vectorEvent struct {
+	EventType  string            `json:"event_type"`
+	Attributes []vectorAttribute `json:"attributes"`
+	Data       string            `json:"data"` // base64 encoded
+}
+
+// outcome is the result a processor is expected to produce for a vector's transaction
+type outcome struct {
+	Status string            `json:"status"` // OK, INVALID_TRANSACTION or INTERNAL_ERROR
+	State  map[string]string `json:"state"`
+	Events []vectorEvent     `json:"events"`
+}
+
+// vector is a single conformance test: a transaction applied to an initial state and its expected outcome.
+// State values are the UTF-8 encoded entries. Every processor must produce the expected outcome.
+type vector struct {
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	SignerPublicKey string            `json:"signer_public_key"`
+	State           map[string]string `json:"state"`
+	Transaction     struct {
+		FamilyName    string   `json:"family_name"`
+		FamilyVersion string   `json:"family_version"`
+		Payload       string   `json:"payload"`
+		Inputs        []string `json:"inputs"`
+		Outputs       []string `json:"outputs"`
+	} `json:"transaction"`
+	Expected outcome `json:"expected"`
+}
+
+// loadVectors reads all vectors of the directory, ordered by file name
+func loadVectors(dir string) ([]*vector, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	vectors := make([]*vector, 0, len(files))
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		v := &vector{}
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", file, err)
+		}
+		if v.Name == "" {
+			v.Name = filepath.Base(file)
+		}
+		vectors = append(vectors, v)
+	}
+
+	return vectors, nil
+}
+
+// initialState returns the state the transaction is applied to
+func (v *vector) initialState() map[string][]byte {
+	state := map[string][]byte{}
+	for address, value := range v.State {
+		state[address] = []byte(value)
+	}
+
+	return state
+}
+
+// check compares the processor's response and the receipt of the transaction with the expected outcome
+func (v *vector) check(expected *outcome, response *processor_pb2.TpProcessResponse, receipt *cookiejar.Receipt) error {
+	status := statusNames[response.Status]
+	if status != expected.Status {
+		return fmt.Errorf("expected status %s, got %s (%s)", expected.Status, status, response.Message)
+	}
+
+	// The validator discards the changes of failed transactions
+	if status != "OK" {
+		return nil
+	}
+
+	if err := v.checkState(expected, receipt.StateChanges); err != nil {
+		return err
+	}
+
+	return checkEvents(expected, receipt.Events)
+}
+
+// checkState compares the state resulting from the state changes with the expected state
+func (v *vector) checkState(expected *outcome, changes []*transaction_receipt_pb2.StateChange) error {
+	state := v.initialState()
+	for _, change := range changes {
+		if change.Type == transaction_receipt_pb2.StateChange_DELETE {
+			delete(state, change.Address)
+		} else {
+			state[change.Address] = change.Value
+		}
+	}
+
+	for address, value := range expected.State {
+		actual, ok := state[address]
+		if !ok {
+			return fmt.Errorf("expected %s to hold %q, but it doesn't exist", address, value)
+		}
+		if !bytes.Equal(actual, []byte(value)) {
+			return fmt.Errorf("expected %s to hold %q, got %q", address, value, actual)
+		}
+	}
+	for address, actual := range state {
+		if _, ok := expected.State[address]; !ok {
+			return fmt.Errorf("expected %s not to exist, but it holds %q", address, actual)
+		}
+	}
+
+	return nil
+}
+
+// checkEvents compares the events added by the processor with the expected events
+func checkEvents(o *outcome, events []*events_pb2.Event) error {
+	if len(events) != len(o.Events) {
+		return fmt.Errorf("expected %d events, got %d", len(o.Events), len(events))
+	}
+
+	for i, expected := range o.Events {
+		actual := events[i]
+		if actual.EventType != expected.EventType {
+			return fmt.Errorf("expected event %d to be %s, got %s", i, expected.EventType, actual.EventType)
+		}
+
+		if len(actual.Attributes) != len(expected.Attributes) {
+			return fmt.Errorf("expected event %s to have %d attributes, got %d",
+				expected.EventType, len(expected.Attributes), len(actual.Attributes))
+		}
+		for j, a := range expected.Attributes {
+			if actual.Attributes[j].Key != a.Key || actual.Attributes[j].Value != a.Value {
+				return fmt.Errorf("expected attribute %s=%s of event %s, got %s=%s", a.Key, a.Value, expected.EventType,
+					actual.Attributes[j].Key, actual.Attributes[j].Value)
+			}
+		}
+
+		if data := base64.StdEncoding.EncodeToString(actual.Data); data != expected.Data {
+			return fmt.Errorf("expected data %q of event %s, got %q", expected.Data, expected.EventType, data)
+		}
+	}
+
+	return nil
+}
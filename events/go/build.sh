@@ -14,4 +14,4 @@
 # limitations under the License.
 # -----------------------------------------------------------------------------
 
-go build src/events_client.go
+go build -o events_client src/*.go
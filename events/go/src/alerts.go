@@ -0,0 +1,120 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// alertAttempts is the number of attempts of the webhook action
+const alertAttempts = 3
+
+// run runs the action for the alert, until the context is cancelled
+func (a *ruleAction) run(ctx context.Context, alert *alert, logf func(format string, args ...interface{})) error {
+	switch a.Type {
+	case "webhook":
+		return a.post(ctx, alert)
+	case "exec":
+		return a.exec(ctx, alert)
+	default:
+		logf("ALERT %s", alert.Message)
+		return nil
+	}
+}
+
+// post sends the alert as JSON, signed with the secret like the webhook deliveries, retrying the failed attempts
+func (a *ruleAction) post(ctx context.Context, alert *alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: a.Timeout}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, a.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "cookiejar-alerts")
+		req.Header.Set(webhookIDHeader, fmt.Sprintf("alert:%s:%s:%s:%d", alert.Rule, alert.Jar, alert.State,
+			alert.NotifiedAt.Unix()))
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		if a.Secret != "" {
+			req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(a.Secret, timestamp, body))
+		}
+
+		res, err := client.Do(req.WithContext(ctx))
+		if err == nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode >= 200 && res.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("%s responded with %s", a.URL, res.Status)
+		}
+		if attempt == alertAttempts || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// exec runs the command with the alert in CJ_ALERT_* environment variables and as JSON on its standard input
+func (a *ruleAction) exec(ctx context.Context, alert *alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"CJ_ALERT_RULE="+alert.Rule,
+		"CJ_ALERT_STATE="+alert.State,
+		"CJ_ALERT_JAR="+alert.Jar,
+		"CJ_ALERT_OWNER="+alert.Owner,
+		"CJ_ALERT_METRIC="+alert.Metric,
+		"CJ_ALERT_VALUE="+strconv.Itoa(alert.Value),
+		"CJ_ALERT_THRESHOLD="+strconv.Itoa(alert.Threshold),
+		"CJ_ALERT_MESSAGE="+alert.Message,
+	)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,122 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"cookiejarevents"
+)
+
+// blockPayload is a committed or orphaned block as sent to webhooks and gateway clients, with the events and jar
+// changes selected by their filter
+type blockPayload struct {
+	ID              string                      `json:"id"`
+	Endpoint        string                      `json:"endpoint,omitempty"`
+	Type            cookiejarevents.EventType   `json:"type"`
+	BlockNum        uint64                      `json:"block_num"`
+	BlockID         string                      `json:"block_id"`
+	PreviousBlockID string                      `json:"previous_block_id,omitempty"`
+	Events          []cookiejarevents.Event     `json:"events,omitempty"`
+	Changes         []cookiejarevents.JarChange `json:"changes,omitempty"`
+}
+
+// blockFilter selects the bake and eat events and the jar changes of the committed blocks
+type blockFilter struct {
+	eventTypes map[string]bool // selected event types
+	jars       map[string]bool // selected jar addresses, nil selects every jar
+	filters    []*cookiejarevents.ScopedFilter
+}
+
+// newBlockFilter returns a filter selecting the events of the event types which pass the attribute filters, and the
+// changes of the jars of the owners and addresses, or of every jar if none is given
+func newBlockFilter(eventTypes, owners, jars, filters []string) (*blockFilter, error) {
+	f := &blockFilter{eventTypes: map[string]bool{}}
+	for _, t := range eventTypes {
+		f.eventTypes[t] = true
+	}
+
+	if len(owners) > 0 || len(jars) > 0 {
+		f.jars = map[string]bool{}
+		for _, owner := range owners {
+			f.jars[cookiejarevents.JarAddress(owner)] = true
+		}
+		for _, jar := range jars {
+			f.jars[jar] = true
+		}
+	}
+
+	for _, s := range filters {
+		scoped, err := cookiejarevents.ParseFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		f.filters = append(f.filters, scoped)
+	}
+
+	return f, nil
+}
+
+// apply returns the payload of a committed block with the selected events and changes. With owners or jars the
+// events are selected if the block changed one of them: the bake and eat events don't tell which jar they changed, so
+// a block changing several jars brings the events of all of them.
+func (f *blockFilter) apply(event *cookiejarevents.CookiejarEvent) *blockPayload {
+	p := &blockPayload{
+		ID:              string(event.Type) + ":" + event.BlockID,
+		Type:            event.Type,
+		BlockNum:        event.BlockNum,
+		BlockID:         event.BlockID,
+		PreviousBlockID: event.PreviousBlockID,
+	}
+
+	for _, change := range event.Changes {
+		if f.jars == nil || f.jars[change.Address] {
+			p.Changes = append(p.Changes, change)
+		}
+	}
+
+	for i := range event.Events {
+		e := &event.Events[i]
+		if !f.eventTypes[e.EventType] || (f.jars != nil && len(p.Changes) == 0) {
+			continue
+		}
+		matched := true
+		for _, scoped := range f.filters {
+			if !scoped.Match(e) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			p.Events = append(p.Events, *e)
+		}
+	}
+
+	return p
+}
+
+// limit returns the block with the changes of the selected jars only, as a subscription limited to them receives it
+func (f *blockFilter) limit(event *cookiejarevents.CookiejarEvent) *cookiejarevents.CookiejarEvent {
+	limited := *event
+	limited.Changes = nil
+	for _, change := range event.Changes {
+		if f.jars == nil || f.jars[change.Address] {
+			limited.Changes = append(limited.Changes, change)
+		}
+	}
+
+	return &limited
+}
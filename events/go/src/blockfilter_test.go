@@ -0,0 +1,111 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"cookiejarevents"
+	"reflect"
+	"testing"
+)
+
+// jarEvent returns a bake or eat event of the amount, with the attribute the processor adds
+func jarEvent(eventType, amount string) cookiejarevents.Event {
+	key := "cookies-baked"
+	if eventType == cookiejarevents.EatEventType {
+		key = "cookies-ate"
+	}
+
+	return cookiejarevents.Event{EventType: eventType,
+		Attributes: []cookiejarevents.Attribute{{Key: key, Value: amount}}}
+}
+
+func balance(owner string, cookies int) cookiejarevents.JarChange {
+	return cookiejarevents.JarChange{Address: cookiejarevents.JarAddress(owner), Balance: &cookies}
+}
+
+// amounts returns the cookies attribute of the events of the payload
+func amounts(p *blockPayload) []string {
+	var got []string
+	for _, e := range p.Events {
+		got = append(got, e.Attributes[0].Value)
+	}
+
+	return got
+}
+
+func TestBlockFilterSelectsBlocksOfJars(t *testing.T) {
+	event := &cookiejarevents.CookiejarEvent{
+		Type:    cookiejarevents.BlockCommit,
+		BlockID: "b",
+		Changes: []cookiejarevents.JarChange{balance("alice", 3), balance("bob", 5)},
+		Events: []cookiejarevents.Event{
+			jarEvent(cookiejarevents.BakeEventType, "1"),
+			jarEvent(cookiejarevents.BakeEventType, "2"),
+			jarEvent(cookiejarevents.EatEventType, "3"),
+		},
+	}
+
+	// The events of a block changing one of the jars pass, with the changes of the jars only
+	f, err := newBlockFilter([]string{cookiejarevents.BakeEventType, cookiejarevents.EatEventType}, []string{"alice"},
+		nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := f.apply(event)
+	if got := amounts(p); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Fatalf("got events %v, want every event of the block", got)
+	}
+	if len(p.Changes) != 1 || p.Changes[0].Address != cookiejarevents.JarAddress("alice") {
+		t.Fatalf("got changes %+v, want the jar of alice", p.Changes)
+	}
+
+	// Without owners or jars every event of the selected types passes
+	f, _ = newBlockFilter([]string{cookiejarevents.BakeEventType}, nil, nil, nil)
+	if got := amounts(f.apply(event)); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("got events %v, want the bakes", got)
+	}
+
+	// The attribute filters apply on top of the jars
+	f, _ = newBlockFilter([]string{cookiejarevents.BakeEventType, cookiejarevents.EatEventType}, []string{"alice"},
+		nil, []string{"eat@cookies-ate=3", "bake@cookies-baked=9"})
+	if got := amounts(f.apply(event)); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Fatalf("got events %v, want the eat", got)
+	}
+
+	// Nothing passes from a block which didn't change one of the jars
+	f, _ = newBlockFilter([]string{cookiejarevents.BakeEventType}, nil, []string{cookiejarevents.JarAddress("carol")},
+		nil)
+	if p := f.apply(event); len(p.Events) != 0 || len(p.Changes) != 0 {
+		t.Fatalf("got %+v, want nothing", p)
+	}
+}
+
+func TestBlockFilterLimitsChanges(t *testing.T) {
+	f, _ := newBlockFilter(nil, []string{"alice"}, nil, nil)
+	event := &cookiejarevents.CookiejarEvent{Type: cookiejarevents.BlockCommit,
+		Changes: []cookiejarevents.JarChange{balance("alice", 3), balance("bob", 5)},
+		Events:  []cookiejarevents.Event{jarEvent(cookiejarevents.BakeEventType, "1")}}
+
+	limited := f.limit(event)
+	if len(limited.Changes) != 1 || limited.Changes[0].Address != cookiejarevents.JarAddress("alice") {
+		t.Fatalf("got changes %+v, want the jar of alice", limited.Changes)
+	}
+	if len(limited.Events) != 1 || len(event.Changes) != 2 {
+		t.Fatalf("got %+v from %+v, want the events kept and the block untouched", limited, event)
+	}
+}
@@ -0,0 +1,154 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// checkpointDepth is the number of processed blocks remembered to walk back and detect forks
+const checkpointDepth = 50
+
+// BlockRef identifies a processed block
+type BlockRef struct {
+	BlockNum        uint64 `json:"block_num"`
+	BlockID         string `json:"block_id"`
+	PreviousBlockID string `json:"previous_block_id"`
+}
+
+// checkpoint holds the last processed blocks, oldest first, and is persisted to resume after a restart
+type checkpoint struct {
+	file   string
+	Blocks []BlockRef `json:"blocks"`
+}
+
+// loadCheckpoint reads the checkpoint file. A missing file results in an empty checkpoint and an empty name in a
+// checkpoint which isn't persisted.
+func loadCheckpoint(file string) (*checkpoint, error) {
+	c := &checkpoint{file: file}
+	if file == "" {
+		return c, nil
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read checkpoint: %v", err)
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("Failed to parse checkpoint %s: %v", file, err)
+	}
+
+	return c, nil
+}
+
+// save writes the checkpoint to a temporary file which replaces the checkpoint file, so a crash never leaves it
+// half written
+func (c *checkpoint) save() error {
+	if c.file == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.file), filepath.Base(c.file))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// clone returns a copy of the checkpoint which can be advanced without changing it
+func (c *checkpoint) clone() *checkpoint {
+	return &checkpoint{file: c.file, Blocks: append([]BlockRef(nil), c.Blocks...)}
+}
+
+// knownBlockIds returns the ids of the processed blocks, newest first
+func (c *checkpoint) knownBlockIds() []string {
+	ids := make([]string, 0, len(c.Blocks))
+	for i := len(c.Blocks) - 1; i >= 0; i-- {
+		ids = append(ids, c.Blocks[i].BlockID)
+	}
+
+	return ids
+}
+
+// advance adds the committed block and returns rollback records for the blocks it orphaned, newest first. Blocks
+// which were already processed, as resent when resuming, are reported as duplicates.
+func (c *checkpoint) advance(record *CookiejarEvent) ([]*CookiejarEvent, bool) {
+	parent := -1
+	for i, b := range c.Blocks {
+		if b.BlockID == record.BlockID {
+			return nil, true
+		}
+		if b.BlockID == record.PreviousBlockID {
+			parent = i
+		}
+	}
+
+	// Blocks after the new block's parent were on a fork which was abandoned. If the parent isn't known anymore, all
+	// blocks at or above the new block's height were replaced.
+	keep := len(c.Blocks)
+	if parent >= 0 {
+		keep = parent + 1
+	} else {
+		for keep > 0 && c.Blocks[keep-1].BlockNum >= record.BlockNum {
+			keep--
+		}
+	}
+
+	var rollbacks []*CookiejarEvent
+	for i := len(c.Blocks) - 1; i >= keep; i-- {
+		b := c.Blocks[i]
+		rollbacks = append(rollbacks, &CookiejarEvent{
+			Type:            BlockRollback,
+			BlockNum:        b.BlockNum,
+			BlockID:         b.BlockID,
+			PreviousBlockID: b.PreviousBlockID,
+			Changes:         []JarChange{},
+		})
+	}
+
+	c.Blocks = append(c.Blocks[:keep], BlockRef{
+		BlockNum:        record.BlockNum,
+		BlockID:         record.BlockID,
+		PreviousBlockID: record.PreviousBlockID,
+	})
+	if len(c.Blocks) > checkpointDepth {
+		c.Blocks = c.Blocks[len(c.Blocks)-checkpointDepth:]
+	}
+
+	return rollbacks, false
+}
@@ -0,0 +1,148 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// commit returns the commit event of a block
+func commit(num uint64, id, previous string) *CookiejarEvent {
+	return &CookiejarEvent{Type: BlockCommit, BlockNum: num, BlockID: id, PreviousBlockID: previous}
+}
+
+// rolledBack returns the ids of the rollback events
+func rolledBack(rollbacks []*CookiejarEvent) []string {
+	var ids []string
+	for _, r := range rollbacks {
+		if r.Type != BlockRollback {
+			panic("not a rollback")
+		}
+		ids = append(ids, r.BlockID)
+	}
+
+	return ids
+}
+
+// chain returns a checkpoint holding the blocks a, b and c
+func chain() *checkpoint {
+	c := &checkpoint{}
+	c.advance(commit(1, "a", "genesis"))
+	c.advance(commit(2, "b", "a"))
+	c.advance(commit(3, "c", "b"))
+
+	return c
+}
+
+func TestAdvanceAppendsChildren(t *testing.T) {
+	c := chain()
+
+	if rollbacks, duplicate := c.advance(commit(4, "d", "c")); len(rollbacks) != 0 || duplicate {
+		t.Fatalf("got rollbacks %v, duplicate %v", rolledBack(rollbacks), duplicate)
+	}
+	if ids := c.knownBlockIds(); !reflect.DeepEqual(ids, []string{"d", "c", "b", "a"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestAdvanceReportsDuplicates(t *testing.T) {
+	c := chain()
+
+	if rollbacks, duplicate := c.advance(commit(2, "b", "a")); len(rollbacks) != 0 || !duplicate {
+		t.Fatalf("got rollbacks %v, duplicate %v, want a duplicate", rolledBack(rollbacks), duplicate)
+	}
+	if len(c.Blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3", len(c.Blocks))
+	}
+}
+
+func TestAdvanceRollsBackFork(t *testing.T) {
+	c := chain()
+
+	// b' replaces b and c, newest first
+	rollbacks, duplicate := c.advance(commit(2, "b'", "a"))
+	if duplicate || !reflect.DeepEqual(rolledBack(rollbacks), []string{"c", "b"}) {
+		t.Fatalf("got rollbacks %v, duplicate %v, want c and b", rolledBack(rollbacks), duplicate)
+	}
+	if rollbacks[0].BlockNum != 3 || rollbacks[0].PreviousBlockID != "b" {
+		t.Fatalf("got %+v, want the ref of block c", rollbacks[0])
+	}
+	if ids := c.knownBlockIds(); !reflect.DeepEqual(ids, []string{"b'", "a"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestAdvanceRollsBackUnknownParent(t *testing.T) {
+	c := chain()
+
+	// The parent of the new block 3 isn't known, so the blocks at or above its height were replaced
+	rollbacks, _ := c.advance(commit(3, "c'", "x"))
+	if !reflect.DeepEqual(rolledBack(rollbacks), []string{"c"}) {
+		t.Fatalf("got rollbacks %v, want c", rolledBack(rollbacks))
+	}
+
+	// A gap after a restart doesn't roll anything back
+	rollbacks, _ = c.advance(commit(10, "j", "i"))
+	if len(rollbacks) != 0 {
+		t.Fatalf("got rollbacks %v, want none", rolledBack(rollbacks))
+	}
+	if ids := c.knownBlockIds(); !reflect.DeepEqual(ids, []string{"j", "c'", "b", "a"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestAdvanceKeepsDepth(t *testing.T) {
+	c := &checkpoint{}
+	previous := "genesis"
+	for i := 0; i < checkpointDepth+10; i++ {
+		id := string(rune('A' + i))
+		c.advance(commit(uint64(i+1), id, previous))
+		previous = id
+	}
+
+	if len(c.Blocks) != checkpointDepth || c.Blocks[len(c.Blocks)-1].BlockID != previous {
+		t.Fatalf("got %d blocks ending with %s", len(c.Blocks), c.Blocks[len(c.Blocks)-1].BlockID)
+	}
+}
+
+func TestCheckpointSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "checkpoint.json")
+
+	c, err := loadCheckpoint(file)
+	if err != nil || len(c.Blocks) != 0 {
+		t.Fatalf("got %v, %v, want an empty checkpoint", c, err)
+	}
+	c.advance(commit(1, "a", "genesis"))
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadCheckpoint(file)
+	if err != nil || !reflect.DeepEqual(loaded.Blocks, c.Blocks) {
+		t.Fatalf("got %v, %v, want %v", loaded, err, c.Blocks)
+	}
+}
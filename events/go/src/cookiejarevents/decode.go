@@ -0,0 +1,150 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+)
+
+// blockInfoPrefix is the address prefix of the BlockInfo entries, followed by the block number. The BlockInfo
+// namespace also holds the config entry of the family.
+const blockInfoPrefix = BlockInfoNamespace + "00"
+
+// blockInfo is the BlockInfo entry the validator writes for the previous block when building a block. Its timestamp
+// is the time the new block was built at.
+type blockInfo struct {
+	BlockNum        uint64 `protobuf:"varint,1,opt,name=block_num,json=blockNum,proto3"`
+	PreviousBlockID string `protobuf:"bytes,2,opt,name=previous_block_id,json=previousBlockId,proto3"`
+	SignerPublicKey string `protobuf:"bytes,3,opt,name=signer_public_key,json=signerPublicKey,proto3"`
+	HeaderSignature string `protobuf:"bytes,4,opt,name=header_signature,json=headerSignature,proto3"`
+	Timestamp       uint64 `protobuf:"varint,5,opt,name=timestamp,proto3"`
+}
+
+func (m *blockInfo) Reset()         { *m = blockInfo{} }
+func (m *blockInfo) String() string { return proto.CompactTextString(m) }
+func (*blockInfo) ProtoMessage()    {}
+
+// JarAddress returns the address of the jar owned by the public key
+func JarAddress(publicKey string) string {
+	hash := sha512.Sum512([]byte(publicKey))
+	return Namespace + hex.EncodeToString(hash[:])[:64]
+}
+
+// LoadOwners maps the jar addresses to the public keys stored in the keys directory. Jars of other keys are reported
+// without owner, since the address can't be traced back to the public key.
+func LoadOwners(dir string) map[string]Owner {
+	owners := map[string]Owner{}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pub"))
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		publicKey := strings.TrimSpace(string(b))
+		owners[JarAddress(publicKey)] = Owner{
+			PublicKey: publicKey,
+			KeyName:   strings.TrimSuffix(filepath.Base(file), ".pub"),
+		}
+	}
+
+	return owners
+}
+
+// decodeEvents turns the events of a committed block into a CookiejarEvent
+func decodeEvents(events []*events_pb2.Event, owners map[string]Owner) (*CookiejarEvent, error) {
+	record := &CookiejarEvent{Type: BlockCommit, Changes: []JarChange{}}
+	for _, event := range events {
+		switch event.EventType {
+		case BlockCommitEventType:
+			for _, a := range event.Attributes {
+				switch a.Key {
+				case "block_num":
+					num, err := strconv.ParseUint(a.Value, 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid block number %q: %v", a.Value, err)
+					}
+					record.BlockNum = num
+				case "block_id":
+					record.BlockID = a.Value
+				case "state_root_hash":
+					record.StateRootHash = a.Value
+				case "previous_block_id":
+					record.PreviousBlockID = a.Value
+				}
+			}
+		case StateDeltaEventType:
+			changes := transaction_receipt_pb2.StateChangeList{}
+			if err := proto.Unmarshal(event.Data, &changes); err != nil {
+				return nil, fmt.Errorf("Failed to decode state delta: %v", err)
+			}
+			for _, change := range changes.StateChanges {
+				if strings.HasPrefix(change.Address, blockInfoPrefix) {
+					// A block holds the entry of its parent, written when building it
+					info := blockInfo{}
+					if err := proto.Unmarshal(change.Value, &info); err != nil {
+						return nil, fmt.Errorf("Failed to decode block info: %v", err)
+					}
+					if ts := int64(info.Timestamp); ts > record.Timestamp {
+						record.Timestamp = ts
+					}
+				}
+				if !strings.HasPrefix(change.Address, Namespace) {
+					continue
+				}
+				record.Changes = append(record.Changes, decodeChange(change, owners))
+			}
+		default:
+			e := Event{EventType: event.EventType, Data: event.Data}
+			for _, a := range event.Attributes {
+				e.Attributes = append(e.Attributes, Attribute{Key: a.Key, Value: a.Value})
+			}
+			record.Events = append(record.Events, e)
+		}
+	}
+
+	return record, nil
+}
+
+// decodeChange decodes the state change of a jar
+func decodeChange(change *transaction_receipt_pb2.StateChange, owners map[string]Owner) JarChange {
+	jar := JarChange{Address: change.Address}
+	if owner, ok := owners[change.Address]; ok {
+		jar.Owner = owner.PublicKey
+		jar.KeyName = owner.KeyName
+	}
+
+	if change.Type == transaction_receipt_pb2.StateChange_DELETE {
+		jar.Deleted = true
+	} else if balance, err := strconv.Atoi(string(change.Value)); err == nil {
+		jar.Balance = &balance
+	} else {
+		jar.Data = string(change.Value)
+	}
+
+	return jar
+}
@@ -0,0 +1,75 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+)
+
+// stateDelta returns the state delta event of the changes
+func stateDelta(t *testing.T, changes ...*transaction_receipt_pb2.StateChange) *events_pb2.Event {
+	data, err := proto.Marshal(&transaction_receipt_pb2.StateChangeList{StateChanges: changes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &events_pb2.Event{EventType: StateDeltaEventType, Data: data}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	jar := JarAddress("02abcd")
+	// BlockInfo of block 6 written when building block 7 at 1500000000: block_num = 6, header_signature = "f",
+	// timestamp = 1500000000
+	info := []byte{0x08, 0x06, 0x22, 0x01, 'f', 0x28, 0x80, 0xde, 0xa0, 0xcb, 0x05}
+
+	record, err := decodeEvents([]*events_pb2.Event{
+		&events_pb2.Event{EventType: BlockCommitEventType, Attributes: []*events_pb2.Event_Attribute{
+			{Key: "block_num", Value: "7"},
+			{Key: "block_id", Value: "g"},
+			{Key: "previous_block_id", Value: "f"},
+		}},
+		stateDelta(t,
+			&transaction_receipt_pb2.StateChange{Address: jar, Value: []byte("12"), Type: transaction_receipt_pb2.StateChange_SET},
+			&transaction_receipt_pb2.StateChange{Address: blockInfoPrefix + "06", Value: info, Type: transaction_receipt_pb2.StateChange_SET},
+			&transaction_receipt_pb2.StateChange{Address: "1cf126" + "00", Value: []byte("setting")},
+		),
+		&events_pb2.Event{EventType: BakeEventType, Attributes: []*events_pb2.Event_Attribute{
+			{Key: "cookies-baked", Value: "2"},
+		}},
+	}, map[string]Owner{jar: Owner{PublicKey: "02abcd", KeyName: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.BlockNum != 7 || record.BlockID != "g" || record.PreviousBlockID != "f" {
+		t.Fatalf("got block %d %s after %s", record.BlockNum, record.BlockID, record.PreviousBlockID)
+	}
+	if record.Timestamp != 1500000000 {
+		t.Fatalf("got timestamp %d, want 1500000000", record.Timestamp)
+	}
+	if len(record.Changes) != 1 || *record.Changes[0].Balance != 12 || record.Changes[0].KeyName != "alice" {
+		t.Fatalf("got changes %+v, want the jar of alice with 12 cookies", record.Changes)
+	}
+	if len(record.Events) != 1 || record.Events[0].EventType != BakeEventType {
+		t.Fatalf("got events %+v, want the bake", record.Events)
+	}
+}
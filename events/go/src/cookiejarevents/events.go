@@ -0,0 +1,261 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+// Package cookiejarevents subscribes to the events of a Sawtooth validator and delivers the committed blocks with
+// the cookie jars they changed. Subscriptions resume after the last processed block, survive validator restarts and
+// report the blocks orphaned by forks.
+package cookiejarevents
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	zmq "github.com/pebbe/zmq4"
+)
+
+const (
+	// DefaultValidatorURL is the validator connected to when neither the options nor VALIDATOR_URL set one
+	DefaultValidatorURL = "tcp://validator:4004"
+	// Namespace is the address prefix of the cookiejar family, the first 6 characters of SHA-512("cookiejar")
+	Namespace = "a4d219"
+	// BlockInfoNamespace is the address prefix of the BlockInfo family, whose transactions are injected into every
+	// block by the validator's block_info injector
+	BlockInfoNamespace = "00b10c"
+
+	// The event types of the validator
+	BlockCommitEventType = "sawtooth/block-commit"
+	StateDeltaEventType  = "sawtooth/state-delta"
+)
+
+// EventType tells what a CookiejarEvent reports
+type EventType string
+
+const (
+	// BlockCommit reports a committed block and the jars it changed
+	BlockCommit EventType = "commit"
+	// BlockRollback reports a previously committed block which was orphaned by a fork, whose changes don't apply
+	// anymore. Rollbacks are delivered newest first, before the commit of the block replacing them.
+	BlockRollback EventType = "rollback"
+	// SubscriptionError reports the error which ended the subscription. It's the last event before the channel is
+	// closed.
+	SubscriptionError EventType = "error"
+)
+
+// JarChange is the new balance of a cookie jar changed by a block
+type JarChange struct {
+	Address string `json:"address"`
+	Owner   string `json:"owner,omitempty"`    // public key of the owner, if known
+	KeyName string `json:"key_name,omitempty"` // name of the local key owning the jar, if any
+	Balance *int   `json:"balance,omitempty"`  // nil if the jar was deleted or doesn't hold a cookie count
+	Data    string `json:"data,omitempty"`     // the raw value if it isn't a cookie count
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Attribute is a key value pair of an event
+type Attribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Event is an event of a committed block other than the block commit and the state delta, for instance the
+// cookiejar/bake events of the transaction processor
+type Event struct {
+	EventType  string      `json:"event_type"`
+	Attributes []Attribute `json:"attributes,omitempty"`
+	Data       []byte      `json:"data,omitempty"`
+}
+
+// Attribute returns the value of the first attribute with the key, and whether there is one
+func (e *Event) Attribute(key string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// CookiejarEvent is a committed or orphaned block with the jars it changed
+type CookiejarEvent struct {
+	Type            EventType   `json:"type"`
+	BlockNum        uint64      `json:"block_num"`
+	BlockID         string      `json:"block_id"`
+	PreviousBlockID string      `json:"previous_block_id,omitempty"`
+	StateRootHash   string      `json:"state_root_hash,omitempty"`
+	Timestamp       int64       `json:"timestamp,omitempty"` // unix time the block was built at, see Options.BlockTimes
+	Changes         []JarChange `json:"changes"`
+	Events          []Event     `json:"events,omitempty"`
+	Err             error       `json:"-"` // set for SubscriptionError events
+
+	ack chan<- struct{} // set with Options.ManualAck
+}
+
+// Ack confirms that the consumer handled the event, see Options.ManualAck. Acking twice or without ManualAck does
+// nothing.
+func (e *CookiejarEvent) Ack() {
+	if e.ack == nil {
+		return
+	}
+	select {
+	case e.ack <- struct{}{}:
+	default:
+	}
+}
+
+// Owner is the owner of a jar, known from a local key
+type Owner struct {
+	PublicKey string
+	KeyName   string
+}
+
+// Options configures a subscription. The zero value subscribes to the cookiejar state changes of the validator at
+// VALIDATOR_URL, or DefaultValidatorURL, without persisting the checkpoint.
+type Options struct {
+	// ValidatorURL is the validator's component endpoint
+	ValidatorURL string
+	// Filters select the state changes of the state delta subscription. The default matches the cookiejar namespace.
+	Filters []*events_pb2.EventFilter
+	// Subscriptions replace the default block commit and state delta subscriptions, for instance to also receive the
+	// cookiejar/bake events. A block commit subscription is added if missing, since resuming depends on it.
+	Subscriptions []*events_pb2.EventSubscription
+	// BlockTimes adds a state delta subscription to the BlockInfo namespace, so the commits carry the Timestamp of the
+	// BlockInfo transaction the validator injected into the block when building it. Sawtooth blocks have no timestamp
+	// of their own; it stays 0 if the network doesn't run the block_info injector and transaction processor.
+	BlockTimes bool
+	// Owners maps jar addresses to their owners, see LoadOwners
+	Owners map[string]Owner
+	// Checkpoint is the file persisting the last processed blocks to resume after a restart. Empty keeps them in
+	// memory, which still resumes after reconnecting.
+	Checkpoint string
+	// Resume lists the last processed blocks, oldest first, for consumers which persist their own progress. It's
+	// only used without a checkpoint file.
+	Resume []BlockRef
+	// IdleTimeout is how long nothing, not even a ping, may be received before reconnecting. Default 1 minute.
+	IdleTimeout time.Duration
+	// MinReconnectDelay and MaxReconnectDelay bound the exponential backoff between reconnection attempts.
+	// Defaults 1 second and 1 minute.
+	MinReconnectDelay time.Duration
+	MaxReconnectDelay time.Duration
+	// Logf logs the connection state. Defaults to the standard logger.
+	Logf func(format string, args ...interface{})
+	// ManualAck waits for the consumer to Ack every event before delivering the next one, and only advances the
+	// checkpoint once the block's events are acked. An event the consumer didn't ack, because it crashed or was
+	// stopped while handling it, is delivered again after a restart. Without it, the checkpoint advances as soon as
+	// the events were received from the channel.
+	ManualAck bool
+}
+
+// subscriptions returns the subscriptions to send, with a block commit subscription first
+func (o *Options) subscriptions() []*events_pb2.EventSubscription {
+	var subscriptions []*events_pb2.EventSubscription
+	if o.Subscriptions == nil {
+		filters := o.Filters
+		if filters == nil {
+			filters = []*events_pb2.EventFilter{NamespaceFilter()}
+		}
+		subscriptions = []*events_pb2.EventSubscription{
+			&events_pb2.EventSubscription{EventType: BlockCommitEventType},
+			&events_pb2.EventSubscription{EventType: StateDeltaEventType, Filters: filters},
+		}
+	} else {
+		subscriptions = o.Subscriptions
+		commit := false
+		for _, s := range o.Subscriptions {
+			commit = commit || s.EventType == BlockCommitEventType
+		}
+		if !commit {
+			subscriptions = append([]*events_pb2.EventSubscription{
+				&events_pb2.EventSubscription{EventType: BlockCommitEventType},
+			}, subscriptions...)
+		}
+	}
+
+	// The validator sends the state changes matching any subscription, so the BlockInfo changes don't widen the
+	// cookiejar changes of the other subscription
+	if o.BlockTimes {
+		subscriptions = append(subscriptions, &events_pb2.EventSubscription{
+			EventType: StateDeltaEventType,
+			Filters:   []*events_pb2.EventFilter{BlockInfoFilter()},
+		})
+	}
+
+	return subscriptions
+}
+
+// Subscribe subscribes to the events of the validator and delivers them on the returned channel. The subscription
+// resumes after the newest block of the checkpoint and reconnects whenever the connection is lost. Cancelling the
+// context unsubscribes and closes the channel. An error is returned if the checkpoint can't be read.
+func Subscribe(ctx context.Context, opts Options) (<-chan CookiejarEvent, error) {
+	if opts.ValidatorURL == "" {
+		opts.ValidatorURL = os.Getenv("VALIDATOR_URL")
+	}
+	if opts.ValidatorURL == "" {
+		opts.ValidatorURL = DefaultValidatorURL
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = time.Minute
+	}
+	if opts.MinReconnectDelay == 0 {
+		opts.MinReconnectDelay = time.Second
+	}
+	if opts.MaxReconnectDelay == 0 {
+		opts.MaxReconnectDelay = time.Minute
+	}
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+
+	cp, err := loadCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Checkpoint == "" {
+		cp.Blocks = append(cp.Blocks, opts.Resume...)
+	}
+	zmqContext, err := zmq.NewContext()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan CookiejarEvent)
+	l := &listener{
+		opts:          opts,
+		subscriptions: opts.subscriptions(),
+		cp:            cp,
+		ctx:           ctx,
+		events:        events,
+		dial: func() (validatorConnection, error) {
+			return dialValidator(zmqContext, opts.ValidatorURL)
+		},
+	}
+	go func() {
+		defer close(events)
+
+		if err := l.run(); err != nil {
+			select {
+			case events <- CookiejarEvent{Type: SubscriptionError, Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,218 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+)
+
+// The event types of the cookiejar transaction processor
+const (
+	BakeEventType = "cookiejar/bake"
+	EatEventType  = "cookiejar/eat"
+)
+
+// EventTypes maps the names accepted by ParseEventType to the event types
+var EventTypes = map[string]string{
+	"bake":         BakeEventType,
+	"eat":          EatEventType,
+	"state-delta":  StateDeltaEventType,
+	"block-commit": BlockCommitEventType,
+}
+
+// FilterTypes maps the names accepted by ParseFilter to the filter types. SIMPLE filters compare the attribute
+// values with the value, REGEX filters match them against it. ANY filters match if one attribute with the key
+// matches, ALL filters if every attribute with the key matches.
+var FilterTypes = map[string]events_pb2.EventFilter_FilterType{
+	"simple-any": events_pb2.EventFilter_SIMPLE_ANY,
+	"simple-all": events_pb2.EventFilter_SIMPLE_ALL,
+	"regex-any":  events_pb2.EventFilter_REGEX_ANY,
+	"regex-all":  events_pb2.EventFilter_REGEX_ALL,
+}
+
+// names returns the sorted keys of the map, for error messages
+func names(m interface{}) string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]events_pb2.EventFilter_FilterType:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ", ")
+}
+
+// ParseEventType returns the event type named either by its short name, such as bake, or by its full name, such as
+// cookiejar/bake
+func ParseEventType(name string) (string, error) {
+	if t, ok := EventTypes[name]; ok {
+		return t, nil
+	}
+	for _, t := range EventTypes {
+		if t == name {
+			return t, nil
+		}
+	}
+
+	return "", fmt.Errorf("Invalid event type %q, use one of %s", name, names(EventTypes))
+}
+
+// ScopedFilter is a filter which applies to a single event type, or to every subscription but the block commit if
+// EventType is empty
+type ScopedFilter struct {
+	EventType string
+	Filter    *events_pb2.EventFilter
+
+	re *regexp.Regexp // compiled match string of the REGEX filters
+}
+
+// ParseFilter parses a filter of the form [<event type>@][<filter type>:]<key>=<value>, for instance
+// bake@simple-any:cookies-baked=10. The filter type defaults to simple-any.
+func ParseFilter(s string) (*ScopedFilter, error) {
+	f := &ScopedFilter{Filter: &events_pb2.EventFilter{FilterType: events_pb2.EventFilter_SIMPLE_ANY}}
+
+	if i := strings.Index(s, "@"); i >= 0 {
+		t, err := ParseEventType(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		f.EventType = t
+		s = s[i+1:]
+	}
+
+	if i := strings.Index(s, ":"); i >= 0 {
+		if t, ok := FilterTypes[strings.ToLower(s[:i])]; ok {
+			f.Filter.FilterType = t
+			s = s[i+1:]
+		}
+	}
+
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("Invalid filter %q, use [<event type>@][<filter type>:]<key>=<value> with filter type %s",
+			s, names(FilterTypes))
+	}
+	f.Filter.Key = s[:i]
+	f.Filter.MatchString = s[i+1:]
+
+	if f.Filter.FilterType == events_pb2.EventFilter_REGEX_ANY || f.Filter.FilterType == events_pb2.EventFilter_REGEX_ALL {
+		re, err := regexp.Compile(f.Filter.MatchString)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression %q: %v", f.Filter.MatchString, err)
+		}
+		f.re = re
+	}
+
+	return f, nil
+}
+
+// Match applies the filter to a received event the way the validator does, for consumers which filter locally.
+// Events of another type than the filter's match. ANY filters need an attribute with the key which matches, ALL
+// filters match unless an attribute with the key doesn't.
+func (f *ScopedFilter) Match(e *Event) bool {
+	if f.EventType != "" && f.EventType != e.EventType {
+		return true
+	}
+
+	matches := func(value string) bool {
+		if f.re == nil && (f.Filter.FilterType == events_pb2.EventFilter_REGEX_ANY ||
+			f.Filter.FilterType == events_pb2.EventFilter_REGEX_ALL) {
+			re, err := regexp.Compile(f.Filter.MatchString)
+			if err != nil {
+				return false
+			}
+			f.re = re
+		}
+		if f.re != nil {
+			return f.re.MatchString(value)
+		}
+		return value == f.Filter.MatchString
+	}
+
+	all := f.Filter.FilterType == events_pb2.EventFilter_SIMPLE_ALL || f.Filter.FilterType == events_pb2.EventFilter_REGEX_ALL
+	for _, a := range e.Attributes {
+		if a.Key != f.Filter.Key {
+			continue
+		}
+		if m := matches(a.Value); m != all {
+			return m
+		}
+	}
+
+	return all
+}
+
+// AddressFilter returns a state delta filter matching the changes of any of the addresses
+func AddressFilter(addresses ...string) *events_pb2.EventFilter {
+	quoted := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		quoted = append(quoted, regexp.QuoteMeta(a))
+	}
+
+	return &events_pb2.EventFilter{
+		Key:         "address",
+		MatchString: fmt.Sprintf("^(%s)$", strings.Join(quoted, "|")),
+		FilterType:  events_pb2.EventFilter_REGEX_ANY,
+	}
+}
+
+// NamespaceFilter returns a state delta filter matching the changes of any cookie jar
+func NamespaceFilter() *events_pb2.EventFilter {
+	return &events_pb2.EventFilter{
+		Key:         "address",
+		MatchString: Namespace + ".*",
+		FilterType:  events_pb2.EventFilter_REGEX_ANY,
+	}
+}
+
+// BlockInfoFilter returns a state delta filter matching the BlockInfo entries of the blocks, see Options.BlockTimes
+func BlockInfoFilter() *events_pb2.EventFilter {
+	return &events_pb2.EventFilter{
+		Key:         "address",
+		MatchString: "^" + blockInfoPrefix,
+		FilterType:  events_pb2.EventFilter_REGEX_ANY,
+	}
+}
+
+// NewSubscriptions returns subscriptions to the event types with the filters. Unscoped filters apply to every event
+// type but the block commit, whose attributes describe the block. All filters of a subscription must match.
+func NewSubscriptions(eventTypes []string, filters []*ScopedFilter) []*events_pb2.EventSubscription {
+	subscriptions := make([]*events_pb2.EventSubscription, 0, len(eventTypes))
+	for _, t := range eventTypes {
+		subscription := &events_pb2.EventSubscription{EventType: t}
+		for _, f := range filters {
+			if f.EventType == t || (f.EventType == "" && t != BlockCommitEventType) {
+				subscription.Filters = append(subscription.Filters, f.Filter)
+			}
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions
+}
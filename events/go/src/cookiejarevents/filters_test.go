@@ -0,0 +1,124 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"testing"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+)
+
+// bake returns a bake event with the attributes, given as key value pairs
+func bake(kv ...string) *Event {
+	e := &Event{EventType: BakeEventType}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e.Attributes = append(e.Attributes, Attribute{Key: kv[i], Value: kv[i+1]})
+	}
+
+	return e
+}
+
+func mustParse(t *testing.T, s string) *ScopedFilter {
+	t.Helper()
+	f, err := ParseFilter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestParseFilter(t *testing.T) {
+	f := mustParse(t, "bake@regex-all:cookies-baked=^[0-9]+$")
+	if f.EventType != BakeEventType || f.Filter.FilterType != events_pb2.EventFilter_REGEX_ALL ||
+		f.Filter.Key != "cookies-baked" || f.Filter.MatchString != "^[0-9]+$" {
+		t.Fatalf("got %s %+v", f.EventType, f.Filter)
+	}
+
+	// The filter type defaults to simple-any and the event type to every subscription
+	f = mustParse(t, "address=a4d219")
+	if f.EventType != "" || f.Filter.FilterType != events_pb2.EventFilter_SIMPLE_ANY || f.Filter.Key != "address" {
+		t.Fatalf("got %s %+v", f.EventType, f.Filter)
+	}
+
+	for _, s := range []string{"cookies-baked", "=10", "cake@cookies-baked=10", "regex-any:cookies-baked=("} {
+		if _, err := ParseFilter(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		filter string
+		event  *Event
+		want   bool
+	}{
+		{"cookies-baked=10", bake("cookies-baked", "10"), true},
+		{"cookies-baked=10", bake("cookies-baked", "1"), false},
+		{"cookies-baked=10", bake(), false},
+		{"simple-any:k=a", bake("k", "b", "k", "a"), true},
+		{"simple-all:k=a", bake("k", "b", "k", "a"), false},
+		{"simple-all:k=a", bake("k", "a", "k", "a"), true},
+		{"simple-all:k=a", bake(), true},
+		{"regex-any:cookies-baked=^[0-9]{2,}$", bake("cookies-baked", "12"), true},
+		{"regex-any:cookies-baked=^[0-9]{2,}$", bake("cookies-baked", "2"), false},
+		{"regex-all:k=^a", bake("k", "ab", "k", "ba"), false},
+		// Filters scoped to another event type don't apply
+		{"eat@cookies-ate=10", bake("cookies-baked", "1"), true},
+		{"bake@cookies-baked=10", bake("cookies-baked", "1"), false},
+	} {
+		if got := mustParse(t, c.filter).Match(c.event); got != c.want {
+			t.Errorf("%s on %+v: got %v, want %v", c.filter, c.event.Attributes, got, c.want)
+		}
+	}
+}
+
+func TestMatchBuiltFilters(t *testing.T) {
+	// Filters built without ParseFilter compile their regular expression on first use
+	f := &ScopedFilter{Filter: AddressFilter(JarAddress("02ab"), JarAddress("02cd"))}
+	if !f.Match(bake("address", JarAddress("02cd"))) || f.Match(bake("address", JarAddress("02ef"))) {
+		t.Fatal("the address filter doesn't match the addresses")
+	}
+	if f := (&ScopedFilter{Filter: NamespaceFilter()}); !f.Match(bake("address", JarAddress("02ab"))) {
+		t.Fatal("the namespace filter doesn't match a jar")
+	}
+}
+
+func TestEventAttribute(t *testing.T) {
+	e := bake("cookies-baked", "3", "cookies-baked", "4")
+	if v, ok := e.Attribute("cookies-baked"); !ok || v != "3" {
+		t.Fatalf("got %q, %v, want the first amount", v, ok)
+	}
+	if _, ok := e.Attribute("cookies-ate"); ok {
+		t.Fatal("got a missing attribute")
+	}
+}
+
+func TestNewSubscriptions(t *testing.T) {
+	filters := []*ScopedFilter{mustParse(t, "owner=x"), mustParse(t, "bake@cookies-baked=10")}
+	subscriptions := NewSubscriptions([]string{BakeEventType, EatEventType, BlockCommitEventType}, filters)
+
+	counts := map[string]int{}
+	for _, s := range subscriptions {
+		counts[s.EventType] = len(s.Filters)
+	}
+	if counts[BakeEventType] != 2 || counts[EatEventType] != 1 || counts[BlockCommitEventType] != 0 {
+		t.Fatalf("got filter counts %v", counts)
+	}
+}
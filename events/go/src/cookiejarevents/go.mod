@@ -0,0 +1,14 @@
+module cookiejarevents
+
+go 1.20
+
+require (
+	github.com/golang/protobuf v1.4.3
+	github.com/hyperledger/sawtooth-sdk-go v0.1.4
+	github.com/pebbe/zmq4 v1.2.5
+)
+
+require (
+	github.com/satori/go.uuid v1.2.0 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+)
@@ -0,0 +1,449 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/messaging"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_event_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/network_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// requestTimeout is how long to wait for the validator to answer a request
+const requestTimeout = 10 * time.Second
+
+// pollInterval is how often the listener wakes up to check the context and answer pings
+const pollInterval = 500 * time.Millisecond
+
+// errStopped is returned when the context is cancelled
+var errStopped = errors.New("Stopped")
+
+// errTimeout is returned when the validator doesn't send anything in time
+var errTimeout = errors.New("Timeout")
+
+// fatalError is an error which reconnecting doesn't fix
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+// validatorConnection is the connection to the validator
+type validatorConnection interface {
+	SendNewMsg(t validator_pb2.Message_MessageType, c []byte) (string, error)
+	SendMsg(t validator_pb2.Message_MessageType, c []byte, corrId string) error
+	RecvMsg() (string, *validator_pb2.Message, error)
+	Close()
+	// poll waits up to timeout for a message and returns whether one can be received
+	poll(timeout time.Duration) (bool, error)
+}
+
+// zmqConnection is a connection to the validator over a ZMQ DEALER socket
+type zmqConnection struct {
+	*messaging.ZmqConnection
+	poller *zmq.Poller
+}
+
+// dialValidator connects to the validator at the url
+func dialValidator(context *zmq.Context, url string) (validatorConnection, error) {
+	connection, err := messaging.NewConnection(context, zmq.DEALER, url, false)
+	if err != nil {
+		return nil, err
+	}
+	poller := zmq.NewPoller()
+	poller.Add(connection.Socket(), zmq.POLLIN)
+
+	return &zmqConnection{ZmqConnection: connection, poller: poller}, nil
+}
+
+func (c *zmqConnection) poll(timeout time.Duration) (bool, error) {
+	polled, err := c.poller.Poll(timeout)
+	return len(polled) > 0, err
+}
+
+// listener receives the events of the validator and reconnects when the connection is lost
+type listener struct {
+	opts          Options
+	subscriptions []*events_pb2.EventSubscription
+	cp            *checkpoint
+	ctx           context.Context
+	events        chan<- CookiejarEvent
+
+	shuttingDown bool // the listener is unsubscribing, so the context isn't checked anymore
+
+	dial       func() (validatorConnection, error)
+	connection validatorConnection
+	subscribed bool
+	pending    []*validator_pb2.Message // messages received while answering pings, handled before new ones
+}
+
+// connect opens a new connection to the validator
+func (l *listener) connect() error {
+	connection, err := l.dial()
+	if err != nil {
+		return err
+	}
+	l.connection = connection
+	l.subscribed = false
+	l.pending = nil
+
+	return nil
+}
+
+// close closes the connection to the validator, if any
+func (l *listener) close() {
+	if l.connection != nil {
+		l.connection.Close()
+		l.connection = nil
+	}
+}
+
+// stopping returns whether the context was cancelled
+func (l *listener) stopping() bool {
+	if l.shuttingDown {
+		return false
+	}
+
+	select {
+	case <-l.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// recv waits up to timeout for the next message of the validator. Ping requests are answered and extend the
+// timeout, since they show the connection is alive.
+func (l *listener) recv(timeout time.Duration) (*validator_pb2.Message, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if l.stopping() {
+			return nil, errStopped
+		}
+		if len(l.pending) > 0 {
+			message := l.pending[0]
+			l.pending = l.pending[1:]
+			return message, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, errTimeout
+		}
+		// Wake up regularly to check the context
+		if remaining > pollInterval {
+			remaining = pollInterval
+		}
+
+		ready, err := l.connection.poll(remaining)
+		if err != nil {
+			return nil, err
+		}
+		if !ready {
+			continue
+		}
+
+		_, message, err := l.connection.RecvMsg()
+		if err != nil {
+			return nil, err
+		}
+		if message.MessageType == validator_pb2.Message_PING_REQUEST {
+			if err := l.answerPing(message); err != nil {
+				return nil, err
+			}
+			deadline = time.Now().Add(timeout)
+			continue
+		}
+
+		return message, nil
+	}
+}
+
+// answerPing answers the ping request of the validator
+func (l *listener) answerPing(message *validator_pb2.Message) error {
+	data, err := proto.Marshal(&network_pb2.PingResponse{})
+	if err != nil {
+		return err
+	}
+
+	return l.connection.SendMsg(validator_pb2.Message_PING_RESPONSE, data, message.CorrelationId)
+}
+
+// answerPings answers the pings already received while the consumer holds an event, so the validator doesn't drop
+// the connection. Other messages are kept for recv.
+func (l *listener) answerPings() error {
+	if l.connection == nil {
+		return nil
+	}
+
+	for {
+		ready, err := l.connection.poll(0)
+		if err != nil || !ready {
+			return err
+		}
+		_, message, err := l.connection.RecvMsg()
+		if err != nil {
+			return err
+		}
+		if message.MessageType != validator_pb2.Message_PING_REQUEST {
+			l.pending = append(l.pending, message)
+			continue
+		}
+		if err := l.answerPing(message); err != nil {
+			return err
+		}
+	}
+}
+
+// request sends the request to the validator and decodes its response. Events received in the meantime are
+// dropped, they are sent again when resubscribing.
+func (l *listener) request(t validator_pb2.Message_MessageType, request, response proto.Message) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	corrId, err := l.connection.SendNewMsg(t, data)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(requestTimeout)
+	for {
+		message, err := l.recv(time.Until(deadline))
+		if err != nil {
+			return err
+		}
+		if message.CorrelationId != corrId {
+			l.opts.Logf("Ignoring %v message while waiting for a response", message.MessageType)
+			continue
+		}
+
+		return proto.Unmarshal(message.Content, response)
+	}
+}
+
+// sendSubscribeRequest sends a subscription request resuming after the provided block, the current head if empty, and
+// returns the status of the subscription
+func (l *listener) sendSubscribeRequest(lastKnownBlockID string) (client_event_pb2.ClientEventsSubscribeResponse_Status, error) {
+	request := client_event_pb2.ClientEventsSubscribeRequest{
+		Subscriptions: l.subscriptions,
+	}
+	if lastKnownBlockID != "" {
+		request.LastKnownBlockIds = []string{lastKnownBlockID}
+	}
+
+	eventSubscribeResponse := client_event_pb2.ClientEventsSubscribeResponse{}
+	if err := l.request(validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST, &request, &eventSubscribeResponse); err != nil {
+		return 0, err
+	}
+
+	return eventSubscribeResponse.Status, nil
+}
+
+// subscribe subscribes to the events after the newest block of the checkpoint. Blocks the validator doesn't know,
+// for instance because they were orphaned by a fork, are walked back until a known one is found. Without a known
+// block, the subscription starts at the current head and events may have been missed.
+func (l *listener) subscribe() error {
+	for _, id := range append(l.cp.knownBlockIds(), "") {
+		if id == "" && len(l.cp.Blocks) > 0 {
+			l.opts.Logf("No block of the checkpoint is known, events since the last processed block are lost")
+		}
+
+		status, err := l.sendSubscribeRequest(id)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case client_event_pb2.ClientEventsSubscribeResponse_OK:
+			if id != "" {
+				l.opts.Logf("Resuming after block %s", id)
+			}
+			l.subscribed = true
+			return nil
+		case client_event_pb2.ClientEventsSubscribeResponse_UNKNOWN_BLOCK:
+			l.opts.Logf("Block %s is unknown to the validator, walking back", id)
+		default:
+			return &fatalError{fmt.Errorf("Client couldn't subscribe successfully: %v", status)}
+		}
+	}
+
+	return &fatalError{errors.New("Client couldn't subscribe successfully")}
+}
+
+// unsubscribe ends the subscription
+func (l *listener) unsubscribe() error {
+	unSubscribeRequest := client_event_pb2.ClientEventsUnsubscribeRequest{}
+	eventUnsubscribeResponse := client_event_pb2.ClientEventsUnsubscribeResponse{}
+	// The context is already cancelled, so wait for the response regardless
+	l.shuttingDown = true
+	if err := l.request(validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_REQUEST, &unSubscribeRequest,
+		&eventUnsubscribeResponse); err != nil {
+		return err
+	}
+	if eventUnsubscribeResponse.Status !=
+		client_event_pb2.ClientEventsUnsubscribeResponse_OK {
+		return errors.New("Client couldn't unsubscribe successfully")
+	}
+	l.subscribed = false
+
+	return nil
+}
+
+// deliver sends the event to the consumer, unless the context is cancelled. With Options.ManualAck, it then waits
+// until the consumer acked the event. Pings are answered meanwhile, as the consumer may take a while.
+func (l *listener) deliver(event *CookiejarEvent) error {
+	var ack chan struct{}
+	if l.opts.ManualAck {
+		ack = make(chan struct{}, 1)
+		event.ack = ack
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	events := l.events
+	for {
+		select {
+		case events <- *event:
+			if ack == nil {
+				return nil
+			}
+			// Only wait for the ack from now on
+			events = nil
+		case <-ack:
+			return nil
+		case <-l.ctx.Done():
+			return errStopped
+		case <-ticker.C:
+			if err := l.answerPings(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleEvents delivers the committed block and the blocks it orphaned, and advances the checkpoint
+func (l *listener) handleEvents(message *validator_pb2.Message) error {
+	eventList := events_pb2.EventList{}
+	if err := proto.Unmarshal(message.Content, &eventList); err != nil {
+		l.opts.Logf("Ignoring undecodable events: %v", err)
+		return nil
+	}
+	record, err := decodeEvents(eventList.Events, l.opts.Owners)
+	if err != nil {
+		l.opts.Logf("Ignoring undecodable events: %v", err)
+		return nil
+	}
+
+	// Report the blocks orphaned by a fork before the block replacing them. A copy of the checkpoint is advanced and
+	// only replaces it once all of them are delivered, or acked with Options.ManualAck, so a consumer stopped in
+	// between receives them again, also after reconnecting.
+	next := l.cp.clone()
+	rollbacks, duplicate := next.advance(record)
+	if duplicate {
+		return nil
+	}
+	for _, r := range append(rollbacks, record) {
+		if err := l.deliver(r); err != nil {
+			return err
+		}
+	}
+	if err := next.save(); err != nil {
+		return &fatalError{fmt.Errorf("Failed to save checkpoint: %v", err)}
+	}
+	l.cp = next
+
+	return nil
+}
+
+// listen handles the events until the connection is lost or the context is cancelled
+func (l *listener) listen() error {
+	l.opts.Logf("Listening to events.")
+	for {
+		message, err := l.recv(l.opts.IdleTimeout)
+		if err == errTimeout {
+			return fmt.Errorf("Nothing received from the validator for %v", l.opts.IdleTimeout)
+		} else if err != nil {
+			return err
+		}
+
+		switch message.MessageType {
+		case validator_pb2.Message_CLIENT_EVENTS:
+			if err := l.handleEvents(message); err != nil {
+				return err
+			}
+		default:
+			l.opts.Logf("Ignoring unexpected %v message", message.MessageType)
+		}
+	}
+}
+
+// wait sleeps for the delay, unless the context is cancelled
+func (l *listener) wait(delay time.Duration) bool {
+	select {
+	case <-l.ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// run listens to the events and reconnects with an exponential backoff whenever the connection is lost, resuming
+// after the last processed block. When the context is cancelled, it unsubscribes before returning.
+func (l *listener) run() error {
+	delay := l.opts.MinReconnectDelay
+	for {
+		err := l.connect()
+		if err == nil {
+			if err = l.subscribe(); err == nil {
+				delay = l.opts.MinReconnectDelay
+				err = l.listen()
+			}
+		}
+
+		if err == errStopped {
+			if l.subscribed {
+				if err := l.unsubscribe(); err != nil {
+					l.opts.Logf("Failed to unsubscribe: %v", err)
+				}
+			}
+			l.close()
+			return nil
+		}
+		l.close()
+		if _, ok := err.(*fatalError); ok {
+			return err
+		}
+
+		l.opts.Logf("Connection to %s lost: %v, reconnecting in %v", l.opts.ValidatorURL, err, delay)
+		if !l.wait(delay) {
+			return nil
+		}
+		if delay *= 2; delay > l.opts.MaxReconnectDelay {
+			delay = l.opts.MaxReconnectDelay
+		}
+	}
+}
@@ -0,0 +1,338 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package cookiejarevents
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_event_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+)
+
+// discardLogs drops the logs of the listener
+func discardLogs(format string, args ...interface{}) {}
+
+// fakeValidator is a connection to a validator played by the test. The messages sent to incoming are received by
+// the listener and closing it drops the connection.
+type fakeValidator struct {
+	incoming chan *validator_pb2.Message
+	sent     chan *validator_pb2.Message
+	next     *validator_pb2.Message
+	corrId   int
+}
+
+func newFakeValidator() *fakeValidator {
+	return &fakeValidator{incoming: make(chan *validator_pb2.Message, 10), sent: make(chan *validator_pb2.Message, 10)}
+}
+
+func (v *fakeValidator) SendNewMsg(t validator_pb2.Message_MessageType, c []byte) (string, error) {
+	v.corrId++
+	corrId := strconv.Itoa(v.corrId)
+
+	return corrId, v.SendMsg(t, c, corrId)
+}
+
+func (v *fakeValidator) SendMsg(t validator_pb2.Message_MessageType, c []byte, corrId string) error {
+	v.sent <- &validator_pb2.Message{MessageType: t, Content: c, CorrelationId: corrId}
+	return nil
+}
+
+func (v *fakeValidator) RecvMsg() (string, *validator_pb2.Message, error) {
+	message := v.next
+	v.next = nil
+
+	return "", message, nil
+}
+
+func (v *fakeValidator) Close() {}
+
+func (v *fakeValidator) poll(timeout time.Duration) (bool, error) {
+	if v.next != nil {
+		return true, nil
+	}
+
+	select {
+	case message, ok := <-v.incoming:
+		if !ok {
+			return false, errors.New("connection lost")
+		}
+		v.next = message
+		return true, nil
+	case <-time.After(timeout):
+		return false, nil
+	}
+}
+
+// answer decodes the next request of the listener and answers it with the response
+func (v *fakeValidator) answer(t *testing.T, messageType validator_pb2.Message_MessageType,
+	request, response proto.Message) {
+	var message *validator_pb2.Message
+	select {
+	case message = <-v.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request sent")
+	}
+	if message.MessageType != messageType {
+		t.Fatalf("got a %v message, want %v", message.MessageType, messageType)
+	}
+	if err := proto.Unmarshal(message.Content, request); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := proto.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.incoming <- &validator_pb2.Message{MessageType: validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_RESPONSE,
+		Content: data, CorrelationId: message.CorrelationId}
+}
+
+func TestDeliverWaitsForAck(t *testing.T) {
+	events := make(chan CookiejarEvent)
+	l := &listener{opts: Options{ManualAck: true}, ctx: context.Background(), events: events}
+
+	delivered := make(chan error)
+	go func() {
+		delivered <- l.deliver(commit(1, "a", "genesis"))
+	}()
+
+	event := <-events
+	select {
+	case err := <-delivered:
+		t.Fatalf("delivered before the ack: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	event.Ack()
+	event.Ack()
+	select {
+	case err := <-delivered:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not delivered after the ack")
+	}
+}
+
+func TestDeliverStopsWithoutAck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan CookiejarEvent, 1)
+	l := &listener{opts: Options{ManualAck: true}, ctx: ctx, events: events}
+
+	delivered := make(chan error)
+	go func() {
+		delivered <- l.deliver(commit(1, "a", "genesis"))
+	}()
+	<-time.After(20 * time.Millisecond)
+	cancel()
+
+	if err := <-delivered; err != errStopped {
+		t.Fatalf("got %v, want errStopped", err)
+	}
+}
+
+func TestDeliverWithoutManualAck(t *testing.T) {
+	events := make(chan CookiejarEvent, 1)
+	l := &listener{ctx: context.Background(), events: events}
+
+	if err := l.deliver(commit(1, "a", "genesis")); err != nil {
+		t.Fatal(err)
+	}
+	event := <-events
+	event.Ack()
+}
+
+// blockMessage returns the events message of the committed block
+func blockMessage(t *testing.T, num, id, previous string) *validator_pb2.Message {
+	content, err := proto.Marshal(&events_pb2.EventList{Events: []*events_pb2.Event{
+		&events_pb2.Event{EventType: BlockCommitEventType, Attributes: []*events_pb2.Event_Attribute{
+			{Key: "block_num", Value: num},
+			{Key: "block_id", Value: id},
+			{Key: "previous_block_id", Value: previous},
+		}},
+		&events_pb2.Event{EventType: StateDeltaEventType},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &validator_pb2.Message{MessageType: validator_pb2.Message_CLIENT_EVENTS, Content: content}
+}
+
+func TestHandleEventsAdvancesAfterDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan CookiejarEvent)
+	l := &listener{opts: Options{ManualAck: true}, cp: chain(), ctx: ctx, events: events}
+
+	// The consumer stops after acking the rollback of the fork but before acking the block replacing it
+	handled := make(chan error)
+	go func() {
+		handled <- l.handleEvents(blockMessage(t, "3", "d", "b"))
+	}()
+	if event := <-events; event.Type != BlockRollback || event.BlockID != "c" {
+		t.Fatalf("got %s of %s, want the rollback of c", event.Type, event.BlockID)
+	} else {
+		event.Ack()
+	}
+	<-events
+	cancel()
+	if err := <-handled; err != errStopped {
+		t.Fatalf("got %v, want errStopped", err)
+	}
+	if ids := l.cp.knownBlockIds(); len(ids) != 3 || ids[0] != "c" {
+		t.Fatalf("got blocks %v, want the checkpoint untouched", ids)
+	}
+
+	// Both are delivered again once the consumer is back
+	l.ctx = context.Background()
+	go func() {
+		handled <- l.handleEvents(blockMessage(t, "3", "d", "b"))
+	}()
+	for _, want := range []string{"c", "d"} {
+		event := <-events
+		if event.BlockID != want {
+			t.Fatalf("got %s, want %s", event.BlockID, want)
+		}
+		event.Ack()
+	}
+	if err := <-handled; err != nil {
+		t.Fatal(err)
+	}
+	if ids := l.cp.knownBlockIds(); len(ids) != 3 || ids[0] != "d" || ids[1] != "b" {
+		t.Fatalf("got blocks %v, want d after b", ids)
+	}
+}
+
+func TestRecvAnswersPings(t *testing.T) {
+	v := newFakeValidator()
+	l := &listener{ctx: context.Background(), connection: v}
+
+	v.incoming <- &validator_pb2.Message{MessageType: validator_pb2.Message_PING_REQUEST, CorrelationId: "ping"}
+	v.incoming <- blockMessage(t, "1", "a", "genesis")
+	message, err := l.recv(time.Second)
+	if err != nil || message.MessageType != validator_pb2.Message_CLIENT_EVENTS {
+		t.Fatalf("got %v, %v, want the events", message, err)
+	}
+	if pong := <-v.sent; pong.MessageType != validator_pb2.Message_PING_RESPONSE || pong.CorrelationId != "ping" {
+		t.Fatalf("got %v, want the response to the ping", pong)
+	}
+
+	// Nothing else is received
+	if _, err := l.recv(10 * time.Millisecond); err != errTimeout {
+		t.Fatalf("got %v, want errTimeout", err)
+	}
+}
+
+func TestSubscribeWalksBackUnknownBlocks(t *testing.T) {
+	v := newFakeValidator()
+	l := &listener{opts: Options{Logf: discardLogs}, cp: chain(), ctx: context.Background(), connection: v}
+
+	subscribed := make(chan error)
+	go func() {
+		subscribed <- l.subscribe()
+	}()
+
+	// c was orphaned while the client was down, so it resumes after b
+	for _, c := range []struct {
+		block  string
+		status client_event_pb2.ClientEventsSubscribeResponse_Status
+	}{
+		{"c", client_event_pb2.ClientEventsSubscribeResponse_UNKNOWN_BLOCK},
+		{"b", client_event_pb2.ClientEventsSubscribeResponse_OK},
+	} {
+		request := &client_event_pb2.ClientEventsSubscribeRequest{}
+		v.answer(t, validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST, request,
+			&client_event_pb2.ClientEventsSubscribeResponse{Status: c.status})
+		if !reflect.DeepEqual(request.LastKnownBlockIds, []string{c.block}) {
+			t.Fatalf("got last known blocks %v, want %s", request.LastKnownBlockIds, c.block)
+		}
+	}
+	if err := <-subscribed; err != nil || !l.subscribed {
+		t.Fatalf("got %v, want a subscription", err)
+	}
+}
+
+func TestRunReconnectsAndUnsubscribes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan CookiejarEvent)
+	first, second := newFakeValidator(), newFakeValidator()
+	connections := []*fakeValidator{first, second}
+	l := &listener{
+		opts: Options{
+			IdleTimeout:       time.Minute,
+			MinReconnectDelay: 10 * time.Millisecond,
+			MaxReconnectDelay: 10 * time.Millisecond,
+			Logf:              discardLogs,
+		},
+		cp:     &checkpoint{},
+		ctx:    ctx,
+		events: events,
+		dial: func() (validatorConnection, error) {
+			connection := connections[0]
+			connections = connections[1:]
+			return connection, nil
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- l.run()
+	}()
+
+	// The first subscription starts at the head, then a block is committed and the connection is lost
+	request := &client_event_pb2.ClientEventsSubscribeRequest{}
+	first.answer(t, validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST, request,
+		&client_event_pb2.ClientEventsSubscribeResponse{Status: client_event_pb2.ClientEventsSubscribeResponse_OK})
+	if len(request.LastKnownBlockIds) != 0 {
+		t.Fatalf("got last known blocks %v, want none", request.LastKnownBlockIds)
+	}
+	first.incoming <- blockMessage(t, "1", "a", "genesis")
+	if event := <-events; event.BlockID != "a" {
+		t.Fatalf("got %s, want a", event.BlockID)
+	}
+	close(first.incoming)
+
+	// The second one resumes after the block
+	second.answer(t, validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST, request,
+		&client_event_pb2.ClientEventsSubscribeResponse{Status: client_event_pb2.ClientEventsSubscribeResponse_OK})
+	if !reflect.DeepEqual(request.LastKnownBlockIds, []string{"a"}) {
+		t.Fatalf("got last known blocks %v, want a", request.LastKnownBlockIds)
+	}
+
+	// Cancelling the context unsubscribes
+	cancel()
+	second.answer(t, validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_REQUEST,
+		&client_event_pb2.ClientEventsUnsubscribeRequest{},
+		&client_event_pb2.ClientEventsUnsubscribeResponse{Status: client_event_pb2.ClientEventsUnsubscribeResponse_OK})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped")
+	}
+}
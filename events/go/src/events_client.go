@@ -18,11 +18,23 @@
 /**
 Sample Sawtooth event client
 To run, start the validator then type the following on the command line:
-	go run events_client.go
+	go run events_client.go [-format text|json] [-keys <dir>] [-checkpoint <file>]
+		[-events <type,...>] [-owner <public key>] [-jar <address>] [-filter <filter>]
+		[-webhooks <file>] [-gateway <address>] [-rules <file>]
+	go run events_client.go -receive <address> -secret <secret> [-fail <count>] [-fail-status <status>]
 Note: If you're using docker-compose file default IP is already set.
 Otherwise, please set global environment variable as
 VALIDATOR_URL="tcp://<VALIDATOR-IP>:4004"
 
+With -webhooks the client forwards the bake and eat events to the HTTP endpoints
+of the webhooks file instead of printing them, and -receive serves a local
+endpoint printing the deliveries, to try them out. With -gateway the client
+serves the events to browsers over Server-Sent Events and websockets. With
+-rules the client raises alerts on the jar balances.
+
+The subscription itself is handled by the cookiejarevents package, which other
+programs can import to consume the cookiejar events.
+
 For more information, see
 https://sawtooth.hyperledger.org/docs/core/releases/latest/app_developers_guide/event_subscriptions.html
 */
@@ -30,153 +42,340 @@ https://sawtooth.hyperledger.org/docs/core/releases/latest/app_developers_guide/
 package main
 
 import (
-	"errors"
+	"context"
+	"cookiejarevents"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"github.com/golang/protobuf/proto"
-	"github.com/hyperledger/sawtooth-sdk-go/messaging"
-	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_event_pb2"
-	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
-	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
-	zmq "github.com/pebbe/zmq4"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
-)
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
-const (
-	DEFAULT_VALIDATOR_URL = "tcp://validator:4004"
-	// Calculated from the 1st 6 characters of SHA-512("cookiejar"):
-	COOKIEJAR_TP_ADDRESS_PREFIX = "a4d219"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
 )
 
-// Global variable for remembering validator URL
-var validatorToConnet = DEFAULT_VALIDATOR_URL
+// stringList is a flag which can be repeated
+type stringList []string
 
-// Get URL from environment if present
-func init() {
-	validatorUrl := os.Getenv("VALIDATOR_URL")
-	if validatorUrl != "" {
-		validatorToConnet = validatorUrl
-	}
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
 
-func listenToEvents(filters []*events_pb2.EventFilter) error {
-	// Listen to cookiejar state-delta events.
-	// Create a connection with validator for that
-	zmqType := zmq.DEALER
-	zmqContext, err := zmq.NewContext()
-	if err != nil {
-		return err
-	}
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
 
-	zmqConnection, err := messaging.NewConnection(zmqContext, zmqType, validatorToConnet, false)
-	// Remember to close the connection when either not needed or error occurs
-	if err != nil {
-		return err
+// newSubscriptions returns the subscriptions selected by the flags. State deltas are always limited to the
+// cookiejar namespace, and to the jars of the owners and addresses if any.
+func newSubscriptions(events string, owners, jars, filters []string) ([]*events_pb2.EventSubscription, error) {
+	var eventTypes []string
+	for _, name := range strings.Split(events, ",") {
+		t, err := cookiejarevents.ParseEventType(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		eventTypes = append(eventTypes, t)
 	}
-	defer zmqConnection.Close()
 
-	// Subscribe to events
-	blockCommitSubscription := events_pb2.EventSubscription{
-		EventType: "sawtooth/block-commit",
+	scoped := []*cookiejarevents.ScopedFilter{&cookiejarevents.ScopedFilter{
+		EventType: cookiejarevents.StateDeltaEventType,
+		Filter:    cookiejarevents.NamespaceFilter(),
+	}}
+	addresses := append([]string{}, jars...)
+	for _, owner := range owners {
+		addresses = append(addresses, cookiejarevents.JarAddress(owner))
 	}
-	stateDeltaSubscription := events_pb2.EventSubscription{
-		EventType: "sawtooth/state-delta",
-		Filters:   filters,
+	if len(addresses) > 0 {
+		scoped = append(scoped, &cookiejarevents.ScopedFilter{
+			EventType: cookiejarevents.StateDeltaEventType,
+			Filter:    cookiejarevents.AddressFilter(addresses...),
+		})
 	}
-	request := client_event_pb2.ClientEventsSubscribeRequest{
-		Subscriptions: []*events_pb2.EventSubscription{
-			&blockCommitSubscription,
-			&stateDeltaSubscription,
-		},
+	for _, s := range filters {
+		f, err := cookiejarevents.ParseFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		scoped = append(scoped, f)
 	}
-	serializedRequest, err := proto.Marshal(&request)
-	if err != nil {
-		return err
+
+	return cookiejarevents.NewSubscriptions(eventTypes, scoped), nil
+}
+
+// withEventTypes adds the event types missing from the comma separated list of event types
+func withEventTypes(events string, names ...string) string {
+	listed := map[string]bool{}
+	for _, name := range strings.Split(events, ",") {
+		if t, err := cookiejarevents.ParseEventType(strings.TrimSpace(name)); err == nil {
+			listed[t] = true
+		}
+	}
+	for _, name := range names {
+		if !listed[cookiejarevents.EventTypes[name]] {
+			events += "," + name
+		}
 	}
 
-	// Send the subscription request
-	corrId, err := zmqConnection.SendNewMsg(
-		validator_pb2.Message_CLIENT_EVENTS_SUBSCRIBE_REQUEST,
-		serializedRequest,
-	)
-	if err != nil {
+	return events
+}
+
+// writeFileAtomic writes the data to a temporary file which replaces the file, so a crash never leaves it truncated
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
 		return err
 	}
-	// Wait for subscription status
-	_, response, err := zmqConnection.RecvMsgWithId(corrId)
-	if err != nil {
-		return err
+
+	return os.Rename(tmp, path)
+}
+
+// writeEvent prints the event as human readable text or as a JSON line
+func writeEvent(w io.Writer, event *cookiejarevents.CookiejarEvent, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(event)
 	}
-	eventSubscribeResponse := client_event_pb2.ClientEventsSubscribeResponse{}
-	err = proto.Unmarshal(response.Content, &eventSubscribeResponse)
-	if err != nil {
+
+	if event.Type == cookiejarevents.BlockRollback {
+		_, err := fmt.Fprintf(w, "Rollback of block %d %s, orphaned by a fork\n", event.BlockNum, event.BlockID)
 		return err
 	}
-	if eventSubscribeResponse.Status !=
-		client_event_pb2.ClientEventsSubscribeResponse_OK {
-		return errors.New("Client couldn't subscribe successfully")
+
+	fmt.Fprintf(w, "Block %d %s (state root %s): %d jar changes\n", event.BlockNum, event.BlockID, event.StateRootHash,
+		len(event.Changes))
+	for _, jar := range event.Changes {
+		owner := "unknown owner"
+		if jar.KeyName != "" {
+			owner = fmt.Sprintf("owned by %s", jar.KeyName)
+		} else if jar.Owner != "" {
+			owner = fmt.Sprintf("owned by %s", jar.Owner)
+		}
+
+		switch {
+		case jar.Deleted:
+			fmt.Fprintf(w, "  %s (%s): deleted\n", jar.Address, owner)
+		case jar.Balance != nil:
+			fmt.Fprintf(w, "  %s (%s): %d cookies\n", jar.Address, owner, *jar.Balance)
+		default:
+			fmt.Fprintf(w, "  %s (%s): invalid value %q\n", jar.Address, owner, jar.Data)
+		}
+	}
+	for _, e := range event.Events {
+		attributes := make([]string, 0, len(e.Attributes))
+		for _, a := range e.Attributes {
+			attributes = append(attributes, fmt.Sprintf("%s=%s", a.Key, a.Value))
+		}
+		fmt.Fprintf(w, "  event %s: %s\n", e.EventType, strings.Join(attributes, " "))
 	}
 
-	// Listen for events in an infinite loop
-	println("Listening to events.")
-	for {
-		_, message, err := zmqConnection.RecvMsg()
+	return nil
+}
+
+func main() {
+	// Entry point function for the client CLI.
+	format := flag.String("format", "text", "output format: text or json (one record per line)")
+	keys := flag.String("keys", filepath.Join(os.Getenv("HOME"), ".sawtooth", "keys"),
+		"directory of the public keys used to name the jar owners")
+	checkpointFile := flag.String("checkpoint", "", "file persisting the last processed blocks to resume after a restart")
+	idleTimeout := flag.Duration("idle-timeout", time.Minute, "reconnect when nothing is received for this long")
+	events := flag.String("events", "block-commit,state-delta",
+		"comma separated event types: bake, eat, state-delta, block-commit or full names such as cookiejar/bake")
+	var owners, jars, filters stringList
+	flag.Var(&owners, "owner", "only report the state changes of the jar of this public key, can be repeated")
+	flag.Var(&jars, "jar", "only report the state changes of this jar address, can be repeated")
+	flag.Var(&filters, "filter", "filter of the form [<event type>@][simple-any|simple-all|regex-any|regex-all:]<key>=<value>, "+
+		"applied to all event types but block-commit unless an event type is given, can be repeated")
+	webhooks := flag.String("webhooks", "", "YAML file of the webhook endpoints to forward the bake and eat events to")
+	receive := flag.String("receive", "", "serve a local webhook endpoint on this address printing the deliveries")
+	secret := flag.String("secret", "", "secret verifying the signature of the deliveries received with -receive")
+	fail := flag.Int("fail", 0, "fail the first deliveries received with -receive, to try out the retries")
+	failStatus := flag.Int("fail-status", 503, "status of the deliveries failed with -fail")
+	gatewayAddr := flag.String("gateway", "", "serve the events to browsers on this address over SSE (/events) and websockets (/ws)")
+	gatewayHistory := flag.Int("gateway-history", 1000, "number of events kept for the browsers catching up with Last-Event-ID")
+	rulesFile := flag.String("rules", "", "YAML file of the alert rules evaluated on the jar balances")
+	var origins stringList
+	flag.Var(&origins, "gateway-origin", "origin allowed to connect to the gateway from another site, * allows any, can be repeated")
+	flag.Parse()
+	if *receive != "" {
+		if *secret == "" {
+			fmt.Println("-receive needs the -secret of the endpoint")
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Receiving webhooks on %s\n", *receive)
+		if err := receiveWebhooks(*receive, *secret, *fail, *failStatus); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Printf("Invalid format %q, use text or json\n", *format)
+		os.Exit(1)
+	}
+	logf := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+
+	// The forwarder needs the bake and eat events, and the state deltas to match the endpoints' jars
+	var forward *forwarder
+	if *webhooks != "" {
+		config, err := loadWebhookConfig(*webhooks)
 		if err != nil {
-			return err
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if forward, err = newForwarder(config, logf); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		if message.MessageType != validator_pb2.Message_CLIENT_EVENTS {
-			return errors.New("Unexpected message received, something which is not subscribed")
+		*events = "bake,eat,state-delta"
+	}
+	var gw *gateway
+	if *gatewayAddr != "" {
+		if *gatewayHistory < 1 {
+			fmt.Println("-gateway-history must be at least 1")
+			os.Exit(1)
 		}
-		eventList := events_pb2.EventList{}
-		err = proto.Unmarshal(message.Content, &eventList)
+		gw = newGateway(*gatewayHistory, origins, logf)
+		*events = "bake,eat,state-delta"
+	}
+	var rules *rulesEngine
+	if *rulesFile != "" {
+		config, err := loadRulesConfig(*rulesFile)
 		if err != nil {
-			return err
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if rules, err = newRulesEngine(config, logf); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		println("Received the following events: ----------")
-		for _, event := range eventList.Events {
-			fmt.Printf("Event: %v\n", *event)
+		for _, key := range rules.alerts() {
+			logf("Alert %s is firing", key)
+		}
+		// The rules need the state deltas and the bake and eat events of every jar
+		if forward == nil && gw == nil {
+			*events = "bake,eat,state-delta"
+		} else {
+			*events = withEventTypes(*events, "bake", "eat", "state-delta")
 		}
 	}
-
-	// Unsubscribe from events
-	unSubscribeRequest := client_event_pb2.ClientEventsUnsubscribeRequest{}
-	serializedRequest, err = proto.Marshal(&unSubscribeRequest)
-	if err != nil {
-		return err
+	// The rules credit the bake and eat events of a block to the jar it changed, which takes the state deltas of every
+	// jar: -owner and -jar then select the changes of the received blocks instead of the subscription's
+	var selected *blockFilter
+	subscribedOwners, subscribedJars := owners, jars
+	if rules != nil && (len(owners) > 0 || len(jars) > 0) {
+		selected, _ = newBlockFilter(nil, owners, jars, nil)
+		rules.jars = selected.jars
+		subscribedOwners, subscribedJars = nil, nil
 	}
-	corrId, err = zmqConnection.SendNewMsg(
-		validator_pb2.Message_CLIENT_EVENTS_UNSUBSCRIBE_REQUEST,
-		serializedRequest,
-	)
+	subscriptions, err := newSubscriptions(*events, subscribedOwners, subscribedJars, filters)
 	if err != nil {
-		return err
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	// Wait for status
-	_, unsubscribeResponse, err := zmqConnection.RecvMsgWithId(corrId)
+
+	// Unsubscribe on SIGINT and SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		fmt.Fprintf(os.Stderr, "Received %v, stopping\n", sig)
+		cancel()
+	}()
+
+	received, err := cookiejarevents.Subscribe(ctx, cookiejarevents.Options{
+		Subscriptions: subscriptions,
+		Owners:        cookiejarevents.LoadOwners(*keys),
+		Checkpoint:    *checkpointFile,
+		IdleTimeout:   *idleTimeout,
+		Logf:          logf,
+		ManualAck:     true,
+		BlockTimes:    rules != nil,
+	})
 	if err != nil {
-		return err
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	eventUnsubscribeResponse := client_event_pb2.ClientEventsUnsubscribeResponse{}
-	err = proto.Unmarshal(unsubscribeResponse.Content, &eventUnsubscribeResponse)
-	if err != nil {
-		return err
+
+	delivered := make(chan struct{})
+	if forward != nil {
+		go func() {
+			forward.run(ctx)
+			close(delivered)
+		}()
 	}
-	if eventUnsubscribeResponse.Status !=
-		client_event_pb2.ClientEventsUnsubscribeResponse_OK {
-		return errors.New("Client couldn't unsubscribe successfully")
+	evaluated := make(chan struct{})
+	if rules != nil {
+		go func() {
+			rules.run(ctx)
+			close(evaluated)
+		}()
+	}
+	var server *http.Server
+	if gw != nil {
+		server = &http.Server{Addr: *gatewayAddr, Handler: gw.handler()}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("Error occurred %v\n", err)
+				os.Exit(1)
+			}
+		}()
+		logf("Serving the gateway on %s", *gatewayAddr)
 	}
-	return nil
-}
 
-func main() {
-	// Entry point function for the client CLI.
-	filters := []*events_pb2.EventFilter{&events_pb2.EventFilter{
-		Key:         "address",
-		MatchString: COOKIEJAR_TP_ADDRESS_PREFIX + ".*",
-		FilterType:  events_pb2.EventFilter_REGEX_ANY,
-	}}
-	// To listen to all events, there should not be any filters
-	err := listenToEvents(filters)
-	if err != nil {
-		fmt.Printf("Error occurred %v\n", err)
+	for event := range received {
+		if event.Type == cookiejarevents.SubscriptionError {
+			fmt.Printf("Error occurred %v\n", event.Err)
+			os.Exit(1)
+		}
+		if forward != nil || gw != nil || rules != nil {
+			if rules != nil {
+				if err := rules.observe(&event); err != nil {
+					fmt.Printf("Error occurred %v\n", err)
+					os.Exit(1)
+				}
+			}
+			scoped := &event
+			if selected != nil {
+				scoped = selected.limit(&event)
+			}
+			if forward != nil {
+				if err := forward.enqueue(scoped); err != nil {
+					fmt.Printf("Error occurred %v\n", err)
+					os.Exit(1)
+				}
+			}
+			if gw != nil {
+				gw.publish(scoped)
+			}
+		} else if err := writeEvent(os.Stdout, &event, *format); err != nil {
+			fmt.Printf("Error occurred %v\n", err)
+			os.Exit(1)
+		}
+
+		// The checkpoint only advances past the block once it's queued, evaluated and printed
+		event.Ack()
+	}
+
+	// The subscription is closed once the context is cancelled, pending deliveries stay queued for the next run
+	if forward != nil {
+		<-delivered
+	}
+	if rules != nil {
+		<-evaluated
+	}
+	if gw != nil {
+		gw.close()
+		shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(shutdown)
+		done()
 	}
 }
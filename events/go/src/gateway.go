@@ -0,0 +1,395 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"cookiejarevents"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// gatewayBuffer is the number of events a client may lag behind before it's disconnected. It catches up with
+	// Last-Event-ID after reconnecting.
+	gatewayBuffer = 256
+	// gatewayKeepAlive is the interval of the SSE comments and websocket pings keeping idle connections open
+	gatewayKeepAlive = 30 * time.Second
+	// gatewayWriteTimeout bounds the writes to a client, so a stalled one doesn't block its handler
+	gatewayWriteTimeout = 10 * time.Second
+	// gatewayReset is the type of the event sent to a reconnecting client whose missed events aren't kept
+	gatewayReset = "reset"
+)
+
+// resetPayload tells a reconnecting client that the events it missed can't be replayed, so it should reload the jars
+// rather than wait for them
+type resetPayload struct {
+	Type string `json:"type"`
+}
+
+// gatewayClient is a connected browser with its filter
+type gatewayClient struct {
+	filter  *blockFilter
+	actions bool // whether the client selected actions, so blocks without its events are skipped
+	events  chan *cookiejarevents.CookiejarEvent
+}
+
+// payload returns the payload of the event for the client, or nil if the event doesn't match its filter. Rollbacks
+// are sent to every client, which ignore those of blocks they didn't receive.
+func (c *gatewayClient) payload(event *cookiejarevents.CookiejarEvent) *blockPayload {
+	if event.Type == cookiejarevents.BlockRollback {
+		return &blockPayload{ID: string(event.Type) + ":" + event.BlockID, Type: event.Type, BlockNum: event.BlockNum,
+			BlockID: event.BlockID, PreviousBlockID: event.PreviousBlockID}
+	}
+
+	p := c.filter.apply(event)
+	if (c.actions && len(p.Events) == 0) || (len(p.Events) == 0 && len(p.Changes) == 0) {
+		return nil
+	}
+
+	return p
+}
+
+// gateway fans the events of the subscription out to the browsers connected over SSE and websockets. The last
+// events are kept for the clients reconnecting with the id of the last event they received.
+type gateway struct {
+	mutex    sync.Mutex
+	history  []*cookiejarevents.CookiejarEvent
+	size     int
+	clients  map[*gatewayClient]bool
+	closed   bool
+	origins  map[string]bool // origins allowed to connect, "*" allows any
+	upgrader websocket.Upgrader
+	logf     func(format string, args ...interface{})
+}
+
+// newGateway returns a gateway keeping the last size events, accepting the cross-origin requests of the origins
+func newGateway(size int, origins []string, logf func(format string, args ...interface{})) *gateway {
+	g := &gateway{size: size, clients: map[*gatewayClient]bool{}, origins: map[string]bool{}, logf: logf}
+	for _, o := range origins {
+		g.origins[strings.TrimSuffix(o, "/")] = true
+	}
+	g.upgrader.CheckOrigin = g.checkOrigin
+
+	return g
+}
+
+// checkOrigin accepts the requests without Origin, from the gateway's own host, or from an allowed origin
+func (g *gateway) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || g.origins["*"] || g.origins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// publish keeps the event and queues it to the clients. A client whose buffer is full is disconnected rather than
+// blocking the others.
+func (g *gateway) publish(event *cookiejarevents.CookiejarEvent) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.history = append(g.history, event)
+	if len(g.history) > g.size {
+		g.history = g.history[len(g.history)-g.size:]
+	}
+
+	for c := range g.clients {
+		select {
+		case c.events <- event:
+		default:
+			g.logf("Disconnecting a client lagging %d events behind", gatewayBuffer)
+			delete(g.clients, c)
+			close(c.events)
+		}
+	}
+}
+
+// connect registers a client and returns the events it missed after the event with the id lastEventID. The events
+// after an unknown id, of an event too old or from before a restart of the gateway, aren't kept: nothing is replayed
+// and reset is set instead, since replaying the kept events would repeat those the client already received.
+func (g *gateway) connect(c *gatewayClient, lastEventID string) ([]*cookiejarevents.CookiejarEvent, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	c.events = make(chan *cookiejarevents.CookiejarEvent, gatewayBuffer)
+	if g.closed {
+		close(c.events)
+		return nil, false
+	}
+	g.clients[c] = true
+
+	if lastEventID == "" {
+		return nil, false
+	}
+	for i := len(g.history) - 1; i >= 0; i-- {
+		if string(g.history[i].Type)+":"+g.history[i].BlockID == lastEventID {
+			return append([]*cookiejarevents.CookiejarEvent{}, g.history[i+1:]...), false
+		}
+	}
+
+	return nil, true
+}
+
+// disconnect unregisters a client
+func (g *gateway) disconnect(c *gatewayClient) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.clients[c] {
+		delete(g.clients, c)
+		close(c.events)
+	}
+}
+
+// close disconnects every client, so their handlers return
+func (g *gateway) close() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.closed = true
+	for c := range g.clients {
+		delete(g.clients, c)
+		close(c.events)
+	}
+}
+
+// handler returns the HTTP handler of the gateway
+func (g *gateway) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", g.serveSSE)
+	mux.HandleFunc("/ws", g.serveWebsocket)
+
+	return mux
+}
+
+// newClient returns a client with the filter of the query parameters owner, jar and action, which can be repeated
+func newClient(r *http.Request) (*gatewayClient, error) {
+	q := r.URL.Query()
+	eventTypes := []string{cookiejarevents.BakeEventType, cookiejarevents.EatEventType}
+	if actions := q["action"]; len(actions) > 0 {
+		eventTypes = nil
+		for _, action := range actions {
+			if action != "bake" && action != "eat" {
+				return nil, fmt.Errorf("Invalid action %q, use bake or eat", action)
+			}
+			eventTypes = append(eventTypes, cookiejarevents.EventTypes[action])
+		}
+	}
+
+	filter, err := newBlockFilter(eventTypes, q["owner"], q["jar"], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gatewayClient{filter: filter, actions: len(q["action"]) > 0}, nil
+}
+
+// lastEventID returns the id of the last event received by a reconnecting client, from the Last-Event-ID header
+// sent by EventSource or from the last_event_id query parameter, since browsers can't set websocket headers
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+
+	return r.URL.Query().Get("last_event_id")
+}
+
+// serveSSE streams the events to an EventSource as Server-Sent Events. The connection is hijacked to put a deadline on
+// every write, so a stalled browser doesn't keep its handler blocked once publish dropped it.
+func (g *gateway) serveSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if !g.checkOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c, err := newClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		g.logf("Failed to take over an SSE connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	backlog, reset := g.connect(c, lastEventID(r))
+	defer g.disconnect(c)
+
+	// Read until the browser closes the connection, which the server no longer watches
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		io.Copy(ioutil.Discard, rw.Reader)
+	}()
+
+	// send writes the buffered text with a deadline
+	send := func(format string, args ...interface{}) error {
+		conn.SetWriteDeadline(time.Now().Add(gatewayWriteTimeout))
+		fmt.Fprintf(rw.Writer, format, args...)
+		return rw.Writer.Flush()
+	}
+	write := func(event *cookiejarevents.CookiejarEvent) error {
+		p := c.payload(event)
+		if p == nil {
+			return nil
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return send("id: %s\nevent: %s\ndata: %s\n\n", p.ID, p.Type, b)
+	}
+
+	// The response has no length, it ends when the connection is closed
+	header := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nCache-Control: no-cache\r\nConnection: close\r\n"
+	if origin := r.Header.Get("Origin"); origin != "" {
+		header += fmt.Sprintf("Access-Control-Allow-Origin: %s\r\n", origin)
+	}
+	if err := send("%s\r\nretry: 3000\n\n", header); err != nil {
+		return
+	}
+	if reset {
+		// The empty id clears the id the EventSource sends when it reconnects
+		b, _ := json.Marshal(&resetPayload{Type: gatewayReset})
+		if err := send("id\nevent: %s\ndata: %s\n\n", gatewayReset, b); err != nil {
+			return
+		}
+	}
+	for _, event := range backlog {
+		if err := write(event); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(gatewayKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				return
+			}
+			if err := write(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := send(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// serveWebsocket sends the events to a websocket as JSON text messages
+func (g *gateway) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	c, err := newClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader responded with the error
+		return
+	}
+	defer conn.Close()
+
+	backlog, reset := g.connect(c, lastEventID(r))
+	defer g.disconnect(c)
+
+	// Read until the browser closes the connection, answering its pings and reading its pongs
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(2 * gatewayKeepAlive))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * gatewayKeepAlive))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(event *cookiejarevents.CookiejarEvent) error {
+		p := c.payload(event)
+		if p == nil {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(gatewayWriteTimeout))
+		return conn.WriteJSON(p)
+	}
+	if reset {
+		conn.SetWriteDeadline(time.Now().Add(gatewayWriteTimeout))
+		if err := conn.WriteJSON(&resetPayload{Type: gatewayReset}); err != nil {
+			return
+		}
+	}
+	for _, event := range backlog {
+		if err := write(event); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(gatewayKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := write(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(gatewayWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
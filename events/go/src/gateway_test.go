@@ -0,0 +1,189 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectCatchesUp(t *testing.T) {
+	g := newGateway(2, nil, func(string, ...interface{}) {})
+	for _, id := range []string{"b1", "b2", "b3"} {
+		g.publish(bakeBlock(id))
+	}
+
+	backlog, reset := g.connect(&gatewayClient{}, "commit:b2")
+	if len(backlog) != 1 || backlog[0].BlockID != "b3" || reset {
+		t.Fatalf("got backlog %v, reset %v, want b3", backlog, reset)
+	}
+	if backlog, reset = g.connect(&gatewayClient{}, ""); len(backlog) != 0 || reset {
+		t.Fatalf("got backlog %v, reset %v, want nothing", backlog, reset)
+	}
+	// b1 isn't kept anymore
+	if backlog, reset = g.connect(&gatewayClient{}, "commit:b1"); len(backlog) != 0 || !reset {
+		t.Fatalf("got backlog %v, reset %v, want a reset", backlog, reset)
+	}
+}
+
+// sseStream reads the events of an SSE response
+type sseStream struct {
+	res     *http.Response
+	scanner *bufio.Scanner
+}
+
+// openSSE connects to the gateway's SSE endpoint with the Last-Event-ID
+func openSSE(t *testing.T, url, lastEventID string) *sseStream {
+	req, err := http.NewRequest(http.MethodGet, url+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %s with %s", res.Status, res.Header.Get("Content-Type"))
+	}
+
+	return &sseStream{res: res, scanner: bufio.NewScanner(res.Body)}
+}
+
+// next returns the fields of the next event, skipping the comments and the retry
+func (s *sseStream) next(t *testing.T) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if _, ok := fields["event"]; ok {
+				return fields
+			}
+			fields = map[string]string{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			fields[line] = ""
+			continue
+		}
+		fields[line[:i]] = strings.TrimPrefix(line[i+1:], " ")
+	}
+	t.Fatalf("stream ended: %v", s.scanner.Err())
+
+	return nil
+}
+
+func newTestGateway(t *testing.T) (*gateway, *httptest.Server) {
+	g := newGateway(10, nil, func(string, ...interface{}) {})
+	server := httptest.NewServer(g.handler())
+
+	return g, server
+}
+
+func TestSSEResetsUnknownLastEventID(t *testing.T) {
+	g, server := newTestGateway(t)
+	defer server.Close()
+	defer g.close()
+	g.publish(bakeBlock("b1"))
+
+	stream := openSSE(t, server.URL, "commit:before-restart")
+	defer stream.res.Body.Close()
+
+	reset := stream.next(t)
+	if id, ok := reset["id"]; reset["event"] != gatewayReset || !ok || id != "" ||
+		reset["data"] != `{"type":"reset"}` {
+		t.Fatalf("got %v, want a reset clearing the id", reset)
+	}
+
+	// b1 isn't replayed, the next block is sent
+	g.publish(bakeBlock("b2"))
+	if event := stream.next(t); event["event"] != "commit" || event["id"] != "commit:b2" {
+		t.Fatalf("got %v, want b2", event)
+	}
+}
+
+func TestSSECatchesUp(t *testing.T) {
+	g, server := newTestGateway(t)
+	defer server.Close()
+	defer g.close()
+	for _, id := range []string{"b1", "b2", "b3"} {
+		g.publish(bakeBlock(id))
+	}
+
+	stream := openSSE(t, server.URL, "commit:b1")
+	defer stream.res.Body.Close()
+	for _, id := range []string{"commit:b2", "commit:b3"} {
+		if event := stream.next(t); event["id"] != id || !strings.Contains(event["data"], `"block_id":"`+id[7:]+`"`) {
+			t.Fatalf("got %v, want %s", event, id)
+		}
+	}
+}
+
+func TestSSEEndsWhenGatewayCloses(t *testing.T) {
+	g, server := newTestGateway(t)
+	defer server.Close()
+
+	stream := openSSE(t, server.URL, "")
+	defer stream.res.Body.Close()
+	g.close()
+
+	done := make(chan struct{})
+	go func() {
+		for stream.scanner.Scan() {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream wasn't closed")
+	}
+}
+
+func TestWebsocketResetsUnknownLastEventID(t *testing.T) {
+	g, server := newTestGateway(t)
+	defer server.Close()
+	defer g.close()
+	g.publish(bakeBlock("b1"))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+
+		"/ws?last_event_id=commit:unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var reset resetPayload
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&reset); err != nil || reset.Type != gatewayReset {
+		t.Fatalf("got %+v, %v, want a reset", reset, err)
+	}
+}
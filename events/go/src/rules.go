@@ -0,0 +1,579 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"cookiejarevents"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// The metrics a rule can watch. Baked and eaten are the cookies added to and taken from a jar during the window,
+	// from the bake and eat events of the jar.
+	metricBalance = "balance"
+	metricBaked   = "baked"
+	metricEaten   = "eaten"
+
+	// The states of an alert
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+
+	// balanceDepth is the number of balances kept per jar to undo the blocks orphaned by forks
+	balanceDepth = 10
+	// rulesInterval is how often the rules are evaluated besides every block, so windowed alerts resolve over time
+	rulesInterval = time.Minute
+	// rulesCatchUp is the age from which a block was replayed after a restart rather than just built. The windowed
+	// rules aren't evaluated on replayed blocks, but on the next live block or tick.
+	rulesCatchUp = time.Minute
+)
+
+// ruleAction is an action of a rule, run when an alert fires or resolves
+type ruleAction struct {
+	Type    string        `yaml:"type"`              // log, webhook or exec
+	URL     string        `yaml:"url,omitempty"`     // webhook
+	Secret  string        `yaml:"secret,omitempty"`  // webhook, signs the alerts like the forwarder's deliveries
+	Command []string      `yaml:"command,omitempty"` // exec, run with the alert in the environment and on stdin
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// rule is a rule of the rules file. It applies to the jar of the owner or address, or to every jar if none is set.
+type rule struct {
+	Name           string        `yaml:"name"`
+	Owner          string        `yaml:"owner,omitempty"`
+	Jar            string        `yaml:"jar,omitempty"`
+	Metric         string        `yaml:"metric"`
+	Window         time.Duration `yaml:"window,omitempty"` // for baked and eaten
+	Below          *int          `yaml:"below,omitempty"`
+	Above          *int          `yaml:"above,omitempty"`
+	Message        string        `yaml:"message,omitempty"` // template of the message, with the fields of alert
+	Repeat         time.Duration `yaml:"repeat,omitempty"`  // interval of the reminders while firing, 0 for none
+	NotifyResolved bool          `yaml:"notify_resolved,omitempty"`
+	Actions        []*ruleAction `yaml:"actions"`
+
+	message *template.Template
+}
+
+// rulesConfig is the content of the rules file
+type rulesConfig struct {
+	State string  `yaml:"state"` // file persisting the jars and alerts across restarts
+	Rules []*rule `yaml:"rules"`
+}
+
+// loadRulesConfig reads and validates the rules file
+func loadRulesConfig(file string) (*rulesConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &rulesConfig{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("Invalid rules file %s: %v", file, err)
+	}
+
+	if config.State == "" {
+		config.State = "alerts.state"
+	}
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("No rule in the rules file %s", file)
+	}
+	names := map[string]bool{}
+	for _, r := range config.Rules {
+		if r.Name == "" || names[r.Name] {
+			return nil, fmt.Errorf("Missing or duplicate rule name %q", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.init(); err != nil {
+			return nil, fmt.Errorf("Rule %s: %v", r.Name, err)
+		}
+	}
+
+	return config, nil
+}
+
+// init validates the rule and parses its message
+func (r *rule) init() error {
+	if r.Owner != "" {
+		if r.Jar != "" {
+			return fmt.Errorf("Set either owner or jar")
+		}
+		r.Jar = cookiejarevents.JarAddress(r.Owner)
+	}
+	switch r.Metric {
+	case metricBalance:
+		if r.Window != 0 {
+			return fmt.Errorf("The balance has no window")
+		}
+	case metricBaked, metricEaten:
+		if r.Window <= 0 {
+			return fmt.Errorf("The %s metric needs a window", r.Metric)
+		}
+	default:
+		return fmt.Errorf("Invalid metric %q, use %s, %s or %s", r.Metric, metricBalance, metricBaked, metricEaten)
+	}
+	if (r.Below == nil) == (r.Above == nil) {
+		return fmt.Errorf("Set either below or above")
+	}
+
+	if r.Message == "" {
+		r.Message = `{{.Rule}} {{.State}}: {{.Metric}}{{if .Window}} in {{.Window}}{{end}} of the jar of {{.OwnerName}} ` +
+			`is {{.Value}} (alert {{.Condition}} {{.Threshold}})`
+	}
+	t, err := template.New(r.Name).Parse(r.Message)
+	if err != nil {
+		return fmt.Errorf("Invalid message: %v", err)
+	}
+	r.message = t
+
+	if len(r.Actions) == 0 {
+		r.Actions = []*ruleAction{&ruleAction{Type: "log"}}
+	}
+	for _, a := range r.Actions {
+		if a.Timeout == 0 {
+			a.Timeout = 30 * time.Second
+		}
+		switch a.Type {
+		case "log":
+		case "webhook":
+			if !strings.HasPrefix(a.URL, "http://") && !strings.HasPrefix(a.URL, "https://") {
+				return fmt.Errorf("Invalid webhook URL %q", a.URL)
+			}
+		case "exec":
+			if len(a.Command) == 0 {
+				return fmt.Errorf("The exec action needs a command")
+			}
+		default:
+			return fmt.Errorf("Invalid action %q, use log, webhook or exec", a.Type)
+		}
+	}
+
+	return nil
+}
+
+// check returns whether the value breaks the threshold, with the condition and threshold
+func (r *rule) check(value int) (bool, string, int) {
+	if r.Below != nil {
+		return value < *r.Below, "below", *r.Below
+	}
+
+	return value > *r.Above, "above", *r.Above
+}
+
+// balanceEntry is the balance of a jar after a block, nil if the jar was deleted or holds no cookie count
+type balanceEntry struct {
+	BlockID string `json:"block_id"`
+	Balance *int   `json:"balance"`
+}
+
+// sample is the cookies baked and eaten in a jar in a block, at the time the block was built
+type sample struct {
+	Time    time.Time `json:"time"`
+	BlockID string    `json:"block_id"`
+	Baked   int       `json:"baked,omitempty"`
+	Eaten   int       `json:"eaten,omitempty"`
+}
+
+// jarState is what the rules know about a jar
+type jarState struct {
+	Owner    string         `json:"owner,omitempty"`
+	KeyName  string         `json:"key_name,omitempty"`
+	Balances []balanceEntry `json:"balances"` // oldest first
+	Samples  []sample       `json:"samples,omitempty"`
+}
+
+// balance returns the current balance of the jar
+func (j *jarState) balance() *int {
+	if len(j.Balances) == 0 {
+		return nil
+	}
+
+	return j.Balances[len(j.Balances)-1].Balance
+}
+
+// alert is the state of a rule for a jar, also sent to the actions
+type alert struct {
+	Rule       string    `json:"rule"`
+	State      string    `json:"state"`
+	Jar        string    `json:"jar"`
+	Owner      string    `json:"owner,omitempty"`
+	KeyName    string    `json:"key_name,omitempty"`
+	Metric     string    `json:"metric"`
+	Window     string    `json:"window,omitempty"`
+	Value      int       `json:"value"`
+	Condition  string    `json:"condition"`
+	Threshold  int       `json:"threshold"`
+	Since      time.Time `json:"since"`
+	NotifiedAt time.Time `json:"notified_at"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// OwnerName names the owner of the jar in messages
+func (a *alert) OwnerName() string {
+	switch {
+	case a.KeyName != "":
+		return a.KeyName
+	case a.Owner != "":
+		return a.Owner
+	}
+
+	return a.Jar
+}
+
+// rulesState is persisted in the state file, so alerts aren't repeated and windows survive restarts
+type rulesState struct {
+	Jars   map[string]*jarState `json:"jars"`
+	Alerts map[string]*alert    `json:"alerts"` // by rule name and jar address
+}
+
+// rulesEngine evaluates the rules on every block and every rulesInterval
+type rulesEngine struct {
+	mutex     sync.Mutex
+	rules     []*rule
+	file      string
+	state     rulesState
+	maxWindow time.Duration
+	jars      map[string]bool // jar addresses the rules see, nil for every jar
+	actions   sync.WaitGroup
+	ctx       context.Context // cancelled once run stops, interrupting the running actions
+	stop      context.CancelFunc
+	logf      func(format string, args ...interface{})
+}
+
+// newRulesEngine loads the state file of the rules, if any
+func newRulesEngine(config *rulesConfig, logf func(format string, args ...interface{})) (*rulesEngine, error) {
+	e := &rulesEngine{
+		rules: config.Rules,
+		file:  config.State,
+		state: rulesState{Jars: map[string]*jarState{}, Alerts: map[string]*alert{}},
+		logf:  logf,
+	}
+	e.ctx, e.stop = context.WithCancel(context.Background())
+	for _, r := range config.Rules {
+		if r.Window > e.maxWindow {
+			e.maxWindow = r.Window
+		}
+	}
+
+	b, err := ioutil.ReadFile(config.State)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &e.state); err != nil {
+		return nil, fmt.Errorf("Invalid rules state %s: %v", config.State, err)
+	}
+	if e.state.Jars == nil {
+		e.state.Jars = map[string]*jarState{}
+	}
+	if e.state.Alerts == nil {
+		e.state.Alerts = map[string]*alert{}
+	}
+
+	return e, nil
+}
+
+// save writes the state to the state file
+func (e *rulesEngine) save() error {
+	b, err := json.Marshal(&e.state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(e.file, b)
+}
+
+// observe updates the jars with a committed or orphaned block and evaluates the rules. The windows use the time the
+// block was built at, or the time it was received on networks without BlockInfo.
+func (e *rulesEngine) observe(event *cookiejarevents.CookiejarEvent) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now().UTC()
+	windows := true
+	if event.Type == cookiejarevents.BlockRollback {
+		e.rollback(event.BlockID)
+	} else {
+		at := now
+		if event.Timestamp > 0 {
+			at = time.Unix(event.Timestamp, 0).UTC()
+			windows = now.Sub(at) < rulesCatchUp
+		}
+		actions, attributed := blockActions(event)
+		for _, change := range event.Changes {
+			if e.jars != nil && !e.jars[change.Address] {
+				continue
+			}
+			e.apply(&change, event.BlockID, at, actions[change.Address], attributed)
+		}
+	}
+	e.evaluate(now, windows)
+
+	return e.save()
+}
+
+// blockActions returns the cookies baked and eaten in the block per jar. The bake and eat events don't carry their jar,
+// so they are credited to the jar the block changed: attributed is false if it changed several jars, or if an event has
+// no amount.
+func blockActions(event *cookiejarevents.CookiejarEvent) (map[string]*sample, bool) {
+	s := &sample{}
+	found := false
+	for i := range event.Events {
+		ev := &event.Events[i]
+		var key string
+		switch ev.EventType {
+		case cookiejarevents.BakeEventType:
+			key = "cookies-baked"
+		case cookiejarevents.EatEventType:
+			key = "cookies-ate"
+		default:
+			continue
+		}
+
+		value, _ := ev.Attribute(key)
+		amount, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, false
+		}
+		if ev.EventType == cookiejarevents.BakeEventType {
+			s.Baked += amount
+		} else {
+			s.Eaten += amount
+		}
+		found = true
+	}
+
+	switch {
+	case !found:
+		return nil, true
+	case len(event.Changes) != 1:
+		return nil, false
+	}
+
+	return map[string]*sample{event.Changes[0].Address: s}, true
+}
+
+// apply records the new balance of a jar and the cookies baked and eaten in it, actions, for the windows. Clearing a
+// jar isn't eating. The blocks whose events aren't attributed to a jar fall back to the net change of the balance, for
+// which the first balance seen of a jar is only the baseline.
+func (e *rulesEngine) apply(change *cookiejarevents.JarChange, blockID string, at time.Time, actions *sample,
+	attributed bool) {
+	j, ok := e.state.Jars[change.Address]
+	if !ok {
+		j = &jarState{}
+		e.state.Jars[change.Address] = j
+	}
+	if change.Owner != "" || change.KeyName != "" {
+		j.Owner, j.KeyName = change.Owner, change.KeyName
+	}
+
+	s := sample{Time: at, BlockID: blockID}
+	previous := j.balance()
+	switch {
+	case actions != nil:
+		s.Baked, s.Eaten = actions.Baked, actions.Eaten
+	case !attributed && previous != nil && change.Balance != nil:
+		if delta := *change.Balance - *previous; delta > 0 {
+			s.Baked = delta
+		} else {
+			s.Eaten = -delta
+		}
+	}
+	if e.maxWindow > 0 && (s.Baked > 0 || s.Eaten > 0) {
+		j.Samples = append(j.Samples, s)
+	}
+
+	j.Balances = append(j.Balances, balanceEntry{BlockID: blockID, Balance: change.Balance})
+	if len(j.Balances) > balanceDepth {
+		j.Balances = j.Balances[len(j.Balances)-balanceDepth:]
+	}
+}
+
+// rollback undoes the changes of a block orphaned by a fork
+func (e *rulesEngine) rollback(blockID string) {
+	for _, j := range e.state.Jars {
+		if n := len(j.Balances); n > 0 && j.Balances[n-1].BlockID == blockID {
+			j.Balances = j.Balances[:n-1]
+		}
+		samples := j.Samples[:0]
+		for _, s := range j.Samples {
+			if s.BlockID != blockID {
+				samples = append(samples, s)
+			}
+		}
+		j.Samples = samples
+	}
+}
+
+// value returns the metric of the rule for the jar, false if unknown
+func (e *rulesEngine) value(r *rule, j *jarState, now time.Time) (int, bool) {
+	if r.Metric == metricBalance {
+		if b := j.balance(); b != nil {
+			return *b, true
+		}
+		return 0, false
+	}
+
+	total := 0
+	for _, s := range j.Samples {
+		if now.Sub(s.Time) > r.Window {
+			continue
+		}
+		if r.Metric == metricBaked {
+			total += s.Baked
+		} else {
+			total += s.Eaten
+		}
+	}
+
+	return total, true
+}
+
+// evaluate checks every rule for every jar it applies to, but the windowed rules unless windows is set. Actions run
+// when an alert fires, every repeat interval while it fires, and when it resolves if the rule asks for it.
+func (e *rulesEngine) evaluate(now time.Time, windows bool) {
+	for address, j := range e.state.Jars {
+		samples := j.Samples[:0]
+		for _, s := range j.Samples {
+			if now.Sub(s.Time) <= e.maxWindow {
+				samples = append(samples, s)
+			}
+		}
+		j.Samples = samples
+
+		for _, r := range e.rules {
+			if (r.Jar != "" && r.Jar != address) || (r.Window > 0 && !windows) {
+				continue
+			}
+
+			value, known := e.value(r, j, now)
+			broken, condition, threshold := r.check(value)
+			firing := known && broken
+			key := r.Name + "/" + address
+			a, ok := e.state.Alerts[key]
+			if !ok {
+				a = &alert{Rule: r.Name, Jar: address, State: alertResolved}
+			}
+			a.Owner, a.KeyName, a.Metric, a.Window = j.Owner, j.KeyName, r.Metric, ""
+			if r.Window > 0 {
+				a.Window = r.Window.String()
+			}
+			a.Value, a.Condition, a.Threshold = value, condition, threshold
+
+			switch {
+			case firing && a.State != alertFiring:
+				a.State, a.Since = alertFiring, now
+				e.notify(r, a, now)
+			case firing && r.Repeat > 0 && now.Sub(a.NotifiedAt) >= r.Repeat:
+				e.notify(r, a, now)
+			case !firing && a.State == alertFiring:
+				a.State, a.Since = alertResolved, now
+				if r.NotifyResolved {
+					e.notify(r, a, now)
+				}
+			}
+			if a.State == alertFiring || ok {
+				e.state.Alerts[key] = a
+			}
+		}
+	}
+
+	// Forget the alerts of removed rules
+	for key, a := range e.state.Alerts {
+		found := false
+		for _, r := range e.rules {
+			found = found || r.Name == a.Rule
+		}
+		if !found {
+			delete(e.state.Alerts, key)
+		}
+	}
+}
+
+// notify runs the actions of the rule in the background
+func (e *rulesEngine) notify(r *rule, a *alert, now time.Time) {
+	a.NotifiedAt = now
+	var message bytes.Buffer
+	if err := r.message.Execute(&message, a); err != nil {
+		message.Reset()
+		fmt.Fprintf(&message, "%s %s (invalid message: %v)", a.Rule, a.State, err)
+	}
+	a.Message = message.String()
+
+	sent := *a
+	for _, action := range r.Actions {
+		e.actions.Add(1)
+		go func(action *ruleAction) {
+			defer e.actions.Done()
+			if err := action.run(e.ctx, &sent, e.logf); err != nil {
+				e.logf("Alert action %s of %s failed: %v", action.Type, sent.Rule, err)
+			}
+		}(action)
+	}
+}
+
+// run evaluates the rules every rulesInterval until the context is cancelled, then cancels the running actions and
+// waits for them
+func (e *rulesEngine) run(ctx context.Context) {
+	ticker := time.NewTicker(rulesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.stop()
+			e.actions.Wait()
+			return
+		case <-ticker.C:
+			e.mutex.Lock()
+			e.evaluate(time.Now().UTC(), true)
+			if err := e.save(); err != nil {
+				e.logf("Failed to save the rules state: %v", err)
+			}
+			e.mutex.Unlock()
+		}
+	}
+}
+
+// alerts returns the firing alerts, for the log on startup
+func (e *rulesEngine) alerts() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var firing []string
+	for key, a := range e.state.Alerts {
+		if a.State == alertFiring {
+			firing = append(firing, key)
+		}
+	}
+	sort.Strings(firing)
+
+	return firing
+}
@@ -0,0 +1,260 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"context"
+	"cookiejarevents"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifications collects the messages of the log actions
+type notifications struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (n *notifications) logf(format string, args ...interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if format == "ALERT %s" {
+		n.messages = append(n.messages, args[0].(string))
+	}
+}
+
+// take returns the messages since the last call, once the running actions finished
+func (n *notifications) take(e *rulesEngine) []string {
+	e.actions.Wait()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	messages := n.messages
+	n.messages = nil
+
+	return messages
+}
+
+func threshold(n int) *int {
+	return &n
+}
+
+// newTestEngine returns an engine of the rules, persisting its state in the directory
+func newTestEngine(t *testing.T, dir string, rules ...*rule) (*rulesEngine, *notifications) {
+	for _, r := range rules {
+		r.Message = "{{.Rule}} {{.State}} {{.Value}}"
+		if err := r.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := &notifications{}
+	e, err := newRulesEngine(&rulesConfig{State: filepath.Join(dir, "alerts.state"), Rules: rules}, n.logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e, n
+}
+
+// block returns a committed block built at the time, setting the jar of alice to the balance, with the bake and eat
+// events given as bake=<amount> or eat=<amount>
+func block(id string, at time.Time, balance int, actions ...string) *cookiejarevents.CookiejarEvent {
+	event := &cookiejarevents.CookiejarEvent{
+		Type:      cookiejarevents.BlockCommit,
+		BlockID:   id,
+		Timestamp: at.Unix(),
+		Changes:   []cookiejarevents.JarChange{balanceOf("alice", balance)},
+	}
+	for _, a := range actions {
+		if a[:4] == "bake" {
+			event.Events = append(event.Events, jarEvent(cookiejarevents.BakeEventType, a[5:]))
+		} else {
+			event.Events = append(event.Events, jarEvent(cookiejarevents.EatEventType, a[4:]))
+		}
+	}
+
+	return event
+}
+
+func balanceOf(owner string, cookies int) cookiejarevents.JarChange {
+	change := balance(owner, cookies)
+	change.KeyName = owner
+
+	return change
+}
+
+func observe(t *testing.T, e *rulesEngine, events ...*cookiejarevents.CookiejarEvent) {
+	t.Helper()
+	for _, event := range events {
+		if err := e.observe(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBalanceAlertsAreDeduplicatedAndResolve(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	e, n := newTestEngine(t, dir, &rule{Name: "low", Owner: "alice", Metric: metricBalance, Below: threshold(20),
+		NotifyResolved: true})
+	now := time.Now()
+
+	observe(t, e, block("b1", now, 10), block("b2", now, 5))
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"low firing 10"}) {
+		t.Fatalf("got %v, want a single firing alert", got)
+	}
+	observe(t, e, block("b3", now, 25), block("b4", now, 30))
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"low resolved 25"}) {
+		t.Fatalf("got %v, want a single resolved alert", got)
+	}
+	observe(t, e, block("b5", now, 15))
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"low firing 15"}) {
+		t.Fatalf("got %v, want the alert firing again", got)
+	}
+
+	// A restarted engine remembers the firing alert
+	e, n = newTestEngine(t, dir, &rule{Name: "low", Owner: "alice", Metric: metricBalance, Below: threshold(20),
+		NotifyResolved: true})
+	if got := e.alerts(); !reflect.DeepEqual(got, []string{"low/" + cookiejarevents.JarAddress("alice")}) {
+		t.Fatalf("got firing alerts %v", got)
+	}
+	observe(t, e, block("b6", now, 12))
+	if got := n.take(e); len(got) != 0 {
+		t.Fatalf("got %v, want no repeated alert", got)
+	}
+}
+
+func TestFiringAlertsRepeat(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	e, n := newTestEngine(t, dir, &rule{Name: "low", Metric: metricBalance, Below: threshold(20), Repeat: time.Hour})
+	now := time.Now()
+
+	observe(t, e, block("b1", now, 10))
+	e.evaluate(now.Add(30*time.Minute), true)
+	e.evaluate(now.Add(61*time.Minute), true)
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"low firing 10", "low firing 10"}) {
+		t.Fatalf("got %v, want the alert and a reminder", got)
+	}
+}
+
+func TestWindowsCountTheActions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	e, n := newTestEngine(t, dir, &rule{Name: "eater", Metric: metricEaten, Window: time.Hour, Above: threshold(50)})
+	now := time.Now()
+
+	// The bake doesn't cancel the eat, and clearing the jar isn't eating
+	observe(t, e, block("b1", now, 100), block("b2", now, 80, "eat=30", "bake=10"), block("b3", now, 0))
+	if got := n.take(e); len(got) != 0 {
+		t.Fatalf("got %v, want no alert", got)
+	}
+	if value, _ := e.value(e.rules[0], e.state.Jars[cookiejarevents.JarAddress("alice")], now); value != 30 {
+		t.Fatalf("got %d eaten, want 30", value)
+	}
+
+	observe(t, e, block("b4", now, 10, "bake=35"), block("b5", now, 0, "eat=10", "eat=11"))
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"eater firing 51"}) {
+		t.Fatalf("got %v, want the alert", got)
+	}
+
+	// A rollback removes the samples of its block, and the others leave the window
+	jar := e.state.Jars[cookiejarevents.JarAddress("alice")]
+	observe(t, e, &cookiejarevents.CookiejarEvent{Type: cookiejarevents.BlockRollback, BlockID: "b5"})
+	if len(jar.Samples) != 2 || jar.Samples[0].BlockID != "b2" || jar.Samples[1].BlockID != "b4" {
+		t.Fatalf("got samples %+v, want those of b2 and b4", jar.Samples)
+	}
+	e.evaluate(now.Add(2*time.Hour), true)
+	if len(jar.Samples) != 0 {
+		t.Fatalf("got samples %+v, want none", jar.Samples)
+	}
+}
+
+func TestWindowsUseTheBlockTime(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	e, n := newTestEngine(t, dir, &rule{Name: "eater", Metric: metricEaten, Window: time.Hour, Above: threshold(50)})
+	now := time.Now()
+
+	// Blocks replayed after a downtime keep the time they were built at
+	observe(t, e, block("b1", now.Add(-3*time.Hour), 100), block("b2", now.Add(-3*time.Hour), 40, "eat=60"))
+	e.evaluate(now, true)
+	if got := n.take(e); len(got) != 0 {
+		t.Fatalf("got %v, want no alert for the old eats", got)
+	}
+
+	// Recent replayed blocks are only evaluated once the client caught up
+	observe(t, e, block("b3", now.Add(-10*time.Minute), 0, "eat=40"), block("b4", now.Add(-5*time.Minute), 0,
+		"bake=20", "eat=20"))
+	if got := n.take(e); len(got) != 0 {
+		t.Fatalf("got %v, want no alert while catching up", got)
+	}
+	observe(t, e, block("b5", now, 0))
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"eater firing 60"}) {
+		t.Fatalf("got %v, want the alert of the last hour", got)
+	}
+}
+
+func TestWindowsOfBlocksChangingSeveralJars(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	e, n := newTestEngine(t, dir, &rule{Name: "eater", Metric: metricEaten, Window: time.Hour, Above: threshold(50)})
+	now := time.Now()
+
+	// The events can't be credited to one jar, so the net change counts, the first balance being the baseline
+	shared := func(id string, balance int) *cookiejarevents.CookiejarEvent {
+		b := block(id, now, balance, "eat=0")
+		b.Changes = append(b.Changes, balanceOf("bob", 1))
+		return b
+	}
+	observe(t, e, shared("b1", 200), shared("b2", 170), shared("b3", 140))
+	if got := n.take(e); !reflect.DeepEqual(got, []string{"eater firing 60"}) {
+		t.Fatalf("got %v, want the alert", got)
+	}
+
+	// The jars outside of -owner and -jar are ignored
+	e.jars = map[string]bool{cookiejarevents.JarAddress("bob"): true}
+	observe(t, e, shared("b4", 0))
+	if b := e.state.Jars[cookiejarevents.JarAddress("alice")].balance(); b == nil || *b != 140 {
+		t.Fatalf("got balance %v, want the jar of alice untouched", b)
+	}
+}
+
+func TestWebhookActionStopsWithTheContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// The first attempt fails, the retry waiting a second is interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	a := &ruleAction{Type: "webhook", URL: server.URL, Timeout: time.Second}
+	start := time.Now()
+	if err := a.run(ctx, &alert{Rule: "eater"}, t.Logf); err == nil {
+		t.Fatal("got no error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("took %v, want the retries to stop with the context", elapsed)
+	}
+}
@@ -0,0 +1,518 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"cookiejarevents"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// The headers of a webhook delivery. The signature is the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with
+	// the endpoint's secret, so receivers can reject replayed deliveries.
+	webhookIDHeader        = "X-Cookiejar-Delivery"
+	webhookTimestampHeader = "X-Cookiejar-Timestamp"
+	webhookSignatureHeader = "X-Cookiejar-Signature"
+
+	// rollbackEvent selects the rollbacks of orphaned blocks in the events of an endpoint
+	rollbackEvent = "rollback"
+)
+
+// endpointNamePattern restricts the endpoint names, which name the queue directories
+var endpointNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// webhookEndpoint is an endpoint of the webhooks file
+type webhookEndpoint struct {
+	Name          string        `yaml:"name"`
+	URL           string        `yaml:"url"`
+	Secret        string        `yaml:"secret,omitempty"`
+	SecretEnv     string        `yaml:"secret_env,omitempty"` // environment variable holding the secret
+	Events        []string      `yaml:"events,omitempty"`     // bake, eat or rollback, default bake and eat
+	Owners        []string      `yaml:"owners,omitempty"`
+	Jars          []string      `yaml:"jars,omitempty"`
+	Filters       []string      `yaml:"filters,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	MaxAttempts   int           `yaml:"max_attempts,omitempty"`
+	MinRetryDelay time.Duration `yaml:"min_retry_delay,omitempty"`
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay,omitempty"`
+
+	filter    *blockFilter
+	rollbacks bool
+}
+
+// webhookConfig is the content of the webhooks file
+type webhookConfig struct {
+	Queue      string             `yaml:"queue"`       // directory of the pending deliveries
+	DeadLetter string             `yaml:"dead_letter"` // file the failed deliveries are appended to
+	Endpoints  []*webhookEndpoint `yaml:"endpoints"`
+}
+
+// loadWebhookConfig reads and validates the webhooks file
+func loadWebhookConfig(file string) (*webhookConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &webhookConfig{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("Invalid webhooks file %s: %v", file, err)
+	}
+
+	if config.Queue == "" {
+		config.Queue = "webhooks.queue"
+	}
+	if config.DeadLetter == "" {
+		config.DeadLetter = "webhooks.dead"
+	}
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("No endpoint in the webhooks file %s", file)
+	}
+
+	names := map[string]bool{}
+	for _, e := range config.Endpoints {
+		if !endpointNamePattern.MatchString(e.Name) || names[e.Name] {
+			return nil, fmt.Errorf("Invalid or duplicate endpoint name %q, use letters, digits, '.', '_' and '-'", e.Name)
+		}
+		names[e.Name] = true
+		if err := e.init(); err != nil {
+			return nil, fmt.Errorf("Endpoint %s: %v", e.Name, err)
+		}
+	}
+
+	return config, nil
+}
+
+// init sets the defaults of the endpoint and parses its filters
+func (e *webhookEndpoint) init() error {
+	if !strings.HasPrefix(e.URL, "http://") && !strings.HasPrefix(e.URL, "https://") {
+		return fmt.Errorf("Invalid URL %q", e.URL)
+	}
+	if e.SecretEnv != "" {
+		e.Secret = os.Getenv(e.SecretEnv)
+	}
+	if e.Secret == "" {
+		return fmt.Errorf("No secret, set secret or secret_env")
+	}
+	if e.Timeout == 0 {
+		e.Timeout = 10 * time.Second
+	}
+	if e.MaxAttempts == 0 {
+		e.MaxAttempts = 10
+	}
+	if e.MinRetryDelay == 0 {
+		e.MinRetryDelay = time.Second
+	}
+	if e.MaxRetryDelay == 0 {
+		e.MaxRetryDelay = 5 * time.Minute
+	}
+
+	if len(e.Events) == 0 {
+		e.Events = []string{"bake", "eat"}
+	}
+	var eventTypes []string
+	for _, name := range e.Events {
+		if name == rollbackEvent {
+			e.rollbacks = true
+			continue
+		}
+		t, err := cookiejarevents.ParseEventType(name)
+		if err != nil {
+			return err
+		}
+		if t != cookiejarevents.BakeEventType && t != cookiejarevents.EatEventType {
+			return fmt.Errorf("Invalid event %q, use bake, eat or rollback", name)
+		}
+		eventTypes = append(eventTypes, t)
+	}
+
+	filter, err := newBlockFilter(eventTypes, e.Owners, e.Jars, e.Filters)
+	if err != nil {
+		return err
+	}
+	e.filter = filter
+
+	return nil
+}
+
+// payload returns the payload for the endpoint, or nil if the event doesn't match it. A committed block matches if
+// one of its events was selected, so with owners or jars if it also changed one of them.
+func (e *webhookEndpoint) payload(event *cookiejarevents.CookiejarEvent) *blockPayload {
+	var p *blockPayload
+	if event.Type == cookiejarevents.BlockRollback {
+		if !e.rollbacks {
+			return nil
+		}
+		p = &blockPayload{Type: event.Type, BlockNum: event.BlockNum, BlockID: event.BlockID,
+			PreviousBlockID: event.PreviousBlockID}
+	} else if p = e.filter.apply(event); len(p.Events) == 0 {
+		return nil
+	}
+	p.ID = fmt.Sprintf("%s:%s:%s", event.Type, event.BlockID, e.Name)
+	p.Endpoint = e.Name
+
+	return p
+}
+
+// delivery is a pending delivery, persisted in the queue directory of its endpoint
+type delivery struct {
+	ID        string          `json:"id"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	QueuedAt  time.Time       `json:"queued_at"`
+
+	file string
+}
+
+// deadLetter is a line of the dead letter file
+type deadLetter struct {
+	ID       string          `json:"id"`
+	Endpoint string          `json:"endpoint"`
+	URL      string          `json:"url"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	QueuedAt time.Time       `json:"queued_at"`
+	FailedAt time.Time       `json:"failed_at"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// deliveryError is a failed attempt, which is retried unless permanent
+type deliveryError struct {
+	msg       string
+	permanent bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.msg
+}
+
+// webhookQueue is the queue of an endpoint. Deliveries are sent in order, a failing delivery is retried before the
+// next one is sent.
+type webhookQueue struct {
+	endpoint *webhookEndpoint
+	dir      string
+	next     uint64 // sequence number of the next queued delivery
+	wake     chan struct{}
+	http     *http.Client
+	f        *forwarder
+}
+
+// forwarder queues the matching events for every endpoint and delivers them
+type forwarder struct {
+	queues     []*webhookQueue
+	deadLetter string
+	deadMutex  sync.Mutex
+	logf       func(format string, args ...interface{})
+}
+
+// newForwarder creates the queue directories of the endpoints, keeping the deliveries left by a previous run
+func newForwarder(config *webhookConfig, logf func(format string, args ...interface{})) (*forwarder, error) {
+	f := &forwarder{deadLetter: config.DeadLetter, logf: logf}
+	for _, e := range config.Endpoints {
+		q := &webhookQueue{
+			endpoint: e,
+			dir:      filepath.Join(config.Queue, e.Name),
+			wake:     make(chan struct{}, 1),
+			http:     &http.Client{Timeout: e.Timeout},
+			f:        f,
+		}
+		if err := os.MkdirAll(q.dir, 0755); err != nil {
+			return nil, err
+		}
+		files, err := q.files()
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			last, _ := strconv.ParseUint(strings.TrimSuffix(files[len(files)-1], ".json"), 10, 64)
+			q.next = last + 1
+			logf("Resuming %d pending deliveries to %s", len(files), e.Name)
+		}
+		f.queues = append(f.queues, q)
+	}
+
+	return f, nil
+}
+
+// enqueue persists a delivery of the event to every endpoint it matches
+func (f *forwarder) enqueue(event *cookiejarevents.CookiejarEvent) error {
+	for _, q := range f.queues {
+		p := q.endpoint.payload(event)
+		if p == nil {
+			continue
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		d := &delivery{ID: p.ID, Payload: b, QueuedAt: time.Now().UTC()}
+		d.file = filepath.Join(q.dir, fmt.Sprintf("%020d.json", q.next))
+		if err := d.save(); err != nil {
+			return fmt.Errorf("Failed to queue delivery %s: %v", d.ID, err)
+		}
+		q.next++
+		f.logf("Queued %s of block %d for %s", event.Type, event.BlockNum, q.endpoint.Name)
+
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// run delivers the queued deliveries until the context is cancelled. Undelivered ones stay on disk.
+func (f *forwarder) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, q := range f.queues {
+		wg.Add(1)
+		go func(q *webhookQueue) {
+			defer wg.Done()
+			q.run(ctx)
+		}(q)
+	}
+	wg.Wait()
+}
+
+// save writes the delivery to its file
+func (d *delivery) save() error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(d.file, b)
+}
+
+// files returns the names of the queued deliveries, oldest first
+func (q *webhookQueue) files() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, filepath.Base(m))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// run sends the oldest delivery, retrying with an exponential backoff, until the context is cancelled
+func (q *webhookQueue) run(ctx context.Context) {
+	for {
+		files, err := q.files()
+		if err != nil {
+			q.f.logf("Failed to read the queue of %s: %v", q.endpoint.Name, err)
+		}
+		if len(files) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+				continue
+			}
+		}
+
+		d := &delivery{file: filepath.Join(q.dir, files[0])}
+		b, err := ioutil.ReadFile(d.file)
+		if err == nil {
+			err = json.Unmarshal(b, d)
+		}
+		if err != nil {
+			q.f.logf("Dropping unreadable delivery %s: %v", d.file, err)
+			os.Rename(d.file, d.file+".invalid")
+			continue
+		}
+
+		if !q.deliver(ctx, d) {
+			return
+		}
+	}
+}
+
+// deliver sends the delivery until it succeeds, fails permanently or runs out of attempts. It returns false if the
+// context was cancelled first.
+func (q *webhookQueue) deliver(ctx context.Context, d *delivery) bool {
+	e := q.endpoint
+	delay := e.MinRetryDelay
+	for {
+		err := q.send(ctx, d)
+		if err == nil {
+			q.f.logf("Delivered %s to %s", d.ID, e.Name)
+			os.Remove(d.file)
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		d.Attempts++
+		d.LastError = err.Error()
+		if de, ok := err.(*deliveryError); (ok && de.permanent) || d.Attempts >= e.MaxAttempts {
+			q.f.logf("Giving up on %s to %s after %d attempts: %v", d.ID, e.Name, d.Attempts, err)
+			if err := q.f.bury(e, d); err != nil {
+				q.f.logf("Failed to write the dead letter of %s: %v", d.ID, err)
+			}
+			os.Remove(d.file)
+			return true
+		}
+		if err := d.save(); err != nil {
+			q.f.logf("Failed to save delivery %s: %v", d.ID, err)
+		}
+
+		q.f.logf("Delivery of %s to %s failed (attempt %d/%d): %v, retrying in %v", d.ID, e.Name, d.Attempts,
+			e.MaxAttempts, err, delay)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > e.MaxRetryDelay {
+			delay = e.MaxRetryDelay
+		}
+	}
+}
+
+// send POSTs the signed payload. 2xx responses succeed, other 4xx than 408 and 429 fail permanently.
+func (q *webhookQueue) send(ctx context.Context, d *delivery) error {
+	e := q.endpoint
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return &deliveryError{err.Error(), true}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cookiejar-webhooks")
+	req.Header.Set(webhookIDHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(e.Secret, timestamp, d.Payload))
+
+	res, err := q.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	permanent := res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests
+
+	return &deliveryError{fmt.Sprintf("%s responded with %s", e.URL, res.Status), permanent}
+}
+
+// bury appends the failed delivery to the dead letter file
+func (f *forwarder) bury(e *webhookEndpoint, d *delivery) error {
+	f.deadMutex.Lock()
+	defer f.deadMutex.Unlock()
+
+	file, err := os.OpenFile(f.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(&deadLetter{
+		ID:       d.ID,
+		Endpoint: e.Name,
+		URL:      e.URL,
+		Attempts: d.Attempts,
+		Error:    d.LastError,
+		QueuedAt: d.QueuedAt,
+		FailedAt: time.Now().UTC(),
+		Payload:  d.Payload,
+	})
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>"
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// receiveWebhooks serves a local endpoint which verifies and prints the deliveries, to try out the webhooks. It
+// responds with failStatus to the first failures deliveries, to try out the retries.
+func receiveWebhooks(addr, secret string, failures, failStatus int) error {
+	var mutex sync.Mutex
+	return http.ListenAndServe(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		timestamp := r.Header.Get(webhookTimestampHeader)
+		if t, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(t, 0)) > 5*time.Minute {
+			fmt.Printf("Rejected delivery %s: invalid or stale timestamp %q\n", r.Header.Get(webhookIDHeader), timestamp)
+			http.Error(w, "Invalid timestamp", http.StatusUnauthorized)
+			return
+		}
+		expected := "sha256=" + signWebhook(secret, timestamp, body)
+		if !hmac.Equal([]byte(expected), []byte(r.Header.Get(webhookSignatureHeader))) {
+			fmt.Printf("Rejected delivery %s: invalid signature\n", r.Header.Get(webhookIDHeader))
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		mutex.Lock()
+		fail := failures > 0
+		if fail {
+			failures--
+		}
+		mutex.Unlock()
+		if fail {
+			fmt.Printf("Failing delivery %s with %d\n", r.Header.Get(webhookIDHeader), failStatus)
+			w.WriteHeader(failStatus)
+			return
+		}
+
+		fmt.Printf("Received delivery %s: %s\n", r.Header.Get(webhookIDHeader), body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
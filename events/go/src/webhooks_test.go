@@ -0,0 +1,299 @@
+/**
+ * Copyright 2018 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * ------------------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"cookiejarevents"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer is a receiver answering the deliveries with the statuses, then with 204
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	received []string    // ids of the deliveries, including the failed ones
+	times    []time.Time // times of the deliveries
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get(webhookTimestampHeader)
+		if r.Header.Get(webhookSignatureHeader) != "sha256="+signWebhook("s3cret", timestamp, body) {
+			t.Errorf("invalid signature of %s", r.Header.Get(webhookIDHeader))
+		}
+		var p blockPayload
+		if err := json.Unmarshal(body, &p); err != nil || p.ID != r.Header.Get(webhookIDHeader) {
+			t.Errorf("got payload %s, %v", body, err)
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.received = append(s.received, p.ID)
+		s.times = append(s.times, time.Now())
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+
+	return s
+}
+
+func (s *webhookServer) deliveries() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.received...)
+}
+
+// newTestForwarder returns a forwarder to the URL, queuing in the directory
+func newTestForwarder(t *testing.T, url, dir string) *forwarder {
+	endpoint := &webhookEndpoint{Name: "test", URL: url, Secret: "s3cret", MaxAttempts: 3,
+		MinRetryDelay: 10 * time.Millisecond, MaxRetryDelay: 20 * time.Millisecond}
+	if err := endpoint.init(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newForwarder(&webhookConfig{
+		Queue:      filepath.Join(dir, "queue"),
+		DeadLetter: filepath.Join(dir, "dead"),
+		Endpoints:  []*webhookEndpoint{endpoint},
+	}, func(string, ...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+// start runs the forwarder until the returned function is called
+func start(f *forwarder) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.run(ctx)
+		close(done)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// bakeBlock returns a committed block baking cookies in the jar of alice
+func bakeBlock(id string) *cookiejarevents.CookiejarEvent {
+	return &cookiejarevents.CookiejarEvent{
+		Type:    cookiejarevents.BlockCommit,
+		BlockID: id,
+		Events:  []cookiejarevents.Event{jarEvent(cookiejarevents.BakeEventType, "1")},
+	}
+}
+
+func deliveryID(id string) string {
+	return string(cookiejarevents.BlockCommit) + ":" + id + ":test"
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// waitFor fails the test if the condition isn't met within 5 seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// queued returns the amount of deliveries in the queue of the forwarder
+func queued(t *testing.T, f *forwarder) int {
+	files, err := f.queues[0].files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(files)
+}
+
+// deadLetters returns the dead letters of the forwarder
+func deadLetters(t *testing.T, f *forwarder) []deadLetter {
+	file, err := os.Open(f.deadLetter)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var l deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, l)
+	}
+
+	return letters
+}
+
+func TestSignWebhook(t *testing.T) {
+	// echo -n '1500000000.{}' | openssl dgst -sha256 -hmac s3cret
+	want := "7d383a18915ba79fc8953835cfd9f638c8d1966b5bd3f7d4900433a92f9c7b04"
+	if got := signWebhook("s3cret", "1500000000", []byte("{}")); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWebhookDeliversInOrder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := newWebhookServer(t)
+	defer server.Close()
+	f := newTestForwarder(t, server.URL, dir)
+	stop := start(f)
+	defer stop()
+
+	for _, id := range []string{"b1", "b2", "b3"} {
+		if err := f.enqueue(bakeBlock(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Blocks without matching events aren't queued
+	if err := f.enqueue(&cookiejarevents.CookiejarEvent{Type: cookiejarevents.BlockCommit, BlockID: "b4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the deliveries", func() bool { return len(server.deliveries()) == 3 && queued(t, f) == 0 })
+	want := []string{deliveryID("b1"), deliveryID("b2"), deliveryID("b3")}
+	if got := server.deliveries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got deliveries %v", got)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer server.Close()
+	f := newTestForwarder(t, server.URL, dir)
+	stop := start(f)
+	defer stop()
+
+	if err := f.enqueue(bakeBlock("b1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the delivery", func() bool { return len(server.deliveries()) == 3 && queued(t, f) == 0 })
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	// The delay doubles from the minimum retry delay
+	first, second := server.times[1].Sub(server.times[0]), server.times[2].Sub(server.times[1])
+	if first < 10*time.Millisecond || second < 20*time.Millisecond {
+		t.Fatalf("got retry delays %v and %v, want at least 10ms and 20ms", first, second)
+	}
+	if letters := deadLetters(t, f); len(letters) != 0 {
+		t.Fatalf("got dead letters %+v", letters)
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// The first delivery is rejected, the second one fails more than MaxAttempts times, the third one succeeds
+	server := newWebhookServer(t, http.StatusBadRequest, http.StatusBadGateway, http.StatusBadGateway,
+		http.StatusBadGateway)
+	defer server.Close()
+	f := newTestForwarder(t, server.URL, dir)
+	stop := start(f)
+	defer stop()
+
+	for _, id := range []string{"b1", "b2", "b3"} {
+		if err := f.enqueue(bakeBlock(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the deliveries", func() bool { return len(server.deliveries()) == 5 && queued(t, f) == 0 })
+
+	letters := deadLetters(t, f)
+	if len(letters) != 2 {
+		t.Fatalf("got dead letters %+v, want 2", letters)
+	}
+	if letters[0].ID != deliveryID("b1") || letters[0].Attempts != 1 || letters[0].Endpoint != "test" {
+		t.Fatalf("got %+v, want b1 after a single attempt", letters[0])
+	}
+	if letters[1].ID != deliveryID("b2") || letters[1].Attempts != 3 || letters[1].Error == "" {
+		t.Fatalf("got %+v, want b2 after 3 attempts", letters[1])
+	}
+	if got := server.deliveries(); got[len(got)-1] != deliveryID("b3") {
+		t.Fatalf("got deliveries %v, want b3 last", got)
+	}
+}
+
+func TestWebhookQueueResumes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	server := newWebhookServer(t)
+	defer server.Close()
+
+	// The deliveries queued by a forwarder which never ran are sent by the next one, before the new ones
+	f := newTestForwarder(t, server.URL, dir)
+	for _, id := range []string{"b1", "b2"} {
+		if err := f.enqueue(bakeBlock(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f = newTestForwarder(t, server.URL, dir)
+	if queued(t, f) != 2 || f.queues[0].next != 2 {
+		t.Fatalf("got %d queued deliveries before %d, want 2", queued(t, f), f.queues[0].next)
+	}
+	if err := f.enqueue(bakeBlock("b3")); err != nil {
+		t.Fatal(err)
+	}
+	stop := start(f)
+	defer stop()
+
+	waitFor(t, "the deliveries", func() bool { return len(server.deliveries()) == 3 && queued(t, f) == 0 })
+	want := []string{deliveryID("b1"), deliveryID("b2"), deliveryID("b3")}
+	if got := server.deliveries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got deliveries %v", got)
+	}
+}
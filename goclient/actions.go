@@ -1,36 +1,32 @@
 package main
 
-import (
-	"encoding/base64"
-	"fmt"
-)
+import "strconv"
 
-func (c *CookiejarClient) count() (string, error) {
-	res, err := c.sendRequest(fmt.Sprintf("state/%s", c.getAddress()), "", nil)
+// count returns the amount of cookies in the jar in the state of the provided block, or the current state if head is empty
+func (c *CookiejarClient) count(head string) (string, error) {
+	data, err := c.transport.getState(c.getJarAddress(c.jar), head)
 	if err != nil {
 		return "", err
 	}
 
-	responseMap, err := c.getResponseMap(res)
-	if err != nil {
-		return "", fmt.Errorf("Error reading response: %v", err)
-	}
-
-	data, ok := responseMap["data"].(string)
-	if !ok {
-		return "", fmt.Errorf("assertion to string failed")
-	}
+	return string(data), nil
+}
 
-	resData, err := base64.StdEncoding.DecodeString(data)
-	if err != nil {
-		return "", fmt.Errorf("Decoding error: %v", err)
+// getCount returns the amount of cookies stored at a jar address in the state of the provided block.
+// An empty head reads the current state and a jar which doesn't exist holds no cookies.
+func (c *CookiejarClient) getCount(address, head string) (int, error) {
+	data, err := c.transport.getState(address, head)
+	if err == errNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
 	}
 
-	return string(resData), nil
+	return strconv.Atoi(string(data))
 }
 
 func (c *CookiejarClient) clear() error {
-	if _, err := c.wrapAndSend("clear", 0, 10); err != nil {
+	if _, err := c.wrapAndSend("clear", 0, c.wait); err != nil {
 		return err
 	}
 
@@ -38,9 +34,9 @@ func (c *CookiejarClient) clear() error {
 }
 
 func (c *CookiejarClient) bake(amount int) (string, error) {
-	return c.wrapAndSend("bake", amount, 10)
+	return c.wrapAndSend("bake", amount, c.wait)
 }
 
 func (c *CookiejarClient) eat(amount int) (string, error) {
-	return c.wrapAndSend("eat", amount, 10)
+	return c.wrapAndSend("eat", amount, c.wait)
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"cookiejar"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+)
+
+// auditBlock is a block of the chain with its cookiejar transactions
+type auditBlock struct {
+	num          uint64
+	id           string
+	transactions []restTransaction
+}
+
+// auditDivergence is a difference between the replayed and the on-chain result
+type auditDivergence struct {
+	Block         uint64 `json:"block"`
+	BlockID       string `json:"block_id"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	Address       string `json:"address,omitempty"`
+	Replayed      string `json:"replayed,omitempty"`
+	OnChain       string `json:"on_chain,omitempty"`
+	Message       string `json:"message"`
+}
+
+// auditReport is the summary of an audit
+type auditReport struct {
+	Blocks             int               `json:"blocks"`
+	Transactions       int               `json:"transactions"`
+	Checkpoints        int               `json:"checkpoints"`
+	SkippedCheckpoints int               `json:"skipped_checkpoints"`
+	Divergences        []auditDivergence `json:"divergences"`
+}
+
+// toProto converts the decoded header back into its protobuf form
+func (h *restTransactionHeader) toProto() *transaction_pb2.TransactionHeader {
+	return &transaction_pb2.TransactionHeader{
+		BatcherPublicKey: h.BatcherPublicKey,
+		Dependencies:     h.Dependencies,
+		FamilyName:       h.FamilyName,
+		FamilyVersion:    h.FamilyVersion,
+		Inputs:           h.Inputs,
+		Nonce:            h.Nonce,
+		Outputs:          h.Outputs,
+		PayloadSha512:    h.PayloadSha512,
+		SignerPublicKey:  h.SignerPublicKey,
+	}
+}
+
+// toProto converts the transaction back into its protobuf form. The header is serialized again, so its signature
+// isn't valid for it anymore.
+func (t *restTransaction) toProto() (*transaction_pb2.Transaction, error) {
+	header, err := proto.Marshal(t.Header.toProto())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize transaction header: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(t.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("Decoding error: %v", err)
+	}
+
+	return &transaction_pb2.Transaction{
+		Header:          header,
+		HeaderSignature: t.HeaderSignature,
+		Payload:         payload,
+	}, nil
+}
+
+// chainBlocks returns all blocks from genesis to the current head, with their cookiejar transactions
+func (c *CookiejarClient) chainBlocks() ([]auditBlock, error) {
+	var blocks []auditBlock
+	if err := c.listBlocks(func(b *restBlock) bool {
+		block := auditBlock{num: b.Header.BlockNum, id: b.HeaderSignature}
+		for _, batch := range b.Batches {
+			for _, t := range batch.Transactions {
+				if t.Header.FamilyName == familyName {
+					block.transactions = append(block.transactions, t)
+				}
+			}
+		}
+		blocks = append(blocks, block)
+
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	// The blocks are listed newest first
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	return blocks, nil
+}
+
+// compareState compares the replayed state with the state of the block on chain
+func (c *CookiejarClient) compareState(block auditBlock, replayed map[string][]byte) ([]auditDivergence, error) {
+	onChain := map[string][]byte{}
+	var decodeErr error
+	if _, err := c.listState(c.getPrefix(), block.id, func(e *restStateEntry) bool {
+		data, err := base64.StdEncoding.DecodeString(e.Data)
+		if err != nil {
+			decodeErr = fmt.Errorf("Decoding error: %v", err)
+			return false
+		}
+		onChain[e.Address] = data
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	addresses := map[string]bool{}
+	for address := range replayed {
+		addresses[address] = true
+	}
+	for address := range onChain {
+		addresses[address] = true
+	}
+
+	var divergences []auditDivergence
+	for address := range addresses {
+		r, inReplay := replayed[address]
+		o, inChain := onChain[address]
+		if inReplay == inChain && bytes.Equal(r, o) {
+			continue
+		}
+
+		divergences = append(divergences, auditDivergence{
+			Block:    block.num,
+			BlockID:  block.id,
+			Address:  address,
+			Replayed: string(r),
+			OnChain:  string(o),
+			Message:  "state differs",
+		})
+	}
+	sort.Slice(divergences, func(i, j int) bool { return divergences[i].Address < divergences[j].Address })
+
+	return divergences, nil
+}
+
+// audit replays every cookiejar transaction from genesis with the processor's handler and compares the resulting
+// state with the state on chain every checkpointEvery blocks and at the head
+func (c *CookiejarClient) audit(checkpointEvery uint64) (*auditReport, error) {
+	blocks, err := c.chainBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &auditReport{Divergences: []auditDivergence{}}
+	executor := cookiejar.NewExecutor(cookiejar.NewCookiejarHandler(), nil)
+	for i, block := range blocks {
+		report.Blocks++
+
+		for _, t := range block.transactions {
+			report.Transactions++
+
+			transaction, err := t.toProto()
+			if err == nil {
+				_, err = executor.Execute(transaction)
+			}
+			if err != nil {
+				// Committed transactions were accepted by the network's processor
+				report.Divergences = append(report.Divergences, auditDivergence{
+					Block:         block.num,
+					BlockID:       block.id,
+					TransactionID: t.HeaderSignature,
+					Message:       fmt.Sprintf("committed transaction fails when replayed: %v", err),
+				})
+			}
+		}
+
+		head := i == len(blocks)-1
+		if !head && (checkpointEvery == 0 || block.num%checkpointEvery != 0) {
+			continue
+		}
+
+		divergences, err := c.compareState(block, executor.State)
+		if err != nil && !head {
+			// The validator may have pruned the state of older blocks
+			logger.Warnf("Skipping checkpoint at block %d: %v", block.num, err)
+			report.SkippedCheckpoints++
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		report.Checkpoints++
+		report.Divergences = append(report.Divergences, divergences...)
+	}
+
+	return report, nil
+}
+
+// writeText writes the audit report in a human readable form
+func (r *auditReport) writeText(w io.Writer) {
+	for _, d := range r.Divergences {
+		if d.Address != "" {
+			fmt.Fprintf(w, "block %d: %s: replayed %q, on chain %q\n", d.Block, d.Address, d.Replayed, d.OnChain)
+		} else {
+			fmt.Fprintf(w, "block %d: transaction %s: %s\n", d.Block, d.TransactionID, d.Message)
+		}
+	}
+	fmt.Fprintf(w, "Audited %d blocks and %d cookiejar transactions at %d checkpoints (%d skipped): %d divergences\n",
+		r.Blocks, r.Transactions, r.Checkpoints, r.SkippedCheckpoints, len(r.Divergences))
+}
+
+// cmdAudit executes the audit command
+func cmdAudit(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	checkpointEvery := flags.Uint64("checkpoint-every", 100, "compare the state every n blocks, 0 only compares at the head")
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("Invalid format %q, use text or json", *format)
+	}
+
+	report, err := client.audit(*checkpointEvery)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		report.writeText(os.Stdout)
+	}
+
+	if len(report.Divergences) > 0 {
+		return fmt.Errorf("Found %d divergences", len(report.Divergences))
+	}
+
+	return nil
+}
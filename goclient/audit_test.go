@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// auditChain is a REST API serving a chain of blocks and the state at every block
+type auditChain struct {
+	t      *testing.T
+	blocks []map[string]interface{} // oldest first
+	states map[string][]restStateEntry
+	pruned map[string]bool
+	heads  []string // the blocks whose state was requested
+}
+
+func (c *auditChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/blocks":
+		// Newest first, a block per page
+		start := len(c.blocks) - 1
+		if s := r.URL.Query().Get("start"); s != "" {
+			start = len(s) - 1
+		}
+		next := ""
+		if start > 0 {
+			next = strings.Repeat("x", start)
+		}
+		writeJSON(c.t, w, map[string]interface{}{"data": c.blocks[start : start+1],
+			"paging": map[string]string{"next_position": next}})
+	case "/state":
+		head := r.URL.Query().Get("head")
+		c.heads = append(c.heads, head)
+		entries, ok := c.states[head]
+		if !ok || c.pruned[head] || r.URL.Query().Get("address") != "a4d219" {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(c.t, w, map[string]interface{}{"data": entries, "head": head})
+	default:
+		c.t.Errorf("unexpected request %s", r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// newAuditChain returns a chain with a block per list of transactions, each made of a family, a signer and a payload,
+// whose states on chain are the balances after every block
+func newAuditChain(t *testing.T, transactions [][][3]string, balances []map[string]string) *auditChain {
+	client := newTestClient()
+	chain := &auditChain{t: t, states: map[string][]restStateEntry{}, pruned: map[string]bool{}}
+	for num, txs := range transactions {
+		var batches []interface{}
+		for i, tx := range txs {
+			address := client.getJarAddress(tx[1])
+			batches = append(batches, map[string]interface{}{
+				"header":           map[string]interface{}{"signer_public_key": tx[1]},
+				"header_signature": "batch",
+				"transactions": []interface{}{map[string]interface{}{
+					"header": map[string]interface{}{"family_name": tx[0], "family_version": defaultFamilyVersion,
+						"signer_public_key": tx[1], "inputs": []string{address}, "outputs": []string{address},
+						"payload_sha512": hexdigest(tx[2])},
+					"header_signature": blockID(string(rune('a'+num))) + strconv.Itoa(i),
+					"payload":          base64.StdEncoding.EncodeToString([]byte(tx[2])),
+				}},
+			})
+		}
+		id := blockID(strconv.Itoa(num))
+		chain.blocks = append(chain.blocks, map[string]interface{}{
+			"header":           map[string]interface{}{"block_num": strconv.Itoa(num)},
+			"header_signature": id,
+			"batches":          batches,
+		})
+
+		entries := []restStateEntry{}
+		for key, balance := range balances[num] {
+			entries = append(entries, restStateEntry{Address: client.getJarAddress(key),
+				Data: base64.StdEncoding.EncodeToString([]byte(balance))})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+		chain.states[id] = entries
+	}
+
+	return chain
+}
+
+func TestChainBlocksKeepsCookiejarTransactions(t *testing.T) {
+	chain := newAuditChain(t, [][][3]string{
+		{},
+		{{familyName, "01", "bake,10"}, {"intkey", "01", "set"}},
+		{{familyName, "02", "bake,4"}},
+	}, []map[string]string{{}, {}, {}})
+	server := httptest.NewServer(chain)
+	defer server.Close()
+
+	blocks, err := newTestClient(server.URL).chainBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range blocks {
+		for _, tx := range b.transactions {
+			got = append(got, fmt.Sprintf("%d:%s:%s", b.num, tx.Header.SignerPublicKey, tx.Payload))
+		}
+	}
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	want := []string{"1:01:" + encode("bake,10"), "2:02:" + encode("bake,4")}
+	if len(blocks) != 3 || blocks[0].id != blockID("0") || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d blocks with %v, want genesis first and %v", len(blocks), got, want)
+	}
+}
+
+func TestAuditReplaysTheChain(t *testing.T) {
+	transactions := [][][3]string{
+		{},
+		{{familyName, "01", "bake,10"}},
+		{{familyName, "02", "bake,4"}, {"intkey", "01", "set"}},
+		{{familyName, "01", "eat,3"}},
+		{{familyName, "02", "clear,0"}},
+	}
+	balances := []map[string]string{
+		{},
+		{"01": "10"},
+		{"01": "10", "02": "4"},
+		{"01": "7", "02": "4"},
+		{"01": "7", "02": "0"},
+	}
+
+	cases := []struct {
+		name            string
+		checkpointEvery uint64
+		pruned          []string
+		heads           []string
+		checkpoints     int
+		skipped         int
+	}{
+		{"every block", 1, nil, []string{"0", "1", "2", "3", "4"}, 5, 0},
+		{"every other block", 2, nil, []string{"0", "2", "4"}, 3, 0},
+		// The head is always compared
+		{"every third block", 3, nil, []string{"0", "3", "4"}, 3, 0},
+		{"head only", 0, nil, []string{"4"}, 1, 0},
+		{"pruned checkpoints", 1, []string{"0", "2"}, []string{"0", "1", "2", "3", "4"}, 3, 2},
+	}
+	for _, c := range cases {
+		chain := newAuditChain(t, transactions, balances)
+		for _, p := range c.pruned {
+			chain.pruned[blockID(p)] = true
+		}
+		server := httptest.NewServer(chain)
+
+		report, err := newTestClient(server.URL).audit(c.checkpointEvery)
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var heads []string
+		for _, h := range chain.heads {
+			heads = append(heads, h[:1])
+		}
+		if !reflect.DeepEqual(heads, c.heads) {
+			t.Errorf("%s: got the state of blocks %v, want %v", c.name, heads, c.heads)
+		}
+		if report.Blocks != 5 || report.Transactions != 4 || report.Checkpoints != c.checkpoints ||
+			report.SkippedCheckpoints != c.skipped || len(report.Divergences) != 0 {
+			t.Errorf("%s: got %+v, want a clean audit at %d checkpoints, %d skipped", c.name, report, c.checkpoints,
+				c.skipped)
+		}
+	}
+
+	// The state at the head can't be skipped
+	chain := newAuditChain(t, transactions, balances)
+	chain.pruned[blockID("4")] = true
+	server := httptest.NewServer(chain)
+	defer server.Close()
+	if _, err := newTestClient(server.URL).audit(1); err != errNotFound {
+		t.Errorf("got %v, want the head's state not to be found", err)
+	}
+}
+
+func TestAuditReportsDivergences(t *testing.T) {
+	client := newTestClient()
+	chain := newAuditChain(t, [][][3]string{
+		{},
+		{{familyName, "01", "bake,10"}},
+		// The network accepted an eat from a jar which doesn't exist
+		{{familyName, "02", "eat,4"}, {familyName, "01", "eat,3"}},
+	}, []map[string]string{
+		{},
+		{"01": "10"},
+		{"01": "8", "02": "0", "03": "1"},
+	})
+	server := httptest.NewServer(chain)
+	defer server.Close()
+
+	report, err := newTestClient(server.URL).audit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 3 || report.Transactions != 3 || report.Checkpoints != 1 || len(report.Divergences) != 4 {
+		t.Fatalf("got %+v, want 4 divergences", report)
+	}
+
+	failed := report.Divergences[0]
+	if failed.Block != 2 || failed.BlockID != blockID("2") || failed.TransactionID != blockID("c")+"0" ||
+		!strings.Contains(failed.Message, "fails when replayed") {
+		t.Errorf("got %+v, want the failing eat", failed)
+	}
+	want := []auditDivergence{
+		{Address: client.getJarAddress("01"), Replayed: "7", OnChain: "8"},
+		{Address: client.getJarAddress("02"), OnChain: "0"},
+		{Address: client.getJarAddress("03"), OnChain: "1"},
+	}
+	for i := range want {
+		want[i].Block, want[i].BlockID, want[i].Message = 2, blockID("2"), "state differs"
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].Address < want[j].Address })
+	if !reflect.DeepEqual(report.Divergences[1:], want) {
+		t.Errorf("got %+v, want %+v", report.Divergences[1:], want)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+)
+
+const (
+	// benchPollInterval is the time between two batch status requests of the benchmark tracker
+	benchPollInterval = 250 * time.Millisecond
+	// benchWaitTimeout bounds the wait for the tracked batches when the profile disables waiting
+	benchWaitTimeout = 2 * time.Minute
+)
+
+// benchOptions holds the settings of a benchmark run
+type benchOptions struct {
+	Signers     int
+	Rate        float64 // batches per second, 0 means as fast as the workers can submit
+	Concurrency int
+	BatchSize   int
+	Duration    time.Duration
+	EatRatio    float64
+	Amount      int
+}
+
+// benchLatency holds the submit to commit latency percentiles in milliseconds
+type benchLatency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// benchReport is the summary of a benchmark run
+type benchReport struct {
+	Duration       float64      `json:"duration_seconds"`
+	Signers        int          `json:"signers"`
+	Concurrency    int          `json:"concurrency"`
+	BatchSize      int          `json:"batch_size"`
+	Batches        int          `json:"batches"`
+	Transactions   int          `json:"transactions"`
+	SubmitErrors   int          `json:"submit_errors"`
+	Committed      int          `json:"committed"`
+	Invalid        int          `json:"invalid"`
+	Unresolved     int          `json:"unresolved"`
+	InvalidRate    float64      `json:"invalid_rate"`
+	SubmittedTPS   float64      `json:"submitted_tps"`
+	CommittedTPS   float64      `json:"committed_tps"`
+	CommitLatency  benchLatency `json:"commit_latency"`
+	PollInterval   float64      `json:"poll_interval_ms"` // granularity of the commit latencies
+	StatusRequests int          `json:"status_requests"`
+}
+
+// benchBatch is a submitted batch of the benchmark
+type benchBatch struct {
+	submitted time.Time
+	size      int // amount of transactions
+	signer    int
+	baked     int // cookies credited to the signer once committed
+	eaten     int // cookies reserved from the signer, refunded if invalid
+}
+
+// benchTracker records submitted batches and resolves their status in bulk. It tracks the committed balance of
+// every signer, so the workers only eat cookies which are already in the jar.
+type benchTracker struct {
+	client    *CookiejarClient
+	mu        sync.Mutex
+	pending   map[string]benchBatch // batch id -> batch
+	balances  []int                 // committed cookies of each signer not reserved by an eat yet
+	latencies []time.Duration
+	committed int
+	invalid   int
+	requests  int
+}
+
+// newBenchTracker returns a tracker of the batches of the signers
+func newBenchTracker(client *CookiejarClient, signers int) *benchTracker {
+	return &benchTracker{client: client, pending: map[string]benchBatch{}, balances: make([]int, signers)}
+}
+
+// add registers a submitted batch
+func (t *benchTracker) add(batchID string, batch benchBatch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[batchID] = batch
+}
+
+// reserve takes amount cookies from the balance of the signer, returning false if it doesn't hold enough
+func (t *benchTracker) reserve(signer, amount int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.balances[signer] < amount {
+		return false
+	}
+	t.balances[signer] -= amount
+
+	return true
+}
+
+// outstanding returns the amount of batches which aren't resolved yet
+func (t *benchTracker) outstanding() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// poll requests the status of all pending batches and records the resolved ones
+func (t *benchTracker) poll() error {
+	t.mu.Lock()
+	ids := make([]string, 0, len(t.pending))
+	for id := range t.pending {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	statuses, err := t.client.transport.batchStatuses(ids, 0)
+	now := time.Now()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	for id, status := range statuses {
+		batch, ok := t.pending[id]
+		if !ok {
+			continue
+		}
+
+		switch status.Status {
+		case "COMMITTED":
+			t.committed += batch.size
+			t.balances[batch.signer] += batch.baked
+			t.latencies = append(t.latencies, now.Sub(batch.submitted))
+		case "INVALID":
+			t.invalid += batch.size
+			t.balances[batch.signer] += batch.eaten
+		default:
+			continue
+		}
+		delete(t.pending, id)
+	}
+
+	return nil
+}
+
+// run polls until stop is closed
+func (t *benchTracker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(benchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := t.poll(); err != nil {
+				logger.Warnf("Failed to read batch statuses: %v", err)
+			}
+		}
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(p / 100 * float64(len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// benchWaitTimeout returns how long to wait for the tracked batches to be committed
+func (c *CookiejarClient) benchWaitTimeout() time.Duration {
+	if c.wait > 0 {
+		return time.Duration(c.wait) * time.Second
+	}
+
+	return benchWaitTimeout
+}
+
+// withRandomSigner returns a copy of the client which signs with a new random key
+func (c *CookiejarClient) withRandomSigner() (*CookiejarClient, error) {
+	signer, err := loadSigner("")
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *c
+	clone.signer = signer
+	clone.jar = signer.GetPublicKey().AsHex()
+
+	return &clone, nil
+}
+
+// setupBench bakes into the jar of every signer with a batch which must be committed before the benchmark starts,
+// since eating from a jar which doesn't exist yet is invalid
+func (c *CookiejarClient) setupBench(signers []*CookiejarClient, tracker *benchTracker, amount int) error {
+	batches := make([]*batch_pb2.Batch, len(signers))
+	for i, signer := range signers {
+		transaction, err := signer.newTransaction("bake", amount)
+		if err != nil {
+			return err
+		}
+		if batches[i], err = signer.newBatch([]*transaction_pb2.Transaction{transaction}); err != nil {
+			return err
+		}
+	}
+	if err := c.submitBatches(batches); err != nil {
+		return fmt.Errorf("Failed to submit the setup batches: %v", err)
+	}
+	for i, batch := range batches {
+		tracker.add(batch.HeaderSignature, benchBatch{submitted: time.Now(), size: 1, signer: i, baked: amount})
+	}
+
+	deadline := time.Now().Add(c.benchWaitTimeout())
+	for tracker.outstanding() > 0 && time.Now().Before(deadline) {
+		time.Sleep(benchPollInterval)
+		if err := tracker.poll(); err != nil {
+			logger.Warnf("Failed to read batch statuses: %v", err)
+		}
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.invalid > 0 || len(tracker.pending) > 0 {
+		return fmt.Errorf("Setup failed: %d of %d setup batches committed", tracker.committed, len(batches))
+	}
+	tracker.committed = 0
+	tracker.latencies = nil
+	tracker.requests = 0
+
+	return nil
+}
+
+// bench submits bake and eat batches from simulated signers and measures how they are committed
+func (c *CookiejarClient) bench(opts benchOptions) (*benchReport, error) {
+	// Create the simulated signers
+	signers := make([]*CookiejarClient, opts.Signers)
+	for i := range signers {
+		signer, err := c.withRandomSigner()
+		if err != nil {
+			return nil, err
+		}
+		signers[i] = signer
+	}
+
+	// Fill the jars so the first batches can already eat
+	tracker := newBenchTracker(c, len(signers))
+	if err := c.setupBench(signers, tracker, opts.Amount*opts.BatchSize); err != nil {
+		return nil, err
+	}
+
+	stopTracker := make(chan struct{})
+	trackerDone := make(chan struct{})
+	go func() {
+		tracker.run(stopTracker)
+		close(trackerDone)
+	}()
+
+	var mu sync.Mutex
+	report := &benchReport{
+		Signers:     opts.Signers,
+		Concurrency: opts.Concurrency,
+		BatchSize:   opts.BatchSize,
+	}
+
+	// Every job is a batch submitted by one of the signers
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < opts.Concurrency; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				index := i % len(signers)
+				signer := signers[index]
+
+				// Build a batch with a mix of bake and eat transactions. Eats are turned into bakes when the
+				// committed balance of the signer can't cover them.
+				transactions := make([]*transaction_pb2.Transaction, 0, opts.BatchSize)
+				info := benchBatch{signer: index}
+				var err error
+				for n := 0; n < opts.BatchSize && err == nil; n++ {
+					action := "bake"
+					if rand.Float64() < opts.EatRatio && tracker.reserve(index, opts.Amount) {
+						action = "eat"
+						info.eaten += opts.Amount
+					} else {
+						info.baked += opts.Amount
+					}
+
+					var transaction *transaction_pb2.Transaction
+					if transaction, err = signer.newTransaction(action, opts.Amount); err == nil {
+						transactions = append(transactions, transaction)
+					}
+				}
+
+				var batch *batch_pb2.Batch
+				if err == nil {
+					batch, err = signer.newBatch(transactions)
+				}
+
+				info.submitted = time.Now()
+				info.size = len(transactions)
+				if err == nil {
+					err = signer.submitBatches([]*batch_pb2.Batch{batch})
+				}
+
+				mu.Lock()
+				if err != nil {
+					logger.Warnf("Failed to submit batch: %v", err)
+					report.SubmitErrors++
+				} else {
+					report.Batches++
+					report.Transactions += len(transactions)
+				}
+				mu.Unlock()
+
+				if err == nil {
+					tracker.add(batch.HeaderSignature, info)
+				} else {
+					tracker.mu.Lock()
+					tracker.balances[index] += info.eaten
+					tracker.mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	// Feed the workers at the requested rate until the duration passed
+	start := time.Now()
+	deadline := time.After(opts.Duration)
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+feed:
+	for i := 0; ; i++ {
+		if tick != nil {
+			select {
+			case <-deadline:
+				break feed
+			case <-tick:
+			}
+		}
+
+		select {
+		case <-deadline:
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	elapsed := time.Since(start)
+
+	// Give the network time to commit the outstanding batches, even when the profile disables waiting
+	timeout := time.After(c.benchWaitTimeout())
+wait:
+	for tracker.outstanding() > 0 {
+		select {
+		case <-timeout:
+			break wait
+		case <-time.After(benchPollInterval):
+		}
+	}
+	close(stopTracker)
+	<-trackerDone
+	tracker.poll()
+
+	tracker.summarize(report, elapsed)
+
+	return report, nil
+}
+
+// summarize fills the report with the outcome of the batches tracked over the elapsed submission time
+func (t *benchTracker) summarize(report *benchReport, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report.Duration = elapsed.Seconds()
+	report.Committed = t.committed
+	report.Invalid = t.invalid
+	report.Unresolved = report.Transactions - t.committed - t.invalid
+	report.StatusRequests = t.requests
+	report.PollInterval = float64(benchPollInterval) / float64(time.Millisecond)
+	if report.Transactions > 0 {
+		report.InvalidRate = float64(report.Invalid) / float64(report.Transactions)
+	}
+	if elapsed > 0 {
+		report.SubmittedTPS = float64(report.Transactions) / elapsed.Seconds()
+		report.CommittedTPS = float64(report.Committed) / elapsed.Seconds()
+	}
+
+	latencies := t.latencies
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	if len(latencies) > 0 {
+		report.CommitLatency = benchLatency{
+			Mean: float64(total) / float64(len(latencies)) / float64(time.Millisecond),
+			P50:  percentile(latencies, 50),
+			P90:  percentile(latencies, 90),
+			P99:  percentile(latencies, 99),
+			Max:  percentile(latencies, 100),
+		}
+	}
+}
+
+// writeText writes the benchmark report in a human readable form
+func (r *benchReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Duration:        %.1fs\n", r.Duration)
+	fmt.Fprintf(w, "Signers:         %d (concurrency %d, batch size %d)\n", r.Signers, r.Concurrency, r.BatchSize)
+	fmt.Fprintf(w, "Submitted:       %d transactions in %d batches (%.1f tx/s), %d submit errors\n",
+		r.Transactions, r.Batches, r.SubmittedTPS, r.SubmitErrors)
+	fmt.Fprintf(w, "Committed:       %d transactions (%.1f tx/s)\n", r.Committed, r.CommittedTPS)
+	fmt.Fprintf(w, "Invalid:         %d transactions (%.1f%%)\n", r.Invalid, r.InvalidRate*100)
+	fmt.Fprintf(w, "Unresolved:      %d transactions\n", r.Unresolved)
+	fmt.Fprintf(w, "Commit latency:  mean %.0fms, p50 %.0fms, p90 %.0fms, p99 %.0fms, max %.0fms (polled every %.0fms)\n",
+		r.CommitLatency.Mean, r.CommitLatency.P50, r.CommitLatency.P90, r.CommitLatency.P99, r.CommitLatency.Max,
+		r.PollInterval)
+}
+
+// cmdBench executes the bench command
+func cmdBench(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	signers := flags.Int("signers", 10, "amount of simulated signers")
+	rate := flags.Float64("rate", 0, "target batches per second, 0 submits as fast as the workers can")
+	concurrency := flags.Int("concurrency", 4, "amount of concurrent submitters")
+	batchSize := flags.Int("batch-size", 1, "transactions per batch")
+	duration := flags.Duration("duration", 30*time.Second, "how long to submit batches")
+	eatRatio := flags.Float64("eat-ratio", 0.3, "fraction of transactions which eat cookies instead of baking them")
+	amount := flags.Int("amount", 1, "cookies baked or eaten per transaction")
+	format := flags.String("format", "text", "output format: text or json")
+	reportFile := flags.String("report", "", "also write the JSON report to this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *signers < 1 || *concurrency < 1 || *batchSize < 1 || *amount < 1 {
+		return fmt.Errorf("signers, concurrency, batch-size and amount must be at least 1")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("Invalid format %q, use text or json", *format)
+	}
+
+	report, err := client.bench(benchOptions{
+		Signers:     *signers,
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		BatchSize:   *batchSize,
+		Duration:    *duration,
+		EatRatio:    *eatRatio,
+		Amount:      *amount,
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *reportFile != "" {
+		if err := ioutil.WriteFile(*reportFile, b, 0644); err != nil {
+			return fmt.Errorf("Failed to write report: %v", err)
+		}
+	}
+
+	if *format == "json" {
+		fmt.Println(string(b))
+	} else {
+		report.writeText(os.Stdout)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+)
+
+// fakeStatusTransport answers batch status requests from a fixed set of statuses
+type fakeStatusTransport struct {
+	statuses map[string]string
+}
+
+func (t *fakeStatusTransport) submitBatches(batches []*batch_pb2.Batch) error {
+	return nil
+}
+
+func (t *fakeStatusTransport) batchStatuses(batchIDs []string, wait uint) (map[string]*batchStatus, error) {
+	statuses := map[string]*batchStatus{}
+	for _, id := range batchIDs {
+		status, ok := t.statuses[id]
+		if !ok {
+			status = "PENDING"
+		}
+		statuses[id] = &batchStatus{ID: id, Status: status}
+	}
+
+	return statuses, nil
+}
+
+func (t *fakeStatusTransport) getState(address, head string) ([]byte, error) {
+	return nil, nil
+}
+
+func (t *fakeStatusTransport) close() {}
+
+func TestPercentile(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		durations := make([]time.Duration, len(values))
+		for i, v := range values {
+			durations[i] = time.Duration(v) * time.Millisecond
+		}
+		return durations
+	}
+
+	cases := []struct {
+		sorted []time.Duration
+		p      float64
+		want   float64
+	}{
+		{nil, 50, 0},
+		{ms(7), 50, 7},
+		{ms(7), 100, 7},
+		{ms(10, 20, 30, 40, 50), 0, 10},
+		{ms(10, 20, 30, 40, 50), 50, 30},
+		{ms(10, 20, 30, 40, 50), 90, 40},
+		{ms(10, 20, 30, 40, 50), 100, 50},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 50, 5},
+	}
+	for _, c := range cases {
+		if got := percentile(c.sorted, c.p); got != c.want {
+			t.Errorf("p%v of %v: got %v, want %v", c.p, c.sorted, got, c.want)
+		}
+	}
+}
+
+func TestBenchTrackerAccounting(t *testing.T) {
+	statuses := &fakeStatusTransport{statuses: map[string]string{}}
+	client := newTestClient()
+	client.transport = statuses
+	tracker := newBenchTracker(client, 2)
+	tracker.balances[0] = 10
+
+	// Eats only reserve cookies which are in the jar
+	if !tracker.reserve(0, 6) || tracker.reserve(0, 6) || tracker.reserve(1, 1) {
+		t.Fatal("got reservations beyond the balances")
+	}
+	if tracker.balances[0] != 4 {
+		t.Fatalf("got balance %d, want 4", tracker.balances[0])
+	}
+
+	submitted := time.Now().Add(-time.Second)
+	tracker.add("committed", benchBatch{submitted: submitted, size: 3, signer: 0, baked: 5, eaten: 6})
+	tracker.add("invalid", benchBatch{submitted: submitted, size: 2, signer: 0, eaten: 4})
+	tracker.add("pending", benchBatch{submitted: submitted, size: 1, signer: 1, baked: 5})
+	if !tracker.reserve(0, 4) || tracker.balances[0] != 0 {
+		t.Fatalf("got balance %d, want the rest reserved", tracker.balances[0])
+	}
+
+	// A committed batch credits its bakes, an invalid one refunds its eats and pending ones stay tracked
+	statuses.statuses["committed"] = "COMMITTED"
+	statuses.statuses["invalid"] = "INVALID"
+	if err := tracker.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if tracker.balances[0] != 9 || tracker.balances[1] != 0 {
+		t.Fatalf("got balances %v, want [9 0]", tracker.balances)
+	}
+	if tracker.outstanding() != 1 || tracker.committed != 3 || tracker.invalid != 2 {
+		t.Fatalf("got %d outstanding, %d committed, %d invalid, want 1, 3 and 2", tracker.outstanding(),
+			tracker.committed, tracker.invalid)
+	}
+	if len(tracker.latencies) != 1 || tracker.latencies[0] < time.Second {
+		t.Fatalf("got latencies %v, want one of at least a second", tracker.latencies)
+	}
+
+	report := &benchReport{Transactions: 8}
+	tracker.summarize(report, 2*time.Second)
+	if report.Committed != 3 || report.Invalid != 2 || report.Unresolved != 3 || report.StatusRequests != 1 {
+		t.Fatalf("got %+v", report)
+	}
+	if report.InvalidRate != 0.25 || report.SubmittedTPS != 4 || report.CommittedTPS != 1.5 {
+		t.Fatalf("got invalid rate %v, %v tx/s submitted and %v committed, want 0.25, 4 and 1.5",
+			report.InvalidRate, report.SubmittedTPS, report.CommittedTPS)
+	}
+	if l := report.CommitLatency; l.P50 != l.Max || l.Mean != l.Max || math.Abs(l.Max-1000) > 500 {
+		t.Fatalf("got latencies %+v, want about a second", l)
+	}
+
+	// Nothing submitted has no invalid rate
+	empty := &benchReport{}
+	newBenchTracker(client, 1).summarize(empty, time.Second)
+	if empty.InvalidRate != 0 || empty.CommitLatency != (benchLatency{}) {
+		t.Fatalf("got %+v", empty)
+	}
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
-	gobytes "bytes"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -16,11 +18,17 @@ import (
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
 	"github.com/hyperledger/sawtooth-sdk-go/signing"
-	yaml "gopkg.in/yaml.v2"
 )
 
 const familyName = "cookiejar"
 
+// errNotFound is returned when the REST API responds with 404
+var errNotFound = errors.New("Not found")
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
 // hexdigist returns a string version of the sha512 hash of the input
 func hexdigest(str string) string {
 	hash := sha512.New()
@@ -31,8 +39,15 @@ func hexdigest(str string) string {
 
 // CookiejarClient is the client object which allows communication with the sawtooth network
 type CookiejarClient struct {
-	url    string
-	signer *signing.Signer
+	endpoints     *endpoints
+	signer        *signing.Signer
+	jar           string // Public key of the owner of the jar to read
+	familyVersion string
+	wait          uint
+	http          *http.Client
+	retries       uint
+	auth          AuthConfig
+	transport     transport
 }
 
 // getPrefix returns the 6 character prefix based upon the transaction family name
@@ -42,152 +57,86 @@ func (c *CookiejarClient) getPrefix() string {
 
 // getAddress returns a composite key based upon the namespace prefix and the user's address
 func (c *CookiejarClient) getAddress() string {
-	hashedName := hexdigest(c.signer.GetPublicKey().AsHex())
-	return c.getPrefix() + hashedName[:64]
-}
-
-// sendRequest sends the request to the Sawtooth network
-func (c *CookiejarClient) sendRequest(suffix, contentType string, data []byte) ([]byte, error) {
-	// Create the url
-	var url string
-	if strings.HasPrefix(c.url, "http://") {
-		url = fmt.Sprintf("%s/%s", c.url, suffix)
-	} else {
-		url = fmt.Sprintf("http://%s/%s", c.url, suffix)
-	}
-
-	// Send the request and get the response
-	var response *http.Response
-	var err error
-	if len(data) > 0 {
-		// If there is data, we'll send a POST request
-		response, err = http.Post(url, contentType, gobytes.NewBuffer(data))
-	} else {
-		// Else we'll send a GET request
-		response, err = http.Get(url)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to REST API: %v", err)
-	}
-
-	// Check for potential errors
-	if response.StatusCode == http.StatusNotFound {
-		logger.Debug(err.Error())
-		return nil, fmt.Errorf("Not found")
-	} else if response.StatusCode >= http.StatusBadRequest {
-		return nil, fmt.Errorf("Error %d: %s", response.StatusCode, response.Status)
-	}
-	defer response.Body.Close() // Ensure the body will be closed once done
-
-	// Get the response body
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Error reading response: %v", err)
-	}
-
-	return body, nil
-}
-
-// getResponseMap returns a response as a map
-func (c *CookiejarClient) getResponseMap(response []byte) (map[interface{}]interface{}, error) {
-	responseMap := make(map[interface{}]interface{})
-	if err := yaml.Unmarshal(response, &responseMap); err != nil {
-		return nil, err
-	}
-
-	return responseMap, nil
+	return c.getJarAddress(c.signer.GetPublicKey().AsHex())
 }
 
-// getData returns the data element (from the response map) in a result
-func (c *CookiejarClient) getData(response []byte, i uint) (map[interface{}]interface{}, error) {
-	responseMap, err := c.getResponseMap(response)
-	if err != nil {
-		return nil, err
-	}
-
-	data := responseMap["data"].([]interface{})[i].(map[interface{}]interface{})
-	return data, nil
+// getJarAddress returns the address of the jar owned by the provided public key
+func (c *CookiejarClient) getJarAddress(publicKey string) string {
+	hashedName := hexdigest(publicKey)
+	return c.getPrefix() + hashedName[:64]
 }
 
-// getStatus reads the status of a transaction from the Sawtooth network
-func (c *CookiejarClient) getStatus(batchID string, wait uint) (string, error) {
-	// Create the URL suffix
-	suffix := fmt.Sprintf("%s?id=%s&wait=%d", "batch_statuses", batchID, wait)
-
-	// Send the request
-	response, err := c.sendRequest(suffix, "", []byte{})
-	if err != nil {
-		return "", err
+// waitForStatus will wait and keep probing whether a batch's status changed from PENDING or until timeout
+func (c *CookiejarClient) waitForStatus(batchID string, timeout uint) (*batchStatus, error) {
+	// Without a timeout the status is read once, a timer of 0 would race the request
+	if timeout == 0 {
+		return c.getBatchStatus(batchID, 0)
 	}
 
-	// Get the data element from the respone's response map
-	data, err := c.getData(response, 0)
-	if err != nil {
-		return "", fmt.Errorf("Error reading response: %v", err)
-	}
-
-	// Return the status as a string
-	return data["status"].(string), nil
-}
-
-// waitForStatus will wait and keep probing whether a transaction's status changed from PENDING or until timeout
-func (c *CookiejarClient) waitForStatus(batchID string, timeout uint) (string, error) {
 	// Create a go channel for a response and error
-	resChan := make(chan map[interface{}]interface{}, 1)
+	resChan := make(chan *batchStatus, 1)
 	errChan := make(chan error, 1)
 
 	// Launch a goroutine
 	go func() {
-		// Create a suffix
-		suffix := fmt.Sprintf("batch_statuses?id=%s&wait=%d", batchID, timeout)
-
-		// Get the status from the server
-		b, err := c.sendRequest(suffix, "application/octet-stream", []byte{})
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		// Store the whole response
-		res, err := c.getData(b, 0)
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		// Convert the status to a string
-		status := res["status"].(string)
-
-		// If a timeout is set and the status is PENDING, keep polling
-		for timeout > 0 && status == "PENDING" {
-			var err error
-			status, err = c.getStatus(batchID, timeout)
+		for {
+			// Get the status from the network
+			status, err := c.getBatchStatus(batchID, timeout)
 			if err != nil {
-				// Something went wrong, send the error via the channel and end the goroutine
 				errChan <- err
 				return
 			}
-		}
 
-		// Send the response to the channel
-		resChan <- res
+			// Keep polling while the status is PENDING
+			if status.Status != "PENDING" {
+				resChan <- status
+				return
+			}
+		}
 	}()
 
 	// Keep waiting until we got some response from the goroutine or a timeout
 	select {
 	case res := <-resChan:
-		return fmt.Sprintf("%#v", res), nil
+		return res, nil
 	case err := <-errChan:
-		return "", err
+		return nil, err
 	case <-time.After(time.Duration(timeout) * time.Second):
-		return "", fmt.Errorf("timeout")
+		return nil, fmt.Errorf("timeout")
 	}
 }
 
-// wrapAndSend will wrap a payload into a batchlist and sends it to the Sawtooth network
-func (c *CookiejarClient) wrapAndSend(action string, amount int, timeout uint) (string, error) {
-	rand.Seed(time.Now().UnixNano())
+// getBatchStatus returns the status of a batch, waiting up to wait seconds for it to be committed
+func (c *CookiejarClient) getBatchStatus(batchID string, wait uint) (*batchStatus, error) {
+	statuses, err := c.transport.batchStatuses([]string{batchID}, wait)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := statuses[batchID]
+	if !ok {
+		return nil, fmt.Errorf("No status received for batch %s", batchID)
+	}
+
+	return status, nil
+}
+
+// parsePayload decodes a CSV payload into its action and amount
+func parsePayload(payload string) (string, int, error) {
+	fields := strings.Split(payload, ",")
+	if len(fields) != 2 {
+		return "", 0, fmt.Errorf("Malformed payload %q", payload)
+	}
 
+	amount, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("Couldn't parse amount: %v", err)
+	}
+
+	return fields[0], amount, nil
+}
+
+// newTransactionHeader returns the header and payload of a transaction, which isn't signed yet
+func (c *CookiejarClient) newTransactionHeader(action string, amount int) (*transaction_pb2.TransactionHeader, string) {
 	// We're using CSV encoding
 	payload := strings.Join([]string{action, strconv.Itoa(amount)}, ",")
 
@@ -200,7 +149,7 @@ func (c *CookiejarClient) wrapAndSend(action string, amount int, timeout uint) (
 	rawTransactionsHeader := transaction_pb2.TransactionHeader{
 		SignerPublicKey:  pubKey,
 		FamilyName:       familyName,
-		FamilyVersion:    "1.0",
+		FamilyVersion:    c.familyVersion,
 		Inputs:           addressList, // Important for parallel processing
 		Outputs:          addressList, // Important for parallel processing
 		PayloadSha512:    hexdigest(payload),
@@ -208,68 +157,154 @@ func (c *CookiejarClient) wrapAndSend(action string, amount int, timeout uint) (
 		Nonce:            strconv.Itoa(rand.Int()),
 	}
 
+	return &rawTransactionsHeader, payload
+}
+
+// newTransaction creates a signed cookiejar transaction for the provided action and amount
+func (c *CookiejarClient) newTransaction(action string, amount int) (*transaction_pb2.Transaction, error) {
+	rawTransactionsHeader, payload := c.newTransactionHeader(action, amount)
+
 	// Serialize the raw transaction
-	transactionHeader, err := proto.Marshal(&rawTransactionsHeader)
+	transactionHeader, err := proto.Marshal(rawTransactionsHeader)
 	if err != nil {
-		return "", fmt.Errorf("Unable to serialize transaction header: %v", err)
+		return nil, fmt.Errorf("Unable to serialize transaction header: %v", err)
 	}
 
 	// Create the signature for the transaction header
 	transactionHeaderSignature := hex.EncodeToString(c.signer.Sign(transactionHeader))
 
-	// Create a list of transactions
-	transactions := []*transaction_pb2.Transaction{
-		&transaction_pb2.Transaction{
-			Header:          transactionHeader,
-			HeaderSignature: transactionHeaderSignature,
-			Payload:         []byte(payload),
-		},
+	return &transaction_pb2.Transaction{
+		Header:          transactionHeader,
+		HeaderSignature: transactionHeaderSignature,
+		Payload:         []byte(payload),
+	}, nil
+}
+
+// newBatch wraps the transactions into a batch signed by the client's signer
+func (c *CookiejarClient) newBatch(transactions []*transaction_pb2.Transaction) (*batch_pb2.Batch, error) {
+	transactionIds := make([]string, 0, len(transactions))
+	for _, t := range transactions {
+		transactionIds = append(transactionIds, t.HeaderSignature)
 	}
 
 	// Create the batch header
 	rawBatchHeader := batch_pb2.BatchHeader{
-		SignerPublicKey: pubKey,
-		TransactionIds:  []string{transactions[0].HeaderSignature},
+		SignerPublicKey: c.signer.GetPublicKey().AsHex(),
+		TransactionIds:  transactionIds,
 	}
 
 	// Encode the batch header
 	batchHeader, err := proto.Marshal(&rawBatchHeader)
 	if err != nil {
-		return "", fmt.Errorf("Unable to serialize batch header: %v", err)
+		return nil, fmt.Errorf("Unable to serialize batch header: %v", err)
 	}
 
 	// Create the batch header signature
 	batchHeaderSignature := hex.EncodeToString(c.signer.Sign(batchHeader))
 
-	// Create a batchlist
-	rawBatchList := batch_pb2.BatchList{
-		Batches: []*batch_pb2.Batch{
-			&batch_pb2.Batch{
-				Header:          batchHeader,
-				Transactions:    transactions,
-				HeaderSignature: batchHeaderSignature,
-			},
-		},
+	return &batch_pb2.Batch{
+		Header:          batchHeader,
+		Transactions:    transactions,
+		HeaderSignature: batchHeaderSignature,
+	}, nil
+}
+
+// marshalBatchList encodes the batches as a batch list
+func marshalBatchList(batches []*batch_pb2.Batch) ([]byte, error) {
+	batchList, err := proto.Marshal(&batch_pb2.BatchList{Batches: batches})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize batch list: %v", err)
+	}
+
+	return batchList, nil
+}
+
+// submitBatches sends the batches to the Sawtooth network
+func (c *CookiejarClient) submitBatches(batches []*batch_pb2.Batch) error {
+	return c.transport.submitBatches(batches)
+}
+
+// wrapAndSend will wrap a payload into a batchlist and sends it to the Sawtooth network
+func (c *CookiejarClient) wrapAndSend(action string, amount int, timeout uint) (string, error) {
+	transaction, err := c.newTransaction(action, amount)
+	if err != nil {
+		return "", err
 	}
 
-	// Encode the batch list
-	batchList, err := proto.Marshal(&rawBatchList)
+	batch, err := c.newBatch([]*transaction_pb2.Transaction{transaction})
 	if err != nil {
-		return "", fmt.Errorf("Unable to serialize batch list: %v", err)
+		return "", err
 	}
 
-	// Send the request
-	if _, err := c.sendRequest("batches", "application/octet-stream", batchList); err != nil {
+	if err := c.submitBatches([]*batch_pb2.Batch{batch}); err != nil {
 		return "", err
 	}
 
 	// Wait for the status to change
-	return c.waitForStatus(batchHeaderSignature, timeout)
+	status, err := c.waitForStatus(batch.HeaderSignature, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return status.String(), nil
 }
 
-// NewCookiejarClient returns an initialized cookiejar client
-func NewCookiejarClient(url string, keyFile string) (*CookiejarClient, error) {
+// getPublicKey resolves a jar to the public key of its owner. A jar is either the name of a locally stored key or a public key in hex
+func getPublicKey(jar string) (string, error) {
+	if _, err := hex.DecodeString(jar); err == nil && len(jar) == 66 {
+		return jar, nil
+	}
+
+	keyFile, err := getPrivateKeyFile(fmt.Sprintf("%s.pub", jar))
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read public key of jar %s: %v", jar, err)
+	}
+
+	return strings.TrimSpace(string(publicKey)), nil
+}
+
+// localKeyNames returns the names of all locally stored keys
+func localKeyNames() []string {
+	keyFile, _ := getPrivateKeyFile("*.pub")
+	files, _ := filepath.Glob(keyFile)
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(f), ".pub"))
+	}
+
+	return names
+}
+
+// localJars returns the addresses of the jars owned by the locally stored keys, mapped to the key names
+func (c *CookiejarClient) localJars() map[string]string {
+	jars := map[string]string{}
+	for _, name := range localKeyNames() {
+		if publicKey, err := getPublicKey(name); err == nil {
+			jars[c.getJarAddress(publicKey)] = name
+		}
+	}
+
+	return jars
+}
+
+// loadSigner returns a signer for the locally stored key with the provided name, or for a random key if the name is empty
+func loadSigner(keyName string) (*signing.Signer, error) {
 	// Get the locally stored private key
+	keyFile := ""
+	if keyName != "" {
+		var err error
+		keyFile, err = getPrivateKeyFile(fmt.Sprintf("%s.priv", keyName))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate filename: %v", err)
+		}
+	}
+
 	var privateKey signing.PrivateKey
 	if keyFile != "" {
 		// Read private key file
@@ -287,7 +322,91 @@ func NewCookiejarClient(url string, keyFile string) (*CookiejarClient, error) {
 	cryptoFactory := signing.NewCryptoFactory(signing.NewSecp256k1Context())
 
 	// Create a signer object via the cryptoFactory
-	signer := cryptoFactory.NewSigner(privateKey)
+	return cryptoFactory.NewSigner(privateKey), nil
+}
+
+// useKey switches the client to sign with the locally stored key with the provided name
+func (c *CookiejarClient) useKey(keyName string) error {
+	signer, err := loadSigner(keyName)
+	if err != nil {
+		return err
+	}
+
+	// Keep reading the signer's own jar if no other jar was selected
+	if c.jar == c.signer.GetPublicKey().AsHex() {
+		c.jar = signer.GetPublicKey().AsHex()
+	}
+	c.signer = signer
+
+	return nil
+}
+
+// useJar switches the jar read by the client, which is either a key name or a public key
+func (c *CookiejarClient) useJar(jar string) error {
+	publicKey, err := getPublicKey(jar)
+	if err != nil {
+		return err
+	}
+	c.jar = publicKey
+
+	return nil
+}
+
+// NewCookiejarClient returns an initialized cookiejar client
+func NewCookiejarClient(profile *Profile) (*CookiejarClient, error) {
+	if len(profile.URLs) == 0 {
+		return nil, fmt.Errorf("No REST API URL configured")
+	}
+
+	signer, err := loadSigner(profile.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The jar to read defaults to the signer's own jar
+	jar := signer.GetPublicKey().AsHex()
+	if profile.Jar != "" {
+		if jar, err = getPublicKey(profile.Jar); err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient, err := newHTTPClient(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &CookiejarClient{
+		endpoints:     newEndpoints(profile.URLs),
+		signer:        signer,
+		jar:           jar,
+		familyVersion: profile.FamilyVersion,
+		wait:          *profile.Wait,
+		http:          httpClient,
+		retries:       *profile.Retries,
+		auth:          profile.Auth,
+	}
+
+	// Select how batches are submitted and state is read
+	switch profile.Transport {
+	case "", "rest":
+		client.transport = &restTransport{client}
+	case "zmq":
+		if client.transport, err = newZmqTransport(profile.Connect, time.Duration(*profile.Timeout)*time.Second); err != nil {
+			return nil, err
+		}
+	case "simulate":
+		if client.transport, err = newSimTransport(profile.Simulation, os.Stdout); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Invalid transport %q, use rest, zmq or simulate", profile.Transport)
+	}
+
+	return client, nil
+}
 
-	return &CookiejarClient{url, signer}, nil
+// Close releases the connections held by the client
+func (c *CookiejarClient) Close() {
+	c.transport.close()
 }
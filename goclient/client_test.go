@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client talking to the REST APIs in the order given, without signer
+func newTestClient(urls ...string) *CookiejarClient {
+	c := &CookiejarClient{
+		endpoints:     newEndpoints(urls),
+		familyVersion: defaultFamilyVersion,
+		http:          &http.Client{},
+	}
+	c.endpoints.checked = time.Now() // skip the health checks which would reorder the endpoints
+	c.transport = &restTransport{c}
+
+	return c
+}
+
+// writeJSON answers a request of the client with the value as JSON
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// usageError is returned when a command is invoked with invalid arguments
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// commandNames lists the commands which can be executed with runCommand
+var commandNames = []string{"bake", "eat", "count", "clear", "history", "diff", "watch", "bench", "audit", "verify", "export", "genesis"}
+
+// runCommand executes a single client command and prints its result
+func runCommand(client *CookiejarClient, cmdArgs []string) error {
+	command := strings.ToLower(cmdArgs[0])
+
+	// The simulation has no blockchain to read via the REST API
+	if _, ok := client.transport.(*simTransport); ok {
+		switch command {
+		case "history", "diff", "watch", "audit", "verify", "export":
+			return fmt.Errorf("%s isn't available in simulation mode", command)
+		}
+	}
+	// These commands page through blocks and transactions, which only the REST API serves
+	if _, ok := client.transport.(*zmqTransport); ok {
+		switch command {
+		case "history", "diff", "watch", "audit", "verify", "export":
+			return fmt.Errorf("%s needs the REST API, use --transport rest", command)
+		}
+	}
+
+	// Check the exectured argument
+	switch command {
+	case "bake":
+		params, dryRun, err := parseMutatingArgs("bake", cmdArgs[1:])
+		if err != nil {
+			return err
+		}
+		if len(params) != 1 {
+			return usageError{"bake requires 1 argument"}
+		}
+
+		// Convert the amount to int
+		amount, err := strconv.Atoi(params[0])
+		if err != nil {
+			return usageError{err.Error()}
+		}
+
+		// Only show what would happen
+		if dryRun {
+			return client.printDryRun("bake", amount)
+		}
+
+		// Execute the action
+		resp, err := client.bake(amount)
+		if err != nil {
+			return fmt.Errorf("Failed to register baked cookies: %v", err)
+		}
+
+		fmt.Println(resp)
+	case "eat":
+		params, dryRun, err := parseMutatingArgs("eat", cmdArgs[1:])
+		if err != nil {
+			return err
+		}
+		if len(params) != 1 {
+			return usageError{"eat requires 1 argument"}
+		}
+
+		// Convert the amount to int
+		amount, err := strconv.Atoi(params[0])
+		if err != nil {
+			return usageError{err.Error()}
+		}
+
+		// Only show what would happen
+		if dryRun {
+			return client.printDryRun("eat", amount)
+		}
+
+		// Execute the action
+		resp, err := client.eat(amount)
+		if err != nil {
+			return fmt.Errorf("Failed to register eating cookies: %v", err)
+		}
+
+		fmt.Println(resp)
+	case "count":
+		flags := flag.NewFlagSet("count", flag.ContinueOnError)
+		at := flags.String("at", "", "block id or number to count at (default: the current head)")
+		if err := flags.Parse(cmdArgs[1:]); err != nil {
+			return usageError{err.Error()}
+		}
+
+		// Resolve the block to read the state at
+		head := ""
+		if *at != "" {
+			if _, ok := client.transport.(*zmqTransport); ok {
+				return fmt.Errorf("count --at needs the REST API, use --transport rest")
+			}
+			var err error
+			if head, err = client.resolveBlock(*at); err != nil {
+				return fmt.Errorf("Failed to resolve block: %v", err)
+			}
+		}
+
+		// Execture the action
+		resp, err := client.count(head)
+		if err != nil {
+			return fmt.Errorf("Failed to count cookies: %v", err)
+		}
+
+		fmt.Println(resp)
+	case "clear":
+		params, dryRun, err := parseMutatingArgs("clear", cmdArgs[1:])
+		if err != nil {
+			return err
+		}
+		if len(params) != 0 {
+			return usageError{"clear doesn't take arguments"}
+		}
+
+		// Only show what would happen
+		if dryRun {
+			return client.printDryRun("clear", 0)
+		}
+
+		// Excecute the action
+		if err := client.clear(); err != nil {
+			return fmt.Errorf("Failed to clear the cookie jar: %v", err)
+		}
+	case "history":
+		if err := cmdHistory(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Failed to read jar history: %v", err)
+		}
+	case "diff":
+		if err := cmdDiff(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Failed to compare blocks: %v", err)
+		}
+	case "watch":
+		if err := cmdWatch(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Failed to watch jars: %v", err)
+		}
+	case "bench":
+		if err := cmdBench(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Benchmark failed: %v", err)
+		}
+	case "audit":
+		if err := cmdAudit(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Audit failed: %v", err)
+		}
+	case "verify":
+		if err := cmdVerify(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Verification failed: %v", err)
+		}
+	case "export":
+		if err := cmdExport(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Export failed: %v", err)
+		}
+	case "genesis":
+		if err := cmdGenesis(client, cmdArgs[1:]); err != nil {
+			return fmt.Errorf("Failed to create genesis batch: %v", err)
+		}
+	default:
+		return usageError{"Invalid command"}
+	}
+
+	return nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	defaultProfile       = "default"
+	defaultFamilyVersion = "1.0"
+	defaultWait          = 10
+	defaultTransport     = "rest"
+	defaultConnect       = "tcp://validator:4004"
+	defaultTimeout       = 30
+	defaultRetries       = 3
+)
+
+// TLSConfig holds the TLS settings used to connect to a REST API over HTTPS
+type TLSConfig struct {
+	CA       string `yaml:"ca,omitempty"`
+	Cert     string `yaml:"cert,omitempty"`
+	Key      string `yaml:"key,omitempty"`
+	Insecure bool   `yaml:"insecure,omitempty"`
+}
+
+// AuthConfig holds the credentials sent to the REST API. A token is sent as bearer token, else username
+// and password are sent using basic authentication
+type AuthConfig struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+// Profile holds the settings needed to talk to a single Sawtooth network
+type Profile struct {
+	URLs          []string   `yaml:"urls"`
+	Key           string     `yaml:"key"`
+	Jar           string     `yaml:"jar,omitempty"`
+	FamilyVersion string     `yaml:"family_version"`
+	Wait          *uint      `yaml:"wait,omitempty"` // nil falls back to the default, 0 doesn't wait
+	TLS           TLSConfig  `yaml:"tls,omitempty"`
+	Transport     string     `yaml:"transport,omitempty"`
+	Connect       string     `yaml:"connect,omitempty"`
+	Timeout       *uint      `yaml:"timeout,omitempty"` // 0 disables the timeout
+	Retries       *uint      `yaml:"retries,omitempty"` // 0 disables the retries
+	Auth          AuthConfig `yaml:"auth,omitempty"`
+	Simulation    string     `yaml:"simulation,omitempty"`
+}
+
+// Config is the content of the client configuration file
+type Config struct {
+	Current  string              `yaml:"current"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// newProfile returns a profile holding the default settings
+func newProfile() *Profile {
+	return &Profile{
+		URLs:          []string{defaultURL},
+		Key:           keyName,
+		FamilyVersion: defaultFamilyVersion,
+		Wait:          uintPtr(defaultWait),
+		Transport:     defaultTransport,
+		Connect:       defaultConnect,
+		Timeout:       uintPtr(defaultTimeout),
+		Retries:       uintPtr(defaultRetries),
+	}
+}
+
+// uintPtr returns a pointer to the value, for the settings where 0 differs from unset
+func uintPtr(v uint) *uint {
+	return &v
+}
+
+// uintString formats an optional setting, empty if unset
+func uintString(v *uint) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(*v), 10)
+}
+
+// configPath returns the location of the configuration file, which can be overridden with CJ_CONFIG
+func configPath() string {
+	if v := os.Getenv("CJ_CONFIG"); v != "" {
+		return v
+	}
+
+	return path.Join(UserHomeDir(), ".config", "cookiejar", "config.yaml")
+}
+
+// loadConfig reads the configuration file. A missing file results in a configuration with only the default profile
+func loadConfig(file string) (*Config, error) {
+	config := &Config{
+		Current:  defaultProfile,
+		Profiles: map[string]*Profile{},
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read config file: %v", err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(b, config); err != nil {
+			return nil, fmt.Errorf("Failed to parse config file %s: %v", file, err)
+		}
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = map[string]*Profile{}
+	}
+	if _, ok := config.Profiles[defaultProfile]; !ok {
+		config.Profiles[defaultProfile] = newProfile()
+	}
+	if config.Current == "" {
+		config.Current = defaultProfile
+	}
+
+	return config, nil
+}
+
+// save writes the configuration to file, creating the directory when needed
+func (c *Config) save(file string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("Failed to serialize config: %v", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return fmt.Errorf("Failed to create config directory: %v", err)
+	}
+
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+// profileNames returns the sorted names of all configured profiles
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// profile returns a copy of the named profile, or the current one if name is empty, with the environment overrides applied
+func (c *Config) profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.Current
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown profile %q", name)
+	}
+
+	// Start from the defaults so that settings missing in the file still have a sane value
+	profile := newProfile()
+	if len(p.URLs) > 0 {
+		profile.URLs = append([]string{}, p.URLs...)
+	}
+	if p.Key != "" {
+		profile.Key = p.Key
+	}
+	if p.FamilyVersion != "" {
+		profile.FamilyVersion = p.FamilyVersion
+	}
+	if p.Wait != nil {
+		profile.Wait = uintPtr(*p.Wait)
+	}
+	if p.Transport != "" {
+		profile.Transport = p.Transport
+	}
+	if p.Connect != "" {
+		profile.Connect = p.Connect
+	}
+	if p.Timeout != nil {
+		profile.Timeout = uintPtr(*p.Timeout)
+	}
+	if p.Retries != nil {
+		profile.Retries = uintPtr(*p.Retries)
+	}
+	profile.Jar = p.Jar
+	profile.TLS = p.TLS
+	profile.Auth = p.Auth
+	profile.Simulation = p.Simulation
+
+	if err := profile.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// profileEnv maps the environment variables that override profile settings to their setting key
+var profileEnv = [][2]string{
+	{"CJ_URL", "url"},
+	{"CJ_KEY", "key"},
+	{"CJ_JAR", "jar"},
+	{"CJ_FAMILY_VERSION", "family_version"},
+	{"CJ_WAIT", "wait"},
+	{"CJ_TLS_CA", "tls.ca"},
+	{"CJ_TLS_CERT", "tls.cert"},
+	{"CJ_TLS_KEY", "tls.key"},
+	{"CJ_TLS_INSECURE", "tls.insecure"},
+	{"CJ_TRANSPORT", "transport"},
+	{"CJ_CONNECT", "connect"},
+	{"CJ_TIMEOUT", "timeout"},
+	{"CJ_RETRIES", "retries"},
+	{"CJ_AUTH_USERNAME", "auth.username"},
+	{"CJ_AUTH_PASSWORD", "auth.password"},
+	{"CJ_AUTH_TOKEN", "auth.token"},
+	{"CJ_SIMULATION", "simulation"},
+}
+
+// applyEnv overrides the profile's settings with the ones found in the environment
+func (p *Profile) applyEnv() error {
+	for _, e := range profileEnv {
+		if v := os.Getenv(e[0]); v != "" {
+			if err := p.set(e[1], v); err != nil {
+				return fmt.Errorf("Invalid value for %s: %v", e[0], err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// profileKeys lists the settings which can be read and written with get and set
+var profileKeys = []string{
+	"url", "key", "jar", "family_version", "wait", "tls.ca", "tls.cert", "tls.key", "tls.insecure", "transport", "connect",
+	"timeout", "retries", "auth.username", "auth.password", "auth.token", "simulation",
+}
+
+// secretKeys are the settings which config get only prints when asked for by name
+var secretKeys = map[string]bool{"auth.password": true, "auth.token": true}
+
+// get returns the value of a single setting as a string
+func (p *Profile) get(key string) (string, error) {
+	switch key {
+	case "url", "urls":
+		return strings.Join(p.URLs, ","), nil
+	case "key":
+		return p.Key, nil
+	case "jar":
+		return p.Jar, nil
+	case "family_version":
+		return p.FamilyVersion, nil
+	case "wait":
+		return uintString(p.Wait), nil
+	case "tls.ca":
+		return p.TLS.CA, nil
+	case "tls.cert":
+		return p.TLS.Cert, nil
+	case "tls.key":
+		return p.TLS.Key, nil
+	case "tls.insecure":
+		return strconv.FormatBool(p.TLS.Insecure), nil
+	case "transport":
+		return p.Transport, nil
+	case "connect":
+		return p.Connect, nil
+	case "timeout":
+		return uintString(p.Timeout), nil
+	case "retries":
+		return uintString(p.Retries), nil
+	case "auth.username":
+		return p.Auth.Username, nil
+	case "auth.password":
+		return p.Auth.Password, nil
+	case "auth.token":
+		return p.Auth.Token, nil
+	case "simulation":
+		return p.Simulation, nil
+	default:
+		return "", fmt.Errorf("Unknown setting %q", key)
+	}
+}
+
+// set updates a single setting from its string representation
+func (p *Profile) set(key, value string) error {
+	switch key {
+	case "url", "urls":
+		p.URLs = nil
+		for _, u := range strings.Split(value, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				p.URLs = append(p.URLs, u)
+			}
+		}
+	case "key":
+		p.Key = value
+	case "jar":
+		p.Jar = value
+	case "family_version":
+		p.FamilyVersion = value
+	case "wait":
+		wait, err := strconv.Atoi(value)
+		if err != nil || wait < 0 {
+			return fmt.Errorf("wait must be a positive number of seconds")
+		}
+		p.Wait = uintPtr(uint(wait))
+	case "tls.ca":
+		p.TLS.CA = value
+	case "tls.cert":
+		p.TLS.Cert = value
+	case "tls.key":
+		p.TLS.Key = value
+	case "tls.insecure":
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		p.TLS.Insecure = insecure
+	case "transport":
+		if value != "rest" && value != "zmq" && value != "simulate" {
+			return fmt.Errorf("transport must be rest, zmq or simulate")
+		}
+		p.Transport = value
+	case "connect":
+		p.Connect = value
+	case "timeout":
+		timeout, err := strconv.Atoi(value)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("timeout must be a positive number of seconds")
+		}
+		p.Timeout = uintPtr(uint(timeout))
+	case "retries":
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("retries must be a positive number")
+		}
+		p.Retries = uintPtr(uint(retries))
+	case "auth.username":
+		p.Auth.Username = value
+	case "auth.password":
+		p.Auth.Password = value
+	case "auth.token":
+		p.Auth.Token = value
+	case "simulation":
+		p.Simulation = value
+	default:
+		return fmt.Errorf("Unknown setting %q", key)
+	}
+
+	return nil
+}
+
+// runConfig executes the config sub commands
+func runConfig(config *Config, file, profileName string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("config requires a sub command: get, set or use")
+	}
+
+	if profileName == "" {
+		profileName = config.Current
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "get":
+		profile, err := config.profile(profileName)
+		if err != nil {
+			return err
+		}
+
+		// Print a single setting
+		if len(args) == 2 {
+			value, err := profile.get(args[1])
+			if err != nil {
+				return err
+			}
+			fmt.Println(value)
+			return nil
+		}
+
+		// Print all settings of the profile, the secrets only when asked for by name
+		fmt.Printf("profile: %s\n", profileName)
+		for _, key := range profileKeys {
+			value, _ := profile.get(key)
+			if secretKeys[key] && value != "" {
+				value = "****"
+			}
+			fmt.Printf("%s: %s\n", key, value)
+		}
+		fmt.Printf("profiles: %s (current: %s)\n", strings.Join(config.profileNames(), ", "), config.Current)
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("config set requires a setting and a value")
+		}
+
+		// Setting a value on an unknown profile creates it
+		profile, ok := config.Profiles[profileName]
+		if !ok {
+			profile = newProfile()
+			config.Profiles[profileName] = profile
+		}
+		if err := profile.set(args[1], args[2]); err != nil {
+			return err
+		}
+
+		return config.save(file)
+	case "use":
+		if len(args) != 2 {
+			return fmt.Errorf("config use requires a profile name")
+		}
+		if _, ok := config.Profiles[args[1]]; !ok {
+			return fmt.Errorf("Unknown profile %q", args[1])
+		}
+		config.Current = args[1]
+
+		return config.save(file)
+	default:
+		return fmt.Errorf("Invalid config command %q", args[0])
+	}
+
+	return nil
+}
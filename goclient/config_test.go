@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// setEnv sets the environment variables, clearing the other overrides, and returns a function restoring them
+func setEnv(vars map[string]string) func() {
+	saved := map[string]string{}
+	for _, e := range profileEnv {
+		saved[e[0]] = os.Getenv(e[0])
+		os.Unsetenv(e[0])
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+
+	return func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	config := &Config{Current: "dev", Profiles: map[string]*Profile{
+		"default": newProfile(),
+		"dev":     {URLs: []string{"http://dev:8008"}, Key: "dev", Wait: uintPtr(0)},
+	}}
+
+	cases := []struct {
+		name    string
+		profile string
+		env     map[string]string
+		key     string
+		want    string
+	}{
+		{"default setting", "dev", nil, "family_version", defaultFamilyVersion},
+		{"profile setting", "dev", nil, "url", "http://dev:8008"},
+		{"wait of 0 kept", "dev", nil, "wait", "0"},
+		{"current profile", "", nil, "key", "dev"},
+		{"other profile", "default", nil, "key", keyName},
+		{"environment over profile", "dev", map[string]string{"CJ_URL": "http://env:8008"}, "url", "http://env:8008"},
+		{"environment over default", "dev", map[string]string{"CJ_RETRIES": "0"}, "retries", "0"},
+		{"empty environment ignored", "dev", map[string]string{"CJ_KEY": ""}, "key", "dev"},
+	}
+	for _, c := range cases {
+		restore := setEnv(c.env)
+		profile, err := config.profile(c.profile)
+		restore()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got, _ := profile.get(c.key); got != c.want {
+			t.Errorf("%s: got %s %q, want %q", c.name, c.key, got, c.want)
+		}
+	}
+
+	// The overrides don't leak into the configuration
+	if config.Profiles["dev"].Retries != nil {
+		t.Fatal("the environment changed the stored profile")
+	}
+
+	restore := setEnv(map[string]string{"CJ_WAIT": "soon"})
+	defer restore()
+	if _, err := config.profile("dev"); err == nil || !strings.Contains(err.Error(), "CJ_WAIT") {
+		t.Fatalf("got %v, want the invalid variable", err)
+	}
+}
+
+// captureStdout returns what fn printed
+func captureStdout(t *testing.T, fn func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+
+	return string(b)
+}
+
+func TestConfigGetMasksSecrets(t *testing.T) {
+	defer setEnv(nil)()
+	profile := newProfile()
+	profile.Auth = AuthConfig{Username: "alice", Password: "hunter2", Token: "t0k3n"}
+	config := &Config{Current: "default", Profiles: map[string]*Profile{"default": profile}}
+
+	out := captureStdout(t, func() error { return runConfig(config, "", "", []string{"get"}) })
+	if strings.Contains(out, "hunter2") || strings.Contains(out, "t0k3n") {
+		t.Fatalf("got secrets in\n%s", out)
+	}
+	if !strings.Contains(out, "auth.password: ****\n") || !strings.Contains(out, "auth.username: alice\n") {
+		t.Fatalf("got\n%s\nwant the masked password and the username", out)
+	}
+
+	// A secret asked for by name is printed
+	out = captureStdout(t, func() error { return runConfig(config, "", "", []string{"get", "auth.token"}) })
+	if out != "t0k3n\n" {
+		t.Fatalf("got %q, want the token", out)
+	}
+}
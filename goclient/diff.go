@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// jarChange describes how the amount of cookies at a jar address changed between two blocks
+type jarChange struct {
+	Address string
+	From    int
+	To      int
+	Created bool
+	Deleted bool
+}
+
+// jarCounts returns the amount of cookies for every cookiejar address in the state of the provided block
+func (c *CookiejarClient) jarCounts(head string) (map[string]int, error) {
+	counts := map[string]int{}
+	var decodeErr error
+	if _, err := c.listState(c.getPrefix(), head, func(e *restStateEntry) bool {
+		data, err := base64.StdEncoding.DecodeString(e.Data)
+		if err == nil {
+			counts[e.Address], err = strconv.Atoi(string(data))
+		}
+		if err != nil {
+			decodeErr = fmt.Errorf("Invalid jar at %s: %v", e.Address, err)
+			return false
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	return counts, decodeErr
+}
+
+// diff returns the changes of all cookiejar addresses between the from and to blocks, sorted by address
+func (c *CookiejarClient) diff(from, to string) ([]jarChange, error) {
+	before, err := c.jarCounts(from)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := c.jarCounts(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []jarChange
+	for address, count := range after {
+		old, ok := before[address]
+		if !ok || old != count {
+			changes = append(changes, jarChange{Address: address, From: old, To: count, Created: !ok})
+		}
+	}
+	for address, count := range before {
+		if _, ok := after[address]; !ok {
+			changes = append(changes, jarChange{Address: address, From: count, Deleted: true})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Address < changes[j].Address })
+
+	return changes, nil
+}
+
+// cmdDiff executes the diff command
+func cmdDiff(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	from := flags.String("from", "", "block id or number to compare from")
+	to := flags.String("to", "", "block id or number to compare to (default: the current head)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("diff requires --from")
+	}
+
+	fromID, err := client.resolveBlock(*from)
+	if err != nil {
+		return err
+	}
+
+	toID := ""
+	if *to != "" {
+		if toID, err = client.resolveBlock(*to); err != nil {
+			return err
+		}
+	}
+
+	changes, err := client.diff(fromID, toID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tFROM\tTO\tCHANGE")
+	for _, c := range changes {
+		change := fmt.Sprintf("%+d", c.To-c.From)
+		if c.Created {
+			change = "created"
+		} else if c.Deleted {
+			change = "deleted"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", c.Address, c.From, c.To, change)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// blockID returns a block id made of the character
+func blockID(c string) string {
+	return strings.Repeat(c, 128)
+}
+
+func TestResolveBlock(t *testing.T) {
+	// The blocks are listed newest first, over two pages
+	pages := map[string][]restBlock{
+		"": {
+			{Header: restBlockHeader{BlockNum: 3}, HeaderSignature: blockID("3")},
+			{Header: restBlockHeader{BlockNum: 2}, HeaderSignature: blockID("2")},
+		},
+		"page2": {
+			{Header: restBlockHeader{BlockNum: 1}, HeaderSignature: blockID("1")},
+			{Header: restBlockHeader{BlockNum: 0}, HeaderSignature: blockID("0")},
+		},
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/blocks" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		start := r.URL.Query().Get("start")
+		next := ""
+		if start == "" {
+			next = "page2"
+		}
+		writeJSON(t, w, map[string]interface{}{"data": pages[start],
+			"paging": map[string]string{"next_position": next}})
+	}))
+	defer server.Close()
+	c := newTestClient(server.URL)
+
+	cases := []struct {
+		ref      string
+		want     string
+		err      string
+		requests int
+	}{
+		// Ids are used as they are
+		{blockID("a"), blockID("a"), "", 0},
+		{"3", blockID("3"), "", 1},
+		{"1", blockID("1"), "", 2},
+		{"0", blockID("0"), "", 2},
+		{"7", "", "Block 7 not found", 1},
+		{"abc", "", "Invalid block", 0},
+		{"-1", "", "Invalid block", 0},
+	}
+	for _, tc := range cases {
+		requests = 0
+		id, err := c.resolveBlock(tc.ref)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got %v, want %q", tc.ref, err, tc.err)
+			}
+		} else if err != nil || id != tc.want {
+			t.Errorf("%s: got %s, %v, want %s", tc.ref, id, err, tc.want)
+		}
+		if requests != tc.requests {
+			t.Errorf("%s: got %d requests, want %d", tc.ref, requests, tc.requests)
+		}
+	}
+}
+
+func TestDiffClassifiesChanges(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	states := map[string][]restStateEntry{
+		blockID("1"): {
+			{Address: "a4d21901", Data: encode("5")},
+			{Address: "a4d21902", Data: encode("3")},
+			{Address: "a4d21903", Data: encode("8")},
+		},
+		blockID("2"): {
+			{Address: "a4d21901", Data: encode("5")},
+			{Address: "a4d21902", Data: encode("1")},
+			{Address: "a4d21904", Data: encode("0")},
+		},
+		blockID("3"): {
+			{Address: "a4d21901", Data: encode("five")},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		head := r.URL.Query().Get("head")
+		entries, ok := states[head]
+		if r.URL.Path != "/state" || !ok {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(t, w, map[string]interface{}{"data": entries, "head": head})
+	}))
+	defer server.Close()
+	c := newTestClient(server.URL)
+
+	cases := []struct {
+		from, to string
+		want     []jarChange
+	}{
+		{blockID("1"), blockID("2"), []jarChange{
+			{Address: "a4d21902", From: 3, To: 1},
+			{Address: "a4d21903", From: 8, Deleted: true},
+			{Address: "a4d21904", To: 0, Created: true},
+		}},
+		{blockID("2"), blockID("1"), []jarChange{
+			{Address: "a4d21902", From: 1, To: 3},
+			{Address: "a4d21903", To: 8, Created: true},
+			{Address: "a4d21904", From: 0, Deleted: true},
+		}},
+		{blockID("1"), blockID("1"), nil},
+	}
+	for _, tc := range cases {
+		changes, err := c.diff(tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("%.1s to %.1s: %v", tc.from, tc.to, err)
+		}
+		if !reflect.DeepEqual(changes, tc.want) {
+			t.Errorf("%.1s to %.1s: got %+v, want %+v", tc.from, tc.to, changes, tc.want)
+		}
+	}
+
+	// A jar which doesn't hold a cookie count can't be compared
+	if _, err := c.diff(blockID("1"), blockID("3")); err == nil || !strings.Contains(err.Error(), "a4d21901") {
+		t.Errorf("got %v, want an invalid jar", err)
+	}
+}
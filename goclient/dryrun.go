@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cookiejar"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/processor"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+)
+
+// dryRunResult is the outcome of a transaction executed locally
+type dryRunResult struct {
+	action  string
+	amount  int
+	before  map[string][]byte
+	receipt *cookiejar.Receipt
+	err     error
+}
+
+// dryRun executes the action with the processor's handler against the current state of the jar, without signing
+// or submitting anything
+func (c *CookiejarClient) dryRun(action string, amount int) (*dryRunResult, error) {
+	header, payload := c.newTransactionHeader(action, amount)
+	rawHeader, err := proto.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize transaction header: %v", err)
+	}
+
+	// Fetch the current state of every address the transaction may read
+	state := map[string][]byte{}
+	for _, address := range header.Inputs {
+		data, err := c.transport.getState(address, "")
+		if err == errNotFound {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read jar: %v", err)
+		}
+		state[address] = data
+	}
+
+	result := &dryRunResult{action: action, amount: amount, before: map[string][]byte{}}
+	for address, data := range state {
+		result.before[address] = data
+	}
+
+	executor := cookiejar.NewExecutor(cookiejar.NewCookiejarHandler(), state)
+	result.receipt, result.err = executor.Execute(&transaction_pb2.Transaction{
+		Header:  rawHeader,
+		Payload: []byte(payload),
+	})
+
+	return result, nil
+}
+
+// printDryRun executes the action locally and prints the would-be result
+func (c *CookiejarClient) printDryRun(action string, amount int) error {
+	result, err := c.dryRun(action, amount)
+	if err != nil {
+		return err
+	}
+
+	return result.write(os.Stdout)
+}
+
+// write prints the would-be state changes and events, and returns the reason why the transaction would fail
+func (r *dryRunResult) write(w io.Writer) error {
+	operation := r.action
+	if r.action != "clear" {
+		operation = fmt.Sprintf("%s %d", r.action, r.amount)
+	}
+
+	switch err := r.err.(type) {
+	case nil:
+	case *processor.InvalidTransactionError:
+		return fmt.Errorf("Dry run: %s would be rejected: %s", operation, err.Msg)
+	case *processor.InternalError:
+		// The validator doesn't reject these transactions but keeps retrying them
+		return fmt.Errorf("Dry run: %s would fail with an internal error and be retried by the validator: %s", operation, err.Msg)
+	default:
+		return fmt.Errorf("Dry run: %s would fail: %v", operation, err)
+	}
+
+	fmt.Fprintf(w, "Dry run: %s would be accepted\n", operation)
+	for _, change := range r.receipt.StateChanges {
+		before, ok := r.before[change.Address]
+		if !ok {
+			before = []byte("none")
+		}
+		if change.Type == transaction_receipt_pb2.StateChange_DELETE {
+			fmt.Fprintf(w, "state %s: %s -> deleted\n", change.Address, before)
+		} else {
+			fmt.Fprintf(w, "state %s: %s -> %s\n", change.Address, before, change.Value)
+		}
+	}
+	for _, event := range r.receipt.Events {
+		attributes := make([]string, 0, len(event.Attributes))
+		for _, a := range event.Attributes {
+			attributes = append(attributes, fmt.Sprintf("%s=%s", a.Key, a.Value))
+		}
+		fmt.Fprintf(w, "event %s: %s\n", event.EventType, strings.Join(attributes, " "))
+	}
+
+	return nil
+}
+
+// parseMutatingArgs returns the arguments of a mutating command and whether --dry-run was set, which may be placed
+// before or after the arguments. Integers such as negative amounts are arguments and everything after -- is one too.
+func parseMutatingArgs(name string, args []string) ([]string, bool, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "execute the transaction locally against the current state without submitting it")
+
+	var positional, flagArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		// The flag package would read a negative amount as an undefined flag
+		if _, err := strconv.Atoi(arg); err == nil || !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+		} else {
+			flagArgs = append(flagArgs, arg)
+		}
+	}
+	if err := flags.Parse(flagArgs); err != nil {
+		return nil, false, usageError{err.Error()}
+	}
+
+	return positional, *dryRun, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMutatingArgs(t *testing.T) {
+	cases := []struct {
+		args   []string
+		want   []string
+		dryRun bool
+		err    string
+	}{
+		{args: []string{"3"}, want: []string{"3"}},
+		{args: []string{"3", "--dry-run"}, want: []string{"3"}, dryRun: true},
+		{args: []string{"--dry-run", "3"}, want: []string{"3"}, dryRun: true},
+		{args: []string{"--dry-run", "--", "-3"}, want: []string{"-3"}, dryRun: true},
+		{args: []string{"--", "--dry-run"}, want: []string{"--dry-run"}},
+		{args: []string{"3", "4"}, want: []string{"3", "4"}},
+		{args: nil, want: nil},
+		{args: []string{"-3"}, want: []string{"-3"}},
+		{args: []string{"--dry-run", "-3"}, want: []string{"-3"}, dryRun: true},
+		{args: []string{"-3", "--dry-run=false"}, want: []string{"-3"}},
+		{args: []string{"3", "--", "--dry-run", "-x"}, want: []string{"3", "--dry-run", "-x"}},
+		{args: []string{"-3x"}, err: "flag provided but not defined: -3x"},
+		{args: []string{"--force"}, err: "flag provided but not defined"},
+	}
+	for _, c := range cases {
+		got, dryRun, err := parseMutatingArgs("eat", c.args)
+		if c.err != "" {
+			if _, ok := err.(usageError); !ok || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%v: got error %v, want a usage error %q", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) || dryRun != c.dryRun {
+			t.Errorf("%v: got %v, %v, %v, want %v, %v", c.args, got, dryRun, err, c.want, c.dryRun)
+		}
+	}
+}
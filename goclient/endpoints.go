@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckInterval = 30 * time.Second
+	healthCheckTimeout  = 5 * time.Second
+)
+
+// endpoint is one of the REST APIs the client can talk to
+type endpoint struct {
+	url     string
+	healthy bool
+	latency time.Duration
+}
+
+// endpoints keeps track of the health of the configured REST APIs
+type endpoints struct {
+	mu      sync.Mutex
+	list    []*endpoint
+	checked time.Time
+	probing bool // whether a health check is running
+}
+
+// unavailableError is returned when a REST API can't be reached or can't reach its validator
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+// normalizeURL returns the URL of a REST API, defaulting to plain HTTP when no scheme is given
+func normalizeURL(url string) string {
+	url = strings.TrimSuffix(url, "/")
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url
+	}
+
+	return fmt.Sprintf("http://%s", url)
+}
+
+// newEndpoints returns the endpoints for the provided URLs, which are assumed to be healthy until checked
+func newEndpoints(urls []string) *endpoints {
+	e := &endpoints{}
+	for _, url := range urls {
+		e.list = append(e.list, &endpoint{url: normalizeURL(url), healthy: true})
+	}
+
+	return e
+}
+
+// markDown flags an endpoint as unhealthy until the next health check
+func (e *endpoints) markDown(ep *endpoint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ep.healthy = false
+}
+
+// probe checks the health of a single endpoint via the REST API's status resource
+func (c *CookiejarClient) probe(ep *endpoint) (bool, time.Duration) {
+	request, err := http.NewRequest("GET", ep.url+"/status", nil)
+	if err != nil {
+		return false, 0
+	}
+	if auth := c.authHeader(); auth != "" {
+		request.Header.Set("Authorization", auth)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	response, err := c.http.Do(request.WithContext(ctx))
+	if err != nil {
+		return false, 0
+	}
+	response.Body.Close()
+
+	return response.StatusCode == http.StatusOK, time.Since(start)
+}
+
+// orderedEndpoints returns the endpoints with the healthiest first. With more than one endpoint their health is
+// checked when the last check is outdated. The probes run without holding the lock, so concurrent requests keep using
+// the previous results meanwhile.
+func (c *CookiejarClient) orderedEndpoints() []*endpoint {
+	e := c.endpoints
+	e.mu.Lock()
+	var probed []*endpoint
+	if len(e.list) > 1 && !e.probing && time.Since(e.checked) > healthCheckInterval {
+		e.probing = true
+		probed = append(probed, e.list...)
+	}
+	e.mu.Unlock()
+
+	if probed != nil {
+		// Probe all endpoints at once
+		healthy := make([]bool, len(probed))
+		latencies := make([]time.Duration, len(probed))
+		var wg sync.WaitGroup
+		for i, ep := range probed {
+			wg.Add(1)
+			go func(i int, ep *endpoint) {
+				defer wg.Done()
+				healthy[i], latencies[i] = c.probe(ep)
+				logger.Debugf("REST API %s healthy: %v (%v)", ep.url, healthy[i], latencies[i])
+			}(i, ep)
+		}
+		wg.Wait()
+
+		e.mu.Lock()
+		for i, ep := range probed {
+			ep.healthy, ep.latency = healthy[i], latencies[i]
+		}
+		e.checked = time.Now()
+		e.probing = false
+		e.mu.Unlock()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ordered := append([]*endpoint{}, e.list...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].healthy != ordered[j].healthy {
+			return ordered[i].healthy
+		}
+		return ordered[i].latency < ordered[j].latency
+	})
+
+	return ordered
+}
+
+// baseURL returns the URL of the healthiest REST API
+func (c *CookiejarClient) baseURL() string {
+	return c.orderedEndpoints()[0].url
+}
+
+// sendRequest sends the request to the healthiest REST API, failing over to the next one when it's unavailable.
+// Batches must not be submitted this way, see restTransport.submitBatches.
+func (c *CookiejarClient) sendRequest(suffix, contentType string, data []byte) ([]byte, error) {
+	var err error
+	for _, ep := range c.orderedEndpoints() {
+		var body []byte
+		body, err = c.sendRequestTo(ep.url, suffix, contentType, data)
+		if _, ok := err.(*unavailableError); !ok {
+			return body, err
+		}
+
+		c.endpoints.markDown(ep)
+		logger.Warnf("REST API %s unavailable: %v", ep.url, err)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format written by export
+const snapshotVersion = 1
+
+// snapshotColumns are the columns of a snapshot in CSV format. A snapshot without jars is written as a single row with
+// an empty address, which keeps its block.
+var snapshotColumns = []string{"version", "block_id", "block_num", "address", "owner", "balance", "data"}
+
+// snapshotJar is a single state entry of the cookiejar namespace
+type snapshotJar struct {
+	Address string `json:"address"`
+	Owner   string `json:"owner,omitempty"`   // public key, empty if unknown
+	Balance *int   `json:"balance,omitempty"` // nil if the data isn't a cookie count
+	Data    string `json:"data"`              // the raw entry
+}
+
+// snapshot is the state of the cookiejar namespace at a block
+type snapshot struct {
+	Version       int           `json:"version"`
+	FamilyName    string        `json:"family_name"`
+	FamilyVersion string        `json:"family_version"`
+	BlockID       string        `json:"block_id"`
+	BlockNum      uint64        `json:"block_num"`
+	TakenAt       time.Time     `json:"taken_at"`
+	Jars          []snapshotJar `json:"jars"`
+}
+
+// jarOwners maps the addresses to the public keys of their owners. The local keys are tried first, then the signers
+// of the cookiejar transactions on chain.
+func (c *CookiejarClient) jarOwners(addresses []string) (map[string]string, error) {
+	owners := map[string]string{}
+	for _, name := range localKeyNames() {
+		if publicKey, err := getPublicKey(name); err == nil {
+			owners[c.getJarAddress(publicKey)] = publicKey
+		}
+	}
+
+	unresolved := map[string]bool{}
+	for _, address := range addresses {
+		if _, ok := owners[address]; !ok {
+			unresolved[address] = true
+		}
+	}
+	if len(unresolved) == 0 {
+		return owners, nil
+	}
+
+	if err := c.listTransactions(func(t *restTransaction) bool {
+		if t.Header.FamilyName != familyName {
+			return true
+		}
+		address := c.getJarAddress(t.Header.SignerPublicKey)
+		if unresolved[address] {
+			owners[address] = t.Header.SignerPublicKey
+			delete(unresolved, address)
+		}
+
+		return len(unresolved) > 0
+	}); err != nil {
+		return nil, err
+	}
+
+	return owners, nil
+}
+
+// export reads every entry of the cookiejar namespace at the provided block, the current head if empty
+func (c *CookiejarClient) export(head string, resolveOwners bool) (*snapshot, error) {
+	snap := &snapshot{
+		Version:       snapshotVersion,
+		FamilyName:    familyName,
+		FamilyVersion: c.familyVersion,
+		TakenAt:       time.Now().UTC(),
+		Jars:          []snapshotJar{},
+	}
+
+	var decodeErr error
+	blockID, err := c.listState(c.getPrefix(), head, func(e *restStateEntry) bool {
+		data, err := base64.StdEncoding.DecodeString(e.Data)
+		if err != nil {
+			decodeErr = fmt.Errorf("Decoding error: %v", err)
+			return false
+		}
+
+		jar := snapshotJar{Address: e.Address, Data: string(data)}
+		if balance, err := strconv.Atoi(string(data)); err == nil {
+			jar.Balance = &balance
+		}
+		snap.Jars = append(snap.Jars, jar)
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	snap.BlockID = blockID
+
+	var block struct {
+		Data restBlock `json:"data"`
+	}
+	if err := c.getJSON(fmt.Sprintf("blocks/%s", blockID), &block); err != nil {
+		return nil, fmt.Errorf("Failed to read block %s: %v", blockID, err)
+	}
+	snap.BlockNum = block.Data.Header.BlockNum
+
+	if resolveOwners {
+		addresses := make([]string, 0, len(snap.Jars))
+		for _, jar := range snap.Jars {
+			addresses = append(addresses, jar.Address)
+		}
+		owners, err := c.jarOwners(addresses)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve jar owners: %v", err)
+		}
+		for i := range snap.Jars {
+			snap.Jars[i].Owner = owners[snap.Jars[i].Address]
+		}
+	}
+
+	return snap, nil
+}
+
+// write writes the snapshot in the requested format
+func (s *snapshot) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(snapshotColumns)
+		if len(s.Jars) == 0 {
+			cw.Write([]string{strconv.Itoa(s.Version), s.BlockID, strconv.FormatUint(s.BlockNum, 10), "", "", "", ""})
+		}
+		for _, jar := range s.Jars {
+			balance := ""
+			if jar.Balance != nil {
+				balance = strconv.Itoa(*jar.Balance)
+			}
+			cw.Write([]string{
+				strconv.Itoa(s.Version), s.BlockID, strconv.FormatUint(s.BlockNum, 10), jar.Address, jar.Owner, balance,
+				jar.Data,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("Invalid format %q, use json or csv", format)
+	}
+}
+
+// readSnapshot reads a snapshot in JSON or CSV format
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(b, snap); err != nil {
+			return nil, fmt.Errorf("Failed to parse snapshot: %v", err)
+		}
+	} else if snap, err = readSnapshotCSV(b); err != nil {
+		return nil, err
+	}
+
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d, expected %d", snap.Version, snapshotVersion)
+	}
+
+	return snap, nil
+}
+
+// readSnapshotCSV parses a snapshot in CSV format, whose block columns are repeated on every row
+func readSnapshotCSV(b []byte) (*snapshot, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse snapshot: %v", err)
+	}
+	// Without a row the block the snapshot was taken at is unknown
+	if len(records) < 2 {
+		return nil, fmt.Errorf("Empty snapshot")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, name := range snapshotColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("Snapshot is missing the %s column", name)
+		}
+	}
+
+	snap := &snapshot{Version: snapshotVersion, FamilyName: familyName, Jars: []snapshotJar{}}
+	for n, record := range records[1:] {
+		if snap.Version, err = strconv.Atoi(record[columns["version"]]); err != nil {
+			return nil, fmt.Errorf("Invalid version on line %d: %v", n+2, err)
+		}
+		snap.BlockID = record[columns["block_id"]]
+		if snap.BlockNum, err = strconv.ParseUint(record[columns["block_num"]], 10, 64); err != nil {
+			return nil, fmt.Errorf("Invalid block number on line %d: %v", n+2, err)
+		}
+		if record[columns["address"]] == "" {
+			continue
+		}
+
+		jar := snapshotJar{
+			Address: record[columns["address"]],
+			Owner:   record[columns["owner"]],
+			Data:    record[columns["data"]],
+		}
+		if b := record[columns["balance"]]; b != "" {
+			balance, err := strconv.Atoi(b)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid balance on line %d: %v", n+2, err)
+			}
+			jar.Balance = &balance
+		}
+		snap.Jars = append(snap.Jars, jar)
+	}
+
+	return snap, nil
+}
+
+// cmdExport executes the export command
+func cmdExport(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	at := flags.String("at", "", "block to export the state at, either its id or number (default: the current head)")
+	format := flags.String("format", "json", "output format: json or csv")
+	output := flags.String("output", "", "file to write the snapshot to (default: stdout)")
+	owners := flags.Bool("owners", true, "resolve the owner of every jar from the local keys and the transactions on chain")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("Invalid format %q, use json or csv", *format)
+	}
+
+	head := ""
+	if *at != "" {
+		var err error
+		if head, err = client.resolveBlock(*at); err != nil {
+			return err
+		}
+	}
+
+	snap, err := client.export(head, *owners)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return snap.write(os.Stdout, *format)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := snap.write(f, *format); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d entries at block %d (%s) to %s\n", len(snap.Jars), snap.BlockNum, snap.BlockID, *output)
+
+	return nil
+}
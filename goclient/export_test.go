@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newSnapshotServer returns a REST API serving the entries as the state at the block
+func newSnapshotServer(t *testing.T, block string, num string, entries map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/state":
+			data := []restStateEntry{}
+			for address, value := range entries {
+				data = append(data, restStateEntry{Address: address, Data: base64.StdEncoding.EncodeToString([]byte(value))})
+			}
+			writeJSON(t, w, map[string]interface{}{"data": data, "head": block})
+		case "/blocks/" + block:
+			writeJSON(t, w, map[string]interface{}{"data": map[string]interface{}{
+				"header": map[string]interface{}{"block_num": num}, "header_signature": block}})
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	server := newSnapshotServer(t, blockID("7"), "7", map[string]string{
+		"a4d21901": "12",
+		"a4d21902": "0",
+		// Entries which aren't cookie counts are kept as they are
+		"a4d21903": "not, a \"count\"\n",
+	})
+	defer server.Close()
+
+	snap, err := newTestClient(server.URL).export("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != snapshotVersion || snap.BlockID != blockID("7") || snap.BlockNum != 7 || len(snap.Jars) != 3 {
+		t.Fatalf("got %+v, want the 3 jars at block 7", snap)
+	}
+	sortJars := func(s *snapshot) {
+		byAddress := map[string]snapshotJar{}
+		for _, jar := range s.Jars {
+			byAddress[jar.Address] = jar
+		}
+		s.Jars = []snapshotJar{byAddress["a4d21901"], byAddress["a4d21902"], byAddress["a4d21903"]}
+	}
+	sortJars(snap)
+	snap.Jars[0].Owner = "02ab"
+	if *snap.Jars[0].Balance != 12 || *snap.Jars[1].Balance != 0 || snap.Jars[2].Balance != nil {
+		t.Fatalf("got jars %+v", snap.Jars)
+	}
+
+	for _, format := range []string{"json", "csv"} {
+		var b bytes.Buffer
+		if err := snap.write(&b, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		read, err := readSnapshot(&b)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		// CSV only keeps the block and the jars
+		want := *snap
+		if format == "csv" {
+			want = snapshot{Version: snap.Version, FamilyName: familyName, BlockID: snap.BlockID, BlockNum: snap.BlockNum,
+				Jars: snap.Jars}
+		}
+		if !read.TakenAt.Equal(want.TakenAt) {
+			t.Errorf("%s: got taken at %v, want %v", format, read.TakenAt, want.TakenAt)
+		}
+		read.TakenAt = want.TakenAt
+		if !reflect.DeepEqual(read, &want) {
+			t.Errorf("%s: got %+v, want %+v", format, read, &want)
+		}
+	}
+}
+
+func TestEmptySnapshotKeepsItsBlock(t *testing.T) {
+	snap := &snapshot{Version: snapshotVersion, FamilyName: familyName, BlockID: blockID("3"), BlockNum: 3,
+		Jars: []snapshotJar{}}
+
+	var b bytes.Buffer
+	if err := snap.write(&b, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readSnapshot(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, snap) {
+		t.Errorf("got %+v, want %+v", read, snap)
+	}
+
+	// Without a row the block is unknown
+	header := strings.Join(snapshotColumns, ",") + "\n"
+	if _, err := readSnapshot(strings.NewReader(header)); err == nil {
+		t.Error("got a snapshot without block")
+	}
+}
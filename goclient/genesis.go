@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+// localSigners returns signers for all locally stored keys, mapped to their public keys
+func localSigners() map[string]*signing.Signer {
+	signers := map[string]*signing.Signer{}
+	for _, name := range localKeyNames() {
+		signer, err := loadSigner(name)
+		if err != nil {
+			logger.Warnf("Skipping key %s: %v", name, err)
+			continue
+		}
+		signers[signer.GetPublicKey().AsHex()] = signer
+	}
+
+	return signers
+}
+
+// seedTransaction returns a transaction signed by the owner which bakes the jar's balance into an empty state.
+// The handler only lets owners write to their own jar, so seeding needs the owner's private key.
+func (c *CookiejarClient) seedTransaction(owner *signing.Signer, balance int) (*transaction_pb2.Transaction, error) {
+	payload := strings.Join([]string{"bake", strconv.Itoa(balance)}, ",")
+	publicKey := owner.GetPublicKey().AsHex()
+	addressList := []string{c.getJarAddress(publicKey)}
+
+	header, err := proto.Marshal(&transaction_pb2.TransactionHeader{
+		SignerPublicKey:  publicKey,
+		FamilyName:       familyName,
+		FamilyVersion:    c.familyVersion,
+		Inputs:           addressList,
+		Outputs:          addressList,
+		PayloadSha512:    hexdigest(payload),
+		BatcherPublicKey: c.signer.GetPublicKey().AsHex(),
+		Nonce:            addressList[0],
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize transaction header: %v", err)
+	}
+
+	return &transaction_pb2.Transaction{
+		Header:          header,
+		HeaderSignature: hex.EncodeToString(owner.Sign(header)),
+		Payload:         []byte(payload),
+	}, nil
+}
+
+// genesisBatch turns the snapshot into a single batch signed by the client's signer, which seeds every jar with its
+// balance. Jars whose owner has no local key are reported, and skipped if skipMissing is set.
+func (c *CookiejarClient) genesisBatch(snap *snapshot, skipMissing bool) (*batch_pb2.Batch, int, error) {
+	signers := localSigners()
+
+	var transactions []*transaction_pb2.Transaction
+	var missing []string
+	for _, jar := range snap.Jars {
+		if jar.Balance == nil {
+			return nil, 0, fmt.Errorf("Jar %s holds %q, which isn't a cookie count", jar.Address, jar.Data)
+		}
+		if jar.Owner != "" && c.getJarAddress(jar.Owner) != jar.Address {
+			return nil, 0, fmt.Errorf("Jar %s isn't owned by %s", jar.Address, jar.Owner)
+		}
+
+		owner, ok := signers[jar.Owner]
+		if !ok {
+			missing = append(missing, jar.Address)
+			continue
+		}
+
+		t, err := c.seedTransaction(owner, *jar.Balance)
+		if err != nil {
+			return nil, 0, err
+		}
+		transactions = append(transactions, t)
+	}
+
+	if len(missing) > 0 {
+		if !skipMissing {
+			return nil, 0, fmt.Errorf("No local key owns the jars %s", strings.Join(missing, ", "))
+		}
+		for _, address := range missing {
+			logger.Warnf("Skipping jar %s, no local key owns it", address)
+		}
+	}
+	if len(transactions) == 0 {
+		return nil, 0, fmt.Errorf("No jar to seed")
+	}
+
+	batch, err := c.newBatch(transactions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return batch, len(missing), nil
+}
+
+// cmdGenesis executes the genesis command
+func cmdGenesis(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("genesis", flag.ContinueOnError)
+	output := flags.String("output", "cookiejar-genesis.batch", "file to write the batch list to")
+	skipMissing := flags.Bool("skip-missing", false, "skip the jars whose owner has no local key instead of failing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError{"genesis requires the snapshot file"}
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	snap, err := readSnapshot(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	batch, skipped, err := client.genesisBatch(snap, *skipMissing)
+	if err != nil {
+		return err
+	}
+
+	data, err := marshalBatchList([]*batch_pb2.Batch{batch})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d jars from block %d (%s) to %s, skipped %d\n", len(batch.Transactions), snap.BlockNum,
+		snap.BlockID, *output, skipped)
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+// useKeyDir points the local keys at a temporary home holding the named keys and returns their public keys and a
+// function restoring the home
+func useKeyDir(t *testing.T, names ...string) (map[string]string, func()) {
+	home, err := ioutil.TempDir("", "cookiejar-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(home, ".sawtooth", "keys")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	context := signing.NewSecp256k1Context()
+	publicKeys := map[string]string{}
+	for _, name := range names {
+		private := context.NewRandomPrivateKey()
+		publicKey := context.GetPublicKey(private).AsHex()
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".priv"), private.AsBytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".pub"), []byte(publicKey+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		publicKeys[name] = publicKey
+	}
+
+	saved := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	return publicKeys, func() {
+		os.Setenv("HOME", saved)
+		os.RemoveAll(home)
+	}
+}
+
+func TestGenesisBatch(t *testing.T) {
+	keys, restore := useKeyDir(t, "alice", "bob")
+	defer restore()
+
+	client := newTestClient()
+	signer, err := loadSigner("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.signer = signer
+
+	balance := func(n int) *int { return &n }
+	context := signing.NewSecp256k1Context()
+	carol := context.GetPublicKey(context.NewRandomPrivateKey()).AsHex()
+	alice := snapshotJar{Address: client.getJarAddress(keys["alice"]), Owner: keys["alice"], Balance: balance(12)}
+	bob := snapshotJar{Address: client.getJarAddress(keys["bob"]), Owner: keys["bob"], Balance: balance(0)}
+	unowned := snapshotJar{Address: client.getJarAddress(carol), Balance: balance(3)}
+	foreign := snapshotJar{Address: client.getJarAddress(carol), Owner: carol, Balance: balance(4)}
+
+	cases := []struct {
+		name        string
+		jars        []snapshotJar
+		skipMissing bool
+		err         string
+		seeded      map[string]string // payloads by signer
+		skipped     int
+	}{
+		{"local owners", []snapshotJar{alice, bob}, false, "",
+			map[string]string{keys["alice"]: "bake,12", keys["bob"]: "bake,0"}, 0},
+		{"missing owners", []snapshotJar{alice, unowned, foreign}, false, "No local key owns the jars", nil, 0},
+		{"skipped owners", []snapshotJar{alice, unowned, foreign}, true, "",
+			map[string]string{keys["alice"]: "bake,12"}, 2},
+		{"nothing to seed", []snapshotJar{foreign}, true, "No jar to seed", nil, 0},
+		{"owner mismatch", []snapshotJar{{Address: bob.Address, Owner: keys["alice"], Balance: balance(1)}}, true,
+			"isn't owned by", nil, 0},
+		{"no cookie count", []snapshotJar{{Address: alice.Address, Owner: keys["alice"], Data: "x"}}, true,
+			"isn't a cookie count", nil, 0},
+	}
+	for _, c := range cases {
+		batch, skipped, err := client.genesisBatch(&snapshot{Jars: c.jars}, c.skipMissing)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if skipped != c.skipped || len(batch.Transactions) != len(c.seeded) {
+			t.Errorf("%s: got %d transactions, %d skipped, want %d and %d", c.name, len(batch.Transactions), skipped,
+				len(c.seeded), c.skipped)
+		}
+
+		// Every jar is seeded by its owner, the batch by the client's signer
+		for _, tx := range batch.Transactions {
+			header := &transaction_pb2.TransactionHeader{}
+			if err := proto.Unmarshal(tx.Header, header); err != nil {
+				t.Fatal(err)
+			}
+			if c.seeded[header.SignerPublicKey] != string(tx.Payload) ||
+				header.Outputs[0] != client.getJarAddress(header.SignerPublicKey) ||
+				header.BatcherPublicKey != signer.GetPublicKey().AsHex() {
+				t.Errorf("%s: got %s seeding %s, want %v", c.name, header.SignerPublicKey, tx.Payload, c.seeded)
+			}
+		}
+	}
+}
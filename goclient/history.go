@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// historyEntry is a single line in the ledger of a jar
+type historyEntry struct {
+	Block         uint64 `json:"block"`
+	BlockID       string `json:"block_id"`
+	BatchID       string `json:"batch_id"`
+	BatchStatus   string `json:"batch_status"`
+	TransactionID string `json:"transaction_id"`
+	Signer        string `json:"signer"`
+	Action        string `json:"action"`
+	Amount        int    `json:"amount"`
+	Balance       int    `json:"balance"`
+}
+
+// history returns the chronological ledger of all cookiejar transactions which changed the jar owned by publicKey,
+// starting at block sinceBlock. Only the owner can change their jar, so these are the transactions they signed.
+func (c *CookiejarClient) history(publicKey string, sinceBlock uint64) ([]historyEntry, error) {
+	address := c.getJarAddress(publicKey)
+
+	// Collect the cookiejar transactions signed by the owner, newest first
+	var entries []historyEntry
+	var parseErr error
+	if err := c.listTransactions(func(t *restTransaction) bool {
+		if t.Header.FamilyName != familyName || t.Header.SignerPublicKey != publicKey {
+			return true
+		}
+
+		payload, err := t.decodePayload()
+		if err == nil {
+			var entry historyEntry
+			entry.Action, entry.Amount, err = parsePayload(payload)
+			entry.TransactionID = t.HeaderSignature
+			entry.Signer = t.Header.SignerPublicKey
+			entries = append(entries, entry)
+		}
+		if err != nil {
+			parseErr = fmt.Errorf("Transaction %s: %v", t.HeaderSignature, err)
+			return false
+		}
+
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	// Find the block and batch of every transaction by walking the chain back to the requested block
+	type location struct {
+		block   uint64
+		blockID string
+		batchID string
+	}
+	locations := map[string]location{}
+	startHead := ""
+	if err := c.listBlocks(func(b *restBlock) bool {
+		if b.Header.BlockNum < sinceBlock {
+			// The state of the block just before the requested one provides the opening balance
+			startHead = b.HeaderSignature
+			return false
+		}
+
+		for _, batch := range b.Batches {
+			for _, t := range batch.Transactions {
+				locations[t.HeaderSignature] = location{b.Header.BlockNum, b.HeaderSignature, batch.HeaderSignature}
+			}
+		}
+
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	// Drop the transactions committed before the requested block and reverse into chronological order
+	ledger := make([]historyEntry, 0, len(entries))
+	var batchIDs []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		loc, ok := locations[entries[i].TransactionID]
+		if !ok {
+			continue
+		}
+
+		entry := entries[i]
+		entry.Block, entry.BlockID, entry.BatchID = loc.block, loc.blockID, loc.batchID
+		ledger = append(ledger, entry)
+		batchIDs = append(batchIDs, entry.BatchID)
+	}
+
+	statuses, err := c.getBatchStatuses(batchIDs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate the running balance
+	balance := 0
+	if startHead != "" {
+		if balance, err = c.getCount(address, startHead); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range ledger {
+		switch ledger[i].Action {
+		case "bake":
+			balance += ledger[i].Amount
+		case "eat":
+			balance -= ledger[i].Amount
+		case "clear":
+			balance = 0
+		}
+		ledger[i].Balance = balance
+		if status, ok := statuses[ledger[i].BatchID]; ok {
+			ledger[i].BatchStatus = status.Status
+		}
+	}
+
+	return ledger, nil
+}
+
+// writeHistory writes the ledger in the requested format
+func writeHistory(w io.Writer, ledger []historyEntry, format string) error {
+	switch format {
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "BLOCK\tBATCH\tSTATUS\tTRANSACTION\tACTION\tAMOUNT\tBALANCE")
+		for _, e := range ledger {
+			fmt.Fprintf(tw, "%d\t%.16s\t%s\t%.16s\t%s\t%d\t%d\n",
+				e.Block, e.BatchID, e.BatchStatus, e.TransactionID, e.Action, e.Amount, e.Balance)
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"block", "block_id", "batch_id", "batch_status", "transaction_id", "signer", "action", "amount", "balance"})
+		for _, e := range ledger {
+			cw.Write([]string{
+				strconv.FormatUint(e.Block, 10), e.BlockID, e.BatchID, e.BatchStatus, e.TransactionID, e.Signer,
+				e.Action, strconv.Itoa(e.Amount), strconv.Itoa(e.Balance),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ledger)
+	default:
+		return fmt.Errorf("Invalid format %q, use text, csv or json", format)
+	}
+}
+
+// cmdHistory executes the history command
+func cmdHistory(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	jar := flags.String("jar", "", "jar to show, either a key name or a public key (default: the profile's jar)")
+	sinceBlock := flags.Uint64("since-block", 0, "first block to include")
+	format := flags.String("format", "text", "output format: text, csv or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	publicKey := client.jar
+	if *jar != "" {
+		var err error
+		if publicKey, err = getPublicKey(*jar); err != nil {
+			return err
+		}
+	}
+
+	ledger, err := client.history(publicKey, *sinceBlock)
+	if err != nil {
+		return err
+	}
+
+	return writeHistory(os.Stdout, ledger, *format)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// historyTransaction returns a cookiejar transaction of the signer, declaring the addresses as inputs and outputs
+func historyTransaction(id, signer, payload string, addresses ...string) restTransaction {
+	return restTransaction{
+		Header: restTransactionHeader{FamilyName: familyName, SignerPublicKey: signer, Inputs: addresses,
+			Outputs: addresses},
+		HeaderSignature: id,
+		Payload:         base64.StdEncoding.EncodeToString([]byte(payload)),
+	}
+}
+
+func TestHistoryOnlyReplaysTheOwnersTransactions(t *testing.T) {
+	alice := newTestClient().getJarAddress("alice")
+
+	// bob's transaction declares the jar of alice but can't change it
+	t1 := historyTransaction("t1", "alice", "bake,5", alice)
+	t2 := historyTransaction("t2", "bob", "bake,7", alice, newTestClient().getJarAddress("bob"))
+	t3 := historyTransaction("t3", "alice", "eat,2", alice)
+	t4 := historyTransaction("t4", "alice", "bake,1", alice)
+	t4.Header.FamilyName = "intkey"
+	blocks := []restBlock{
+		{Header: restBlockHeader{BlockNum: 2}, HeaderSignature: "block2",
+			Batches: []restBatch{{HeaderSignature: "b3", Transactions: []restTransaction{t3}}}},
+		{Header: restBlockHeader{BlockNum: 1}, HeaderSignature: "block1",
+			Batches: []restBatch{{HeaderSignature: "b1", Transactions: []restTransaction{t1, t4}},
+				{HeaderSignature: "b2", Transactions: []restTransaction{t2}}}},
+		{Header: restBlockHeader{BlockNum: 0}, HeaderSignature: "block0"},
+	}
+	balances := map[string]string{"block1": "NQ=="} // 5
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/transactions":
+			writeJSON(t, w, map[string]interface{}{"data": []restTransaction{t4, t3, t2, t1}})
+		case r.URL.Path == "/blocks":
+			writeJSON(t, w, map[string]interface{}{"data": blocks})
+		case r.URL.Path == "/batch_statuses":
+			writeJSON(t, w, map[string]interface{}{"data": []batchStatus{{ID: "b1", Status: "COMMITTED"},
+				{ID: "b3", Status: "COMMITTED"}}})
+		case strings.HasPrefix(r.URL.Path, "/state/") && r.URL.Path[len("/state/"):] == alice:
+			if data, ok := balances[r.URL.Query().Get("head")]; ok {
+				writeJSON(t, w, map[string]string{"data": data})
+				return
+			}
+			http.NotFound(w, r)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	c := newTestClient(server.URL)
+
+	cases := []struct {
+		since uint64
+		want  []historyEntry
+	}{
+		{0, []historyEntry{
+			{Block: 1, BlockID: "block1", BatchID: "b1", BatchStatus: "COMMITTED", TransactionID: "t1", Signer: "alice",
+				Action: "bake", Amount: 5, Balance: 5},
+			{Block: 2, BlockID: "block2", BatchID: "b3", BatchStatus: "COMMITTED", TransactionID: "t3", Signer: "alice",
+				Action: "eat", Amount: 2, Balance: 3},
+		}},
+		// The opening balance is read at the block before the first one
+		{2, []historyEntry{
+			{Block: 2, BlockID: "block2", BatchID: "b3", BatchStatus: "COMMITTED", TransactionID: "t3", Signer: "alice",
+				Action: "eat", Amount: 2, Balance: 3},
+		}},
+		{3, []historyEntry{}},
+	}
+	for _, tc := range cases {
+		ledger, err := c.history("alice", tc.since)
+		if err != nil {
+			t.Fatalf("since %d: %v", tc.since, err)
+		}
+		if !reflect.DeepEqual(ledger, tc.want) {
+			t.Errorf("since %d: got %+v, want %+v", tc.since, ledger, tc.want)
+		}
+	}
+}
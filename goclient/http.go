@@ -0,0 +1,221 @@
+package main
+
+import (
+	gobytes "bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryMinBackoff = 250 * time.Millisecond
+	retryMaxBackoff = 10 * time.Second
+)
+
+// newHTTPClient returns a HTTP client for the REST API, using the profile's timeout and TLS settings
+func newHTTPClient(profile *Profile) (*http.Client, error) {
+	settings := profile.TLS
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.Insecure}
+
+	// Trust the provided certificate authority besides the system ones
+	if settings.CA != "" {
+		ca, err := ioutil.ReadFile(settings.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA certificate: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificates found in %s", settings.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Present a client certificate if one is configured
+	if settings.Cert != "" || settings.Key != "" {
+		cert, err := tls.LoadX509KeyPair(settings.Cert, settings.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Requests for batch statuses may be held by the REST API for the wait timeout, so that's added on top
+	var timeout time.Duration
+	if *profile.Timeout > 0 {
+		timeout = time.Duration(*profile.Timeout+*profile.Wait) * time.Second
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// authHeader returns the Authorization header value for the configured credentials, if any
+func (c *CookiejarClient) authHeader() string {
+	if c.auth.Token != "" {
+		return "Bearer " + c.auth.Token
+	}
+	if c.auth.Username != "" {
+		credentials := c.auth.Username + ":" + c.auth.Password
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	return ""
+}
+
+// shouldRetry returns whether a request which failed with the error or response is worth retrying
+func shouldRetry(response *http.Response, err error) bool {
+	if err != nil {
+		return transientError(err)
+	}
+
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable
+}
+
+// transientError returns whether the request error is a timeout or a failed connection. Errors which a retry
+// doesn't fix, like invalid URLs, untrusted certificates or TLS alerts of the server, aren't transient.
+func transientError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	switch e := err.(type) {
+	case *net.OpError:
+		return e.Op == "dial" || e.Op == "read" || e.Op == "write"
+	default:
+		return err == io.EOF || err == io.ErrUnexpectedEOF
+	}
+}
+
+// retryDelay returns how long to wait before the next attempt, using exponential backoff with jitter
+// unless the server asked for a specific delay, which is capped at retryMaxBackoff
+func retryDelay(response *http.Response, attempt uint) time.Duration {
+	if response != nil {
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if seconds >= int(retryMaxBackoff/time.Second) {
+				return retryMaxBackoff
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	backoff := retryMinBackoff << attempt
+	if backoff > retryMaxBackoff || backoff <= 0 {
+		backoff = retryMaxBackoff
+	}
+
+	// Randomize between half and the full backoff so that clients don't retry in lockstep
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// errorMessage extracts the error message from a REST API error response, falling back to the raw body
+func errorMessage(response *http.Response, body []byte) string {
+	var restError struct {
+		Error struct {
+			Title   string `json:"title"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &restError); err == nil && restError.Error.Message != "" {
+		return fmt.Sprintf("%s: %s", restError.Error.Title, restError.Error.Message)
+	}
+
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return msg
+	}
+
+	return response.Status
+}
+
+// doRequest sends a single request to the REST API. If there is data a POST request is sent, else a GET request
+func (c *CookiejarClient) doRequest(url, contentType string, data []byte) (*http.Response, error) {
+	method := "GET"
+	if len(data) > 0 {
+		method = "POST"
+	}
+
+	request, err := http.NewRequest(method, url, gobytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if auth := c.authHeader(); auth != "" {
+		request.Header.Set("Authorization", auth)
+	}
+
+	return c.http.Do(request)
+}
+
+// sendRequestTo sends the request to the REST API at base, retrying on connection errors and when the REST API is overloaded
+func (c *CookiejarClient) sendRequestTo(base, suffix, contentType string, data []byte) ([]byte, error) {
+	// Create the url
+	url := fmt.Sprintf("%s/%s", base, suffix)
+
+	// Send the request and get the response
+	var response *http.Response
+	var err error
+	for attempt := uint(0); ; attempt++ {
+		response, err = c.doRequest(url, contentType, data)
+		if attempt >= c.retries || !shouldRetry(response, err) {
+			break
+		}
+
+		delay := retryDelay(response, attempt)
+		if err != nil {
+			logger.Debugf("Request to %s failed (%v), retrying in %v", url, err, delay)
+		} else {
+			logger.Debugf("Request to %s returned %s, retrying in %v", url, response.Status, delay)
+			response.Body.Close()
+		}
+		time.Sleep(delay)
+	}
+	if err != nil {
+		return nil, &unavailableError{fmt.Errorf("Failed to connect to REST API: %v", err)}
+	}
+	defer response.Body.Close() // Ensure the body will be closed once done
+
+	// Get the response body
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response: %v", err)
+	}
+
+	// Check for potential errors
+	if response.StatusCode == http.StatusNotFound {
+		logger.Debugf("Not found: %s", url)
+		return nil, errNotFound
+	} else if response.StatusCode == http.StatusBadGateway || response.StatusCode == http.StatusServiceUnavailable ||
+		response.StatusCode == http.StatusGatewayTimeout {
+		// The REST API can't reach its validator
+		return nil, &unavailableError{fmt.Errorf("Error %d: %s", response.StatusCode, errorMessage(response, body))}
+	} else if response.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("Error %d: %s", response.StatusCode, errorMessage(response, body))
+	}
+
+	return body, nil
+}
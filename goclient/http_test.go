@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendRequestToRetries(t *testing.T) {
+	cases := []struct {
+		name        string
+		statuses    []int // responses in order, the last one repeating
+		retries     uint
+		attempts    int32
+		unavailable bool
+		err         bool
+	}{
+		{"success", []int{200}, 3, 1, false, false},
+		{"too many requests", []int{429, 429, 200}, 3, 3, false, false},
+		{"overloaded", []int{503, 200}, 3, 2, false, false},
+		{"retries exhausted", []int{503}, 2, 3, true, true},
+		{"retries disabled", []int{429}, 0, 1, false, true},
+		{"not retried", []int{500, 200}, 3, 1, false, true},
+		{"not found", []int{404}, 3, 1, false, true},
+	}
+	for _, c := range cases {
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&attempts, 1)) - 1
+			if n >= len(c.statuses) {
+				n = len(c.statuses) - 1
+			}
+			// The REST API asks for an immediate retry to keep the test fast
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(c.statuses[n])
+			w.Write([]byte(`{"data": "ok"}`))
+		}))
+
+		client := newTestClient(server.URL)
+		client.retries = c.retries
+		body, err := client.sendRequestTo(server.URL, "state/ab", "", nil)
+		server.Close()
+
+		if got := atomic.LoadInt32(&attempts); got != c.attempts {
+			t.Errorf("%s: got %d attempts, want %d", c.name, got, c.attempts)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+		if _, ok := err.(*unavailableError); ok != c.unavailable {
+			t.Errorf("%s: got error %v, want unavailable %v", c.name, err, c.unavailable)
+		}
+		if err == nil && string(body) != `{"data": "ok"}` {
+			t.Errorf("%s: got body %q", c.name, body)
+		}
+	}
+}
+
+func TestShouldRetryOnlyTransientErrors(t *testing.T) {
+	// A server with a certificate the client doesn't trust
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	_, untrusted := http.Get(tlsServer.URL)
+
+	// A server which is gone
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+	_, refused := http.Get(closed.URL)
+
+	// A server which doesn't answer in time
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	_, timeout := (&http.Client{Timeout: 10 * time.Millisecond}).Get(slow.URL)
+
+	_, malformed := http.Get("htp://rest-api:8008")
+
+	cases := []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{"connection refused", refused, true},
+		{"timeout", timeout, true},
+		{"connection closed", &url.Error{Op: "Get", URL: "http://rest-api", Err: io.EOF}, true},
+		{"untrusted certificate", untrusted, false},
+		{"malformed url", malformed, false},
+	}
+	for _, c := range cases {
+		if c.err == nil {
+			t.Fatalf("%s: got no error", c.name)
+		}
+		if got := shouldRetry(nil, c.err); got != c.retry {
+			t.Errorf("%s: got retry %v for %v, want %v", c.name, got, c.err, c.retry)
+		}
+	}
+}
+
+func TestRetryDelayCapsRetryAfter(t *testing.T) {
+	cases := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"0", 0},
+		{"3", 3 * time.Second},
+		{"10", retryMaxBackoff},
+		{"7200", retryMaxBackoff},
+		{"99999999999999999", retryMaxBackoff},
+	}
+	for _, c := range cases {
+		response := &http.Response{Header: http.Header{"Retry-After": []string{c.retryAfter}}}
+		if got := retryDelay(response, 0); got != c.want {
+			t.Errorf("Retry-After %s: got %v, want %v", c.retryAfter, got, c.want)
+		}
+	}
+
+	// Without Retry-After, the jittered backoff is capped too
+	if got := retryDelay(&http.Response{Header: http.Header{}}, 60); got > retryMaxBackoff || got < retryMaxBackoff/2 {
+		t.Errorf("got %v, want between %v and %v", got, retryMaxBackoff/2, retryMaxBackoff)
+	}
+}
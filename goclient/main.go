@@ -1,11 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path"
 	"runtime"
-	"strconv"
 	"strings"
 
 	"github.com/hyperledger/sawtooth-sdk-go/logging"
@@ -24,8 +24,9 @@ func printHelp(msg string) {
 	if msg != "" {
 		fmt.Println(msg)
 	}
-	fmt.Printf("Usage: %s <command>\n\nCommands:\n", os.Args[0])
-	fmt.Printf("bake <amount>\neat <amount>\ncount\nclear")
+	fmt.Printf("Usage: %s [--profile <name>] [--transport rest|zmq|simulate] [--connect <url>] [--simulate] <command>\n\nCommands:\n", os.Args[0])
+	fmt.Printf("bake [--dry-run] <amount>\neat [--dry-run] <amount>\ncount [--at <block id|block num>]\nclear [--dry-run]\nhistory [--jar <jar>] [--since-block <num>] [--format text|csv|json]\ndiff --from <block> --to <block>\nwatch [--jar <jar>] [--format text|json]\nbench [--signers <n>] [--rate <batches/s>] [--concurrency <n>] [--batch-size <n>] [--duration <d>] [--report <file>]\naudit [--checkpoint-every <n>] [--format text|json]\nverify [--since-block <num>] [--format text|json]\nexport [--at <block id|block num>] [--format json|csv] [--output <file>] [--owners=false]\ngenesis [--output <file>] [--skip-missing] <snapshot>\nshell\nconfig get [<setting>]\nconfig set <setting> <value>\nconfig use <profile>\n")
+	fmt.Printf("\nWith --transport zmq only bake, eat, clear, count (without --at), bench, genesis, shell and config are available,\nthe other commands read the chain via the REST API.\n")
 }
 
 // UserHomeDir returns the user's home directory
@@ -49,88 +50,96 @@ func getPrivateKeyFile(keyName string) (string, error) {
 }
 
 func main() {
-	args := len(os.Args)
-	if args < 2 {
+	// The profile can be selected with a flag or via the environment
+	profileName := flag.String("profile", os.Getenv("CJ_PROFILE"), "configuration profile to use")
+	transport := flag.String("transport", "", "submit batches and read state via rest or zmq; zmq only supports bake, eat, clear, count and bench")
+	connect := flag.String("connect", "", "validator endpoint used by the zmq transport, e.g. tcp://validator:4004")
+	simulate := flag.Bool("simulate", false, "run the transactions locally against a file-backed state, without a validator")
+	flag.Usage = func() { printHelp("") }
+	flag.Parse()
+
+	cmdArgs := flag.Args()
+
+	// Installed as cookiejar-audit or cookiejar-genesis, the client runs the audit or genesis command
+	switch path.Base(os.Args[0]) {
+	case "cookiejar-audit":
+		cmdArgs = append([]string{"audit"}, cmdArgs...)
+	case "cookiejar-genesis":
+		cmdArgs = append([]string{"genesis"}, cmdArgs...)
+	}
+	if len(cmdArgs) < 1 {
 		printHelp("")
 		os.Exit(1)
 	}
 
-	// Get the locally stored private key
-	keyFile, err := getPrivateKeyFile(fmt.Sprintf("%s.priv", keyName))
+	// Load the configuration file
+	file := configPath()
+	config, err := loadConfig(file)
 	if err != nil {
-		fmt.Printf("Failed to generate filename: %v\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Instantiate a new cookiejar client
-	client, err := NewCookiejarClient(defaultURL, keyFile)
+	// The config command manages the configuration and doesn't need a client
+	if strings.ToLower(cmdArgs[0]) == "config" {
+		if err := runConfig(config, file, *profileName, cmdArgs[1:]); err != nil {
+			printHelp(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	profile, err := config.profile(*profileName)
 	if err != nil {
-		fmt.Printf("Failed to initialize cookiejar client: %v\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Check the exectured argument
-	switch strings.ToLower(os.Args[1]) {
-	case "bake":
-		if args != 3 {
-			printHelp("bake requires 1 argument")
-			os.Exit(1)
-		}
-
-		// Convert the amount to int
-		amount, err := strconv.Atoi(os.Args[2])
-		if err != nil {
+	// The command line flags take precedence over the profile
+	if *transport != "" {
+		if err := profile.set("transport", *transport); err != nil {
 			printHelp(err.Error())
-			os.Exit(2)
-		}
-
-		// Execute the action
-		resp, err := client.bake(amount)
-		if err != nil {
-			fmt.Printf("Failed to register baked cookies: %v\n", err)
-			os.Exit(2)
-		}
-
-		fmt.Println(resp)
-	case "eat":
-		if args != 3 {
-			printHelp("eat requires 1 argument")
 			os.Exit(1)
 		}
+	}
+	if *connect != "" {
+		profile.Connect = *connect
+	}
+	if *simulate {
+		profile.Transport = "simulate"
+	}
 
-		// Convert the amount to int
-		amount, err := strconv.Atoi(os.Args[2])
-		if err != nil {
-			printHelp(err.Error())
-			os.Exit(2)
-		}
+	// Instantiate a new cookiejar client
+	client, err := NewCookiejarClient(profile)
+	if err != nil {
+		fmt.Printf("Failed to initialize cookiejar client: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
 
-		// Execute the action
-		resp, err := client.eat(amount)
-		if err != nil {
-			fmt.Printf("Failed to register eating cookies: %v\n", err)
-			os.Exit(2)
+	// The shell keeps a client session open for many commands
+	if strings.ToLower(cmdArgs[0]) == "shell" {
+		name := *profileName
+		if name == "" {
+			name = config.Current
 		}
 
-		fmt.Println(resp)
-	case "count":
-		// Execture the action
-		resp, err := client.count()
-		if err != nil {
-			fmt.Printf("Failed to register baked cookies: %v\n", err)
+		if err := runShell(client, profile, name); err != nil {
+			client.Close()
+			fmt.Printf("Shell failed: %v\n", err)
 			os.Exit(2)
 		}
+		return
+	}
 
-		fmt.Println(resp)
-	case "clear":
-		// Excecute the action
-		err := client.clear()
-		if err != nil {
-			fmt.Printf("Failed to register baked cookies: %v\n", err)
-			os.Exit(2)
+	// Execute the command
+	if err := runCommand(client, cmdArgs); err != nil {
+		client.Close()
+		if _, ok := err.(usageError); ok {
+			printHelp(err.Error())
+			os.Exit(1)
 		}
-	default:
-		printHelp("Invalid command")
-		os.Exit(1)
+		fmt.Println(err)
+		os.Exit(2)
 	}
 }
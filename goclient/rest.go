@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+)
+
+// restPageSize is the amount of items requested per page when listing resources
+const restPageSize = 100
+
+// restPaging is the paging element of a REST API list response
+type restPaging struct {
+	NextPosition string `json:"next_position"`
+}
+
+// restTransactionHeader is the decoded header of a transaction as returned by the REST API
+type restTransactionHeader struct {
+	BatcherPublicKey string   `json:"batcher_public_key"`
+	Dependencies     []string `json:"dependencies"`
+	FamilyName       string   `json:"family_name"`
+	FamilyVersion    string   `json:"family_version"`
+	Inputs           []string `json:"inputs"`
+	Nonce            string   `json:"nonce"`
+	Outputs          []string `json:"outputs"`
+	PayloadSha512    string   `json:"payload_sha512"`
+	SignerPublicKey  string   `json:"signer_public_key"`
+}
+
+// restTransaction is a transaction as returned by the REST API
+type restTransaction struct {
+	Header          restTransactionHeader `json:"header"`
+	RawHeader       []byte                `json:"-"` // serialized header, if the REST API sent it base64 encoded
+	HeaderSignature string                `json:"header_signature"`
+	Payload         string                `json:"payload"` // base64 encoded
+}
+
+// UnmarshalJSON decodes a transaction whose header is either expanded or base64 encoded, in which case the raw bytes
+// covered by the header signature are kept
+func (t *restTransaction) UnmarshalJSON(b []byte) error {
+	type plain restTransaction
+	var v struct {
+		plain
+		Header json.RawMessage `json:"header"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*t = restTransaction(v.plain)
+
+	raw, err := rawHeader(v.Header)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		if len(v.Header) == 0 {
+			return nil
+		}
+		return json.Unmarshal(v.Header, &t.Header)
+	}
+	header := &transaction_pb2.TransactionHeader{}
+	if err := proto.Unmarshal(raw, header); err != nil {
+		return fmt.Errorf("Invalid transaction header: %v", err)
+	}
+	t.RawHeader = raw
+	t.Header = restTransactionHeader{
+		BatcherPublicKey: header.BatcherPublicKey,
+		Dependencies:     header.Dependencies,
+		FamilyName:       header.FamilyName,
+		FamilyVersion:    header.FamilyVersion,
+		Inputs:           header.Inputs,
+		Nonce:            header.Nonce,
+		Outputs:          header.Outputs,
+		PayloadSha512:    header.PayloadSha512,
+		SignerPublicKey:  header.SignerPublicKey,
+	}
+
+	return nil
+}
+
+// restBatchHeader is the decoded header of a batch as returned by the REST API
+type restBatchHeader struct {
+	SignerPublicKey string   `json:"signer_public_key"`
+	TransactionIDs  []string `json:"transaction_ids"`
+}
+
+// restBatch is a batch as returned by the REST API
+type restBatch struct {
+	Header          restBatchHeader   `json:"header"`
+	RawHeader       []byte            `json:"-"` // serialized header, if the REST API sent it base64 encoded
+	HeaderSignature string            `json:"header_signature"`
+	Transactions    []restTransaction `json:"transactions"`
+}
+
+// UnmarshalJSON decodes a batch whose header is either expanded or base64 encoded, like restTransaction
+func (b *restBatch) UnmarshalJSON(data []byte) error {
+	type plain restBatch
+	var v struct {
+		plain
+		Header json.RawMessage `json:"header"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = restBatch(v.plain)
+
+	raw, err := rawHeader(v.Header)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		if len(v.Header) == 0 {
+			return nil
+		}
+		return json.Unmarshal(v.Header, &b.Header)
+	}
+	header := &batch_pb2.BatchHeader{}
+	if err := proto.Unmarshal(raw, header); err != nil {
+		return fmt.Errorf("Invalid batch header: %v", err)
+	}
+	b.RawHeader = raw
+	b.Header = restBatchHeader{SignerPublicKey: header.SignerPublicKey, TransactionIDs: header.TransactionIds}
+
+	return nil
+}
+
+// rawHeader returns the bytes of a base64 encoded header, or nil if the header is expanded
+func rawHeader(header json.RawMessage) ([]byte, error) {
+	var encoded string
+	if json.Unmarshal(header, &encoded) != nil || encoded == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Decoding error: %v", err)
+	}
+
+	return raw, nil
+}
+
+// restBlockHeader is the decoded header of a block as returned by the REST API
+type restBlockHeader struct {
+	BatchIDs        []string `json:"batch_ids"`
+	BlockNum        uint64   `json:"block_num,string"`
+	PreviousBlockID string   `json:"previous_block_id"`
+	SignerPublicKey string   `json:"signer_public_key"`
+	StateRootHash   string   `json:"state_root_hash"`
+}
+
+// restBlock is a block as returned by the REST API
+type restBlock struct {
+	Header          restBlockHeader `json:"header"`
+	HeaderSignature string          `json:"header_signature"`
+	Batches         []restBatch     `json:"batches"`
+}
+
+// restEventAttribute is a single attribute of an event
+type restEventAttribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// restEvent is an event as listed in a transaction receipt
+type restEvent struct {
+	EventType  string               `json:"event_type"`
+	Attributes []restEventAttribute `json:"attributes"`
+	Data       string               `json:"data"` // base64 encoded
+}
+
+// restReceipt is a transaction receipt as returned by the REST API
+type restReceipt struct {
+	TransactionID string            `json:"transaction_id"`
+	StateChanges  []restStateChange `json:"state_changes"`
+	Events        []restEvent       `json:"events"`
+	Data          []string          `json:"data"` // base64 encoded
+}
+
+// decodePayload returns the payload of a transaction as a string
+func (t *restTransaction) decodePayload() (string, error) {
+	b, err := base64.StdEncoding.DecodeString(t.Payload)
+	if err != nil {
+		return "", fmt.Errorf("Decoding error: %v", err)
+	}
+
+	return string(b), nil
+}
+
+// getJSON sends a GET request to the REST API and decodes the JSON response into v
+func (c *CookiejarClient) getJSON(suffix string, v interface{}) error {
+	res, err := c.sendRequest(suffix, "", nil)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(res, v); err != nil {
+		return fmt.Errorf("Error reading response: %v", err)
+	}
+
+	return nil
+}
+
+// pageSuffix adds the paging parameters to a URL suffix
+func pageSuffix(suffix, start string) string {
+	sep := "?"
+	if strings.Contains(suffix, "?") {
+		sep = "&"
+	}
+
+	suffix = fmt.Sprintf("%s%slimit=%d", suffix, sep, restPageSize)
+	if start != "" {
+		suffix = fmt.Sprintf("%s&start=%s", suffix, url.QueryEscape(start))
+	}
+
+	return suffix
+}
+
+// listTransactions pages through all committed transactions, newest first, until fn returns false
+func (c *CookiejarClient) listTransactions(fn func(*restTransaction) bool) error {
+	start := ""
+	for {
+		var page struct {
+			Data   []restTransaction `json:"data"`
+			Paging restPaging        `json:"paging"`
+		}
+		if err := c.getJSON(pageSuffix("transactions", start), &page); err != nil {
+			return err
+		}
+
+		for i := range page.Data {
+			if !fn(&page.Data[i]) {
+				return nil
+			}
+		}
+
+		if page.Paging.NextPosition == "" {
+			return nil
+		}
+		start = page.Paging.NextPosition
+	}
+}
+
+// listBlocks pages through the blocks of the chain, newest first, until fn returns false
+func (c *CookiejarClient) listBlocks(fn func(*restBlock) bool) error {
+	start := ""
+	for {
+		var page struct {
+			Data   []restBlock `json:"data"`
+			Paging restPaging  `json:"paging"`
+		}
+		if err := c.getJSON(pageSuffix("blocks", start), &page); err != nil {
+			return err
+		}
+
+		for i := range page.Data {
+			if !fn(&page.Data[i]) {
+				return nil
+			}
+		}
+
+		if page.Paging.NextPosition == "" {
+			return nil
+		}
+		start = page.Paging.NextPosition
+	}
+}
+
+// getBatchStatuses returns the status of every provided batch, indexed by batch id.
+// If wait is set the REST API waits up to that many seconds for the batches to be committed.
+func (c *CookiejarClient) getBatchStatuses(batchIDs []string, wait uint) (map[string]*batchStatus, error) {
+	return fetchBatchStatuses(c.sendRequest, batchIDs, wait)
+}
+
+// fetchBatchStatuses requests the status of the batches using send, which allows asking a specific REST API
+func fetchBatchStatuses(send func(suffix, contentType string, data []byte) ([]byte, error), batchIDs []string,
+	wait uint) (map[string]*batchStatus, error) {
+	statuses := map[string]*batchStatus{}
+	for len(batchIDs) > 0 {
+		// Request the statuses in chunks to keep the requests small
+		n := len(batchIDs)
+		if n > restPageSize {
+			n = restPageSize
+		}
+
+		body, err := json.Marshal(batchIDs[:n])
+		if err != nil {
+			return nil, err
+		}
+		batchIDs = batchIDs[n:]
+
+		suffix := "batch_statuses"
+		if wait > 0 {
+			suffix = fmt.Sprintf("%s?wait=%d", suffix, wait)
+		}
+
+		res, err := send(suffix, "application/json", body)
+		if err != nil {
+			return nil, err
+		}
+
+		var response struct {
+			Data []*batchStatus `json:"data"`
+		}
+		if err := json.Unmarshal(res, &response); err != nil {
+			return nil, fmt.Errorf("Error reading response: %v", err)
+		}
+
+		for _, s := range response.Data {
+			statuses[s.ID] = s
+		}
+	}
+
+	return statuses, nil
+}
+
+// restStateEntry is a single state entry as returned by the REST API
+type restStateEntry struct {
+	Address string `json:"address"`
+	Data    string `json:"data"` // base64 encoded
+}
+
+// listState pages through all state entries under the address prefix in the state of the provided block, until fn returns false.
+// An empty head reads the current state. The id of the block the state was read at is returned.
+func (c *CookiejarClient) listState(prefix, head string, fn func(*restStateEntry) bool) (string, error) {
+	suffix := fmt.Sprintf("state?address=%s", prefix)
+	if head != "" {
+		suffix = fmt.Sprintf("%s&head=%s", suffix, head)
+	}
+
+	start := ""
+	for {
+		var page struct {
+			Data   []restStateEntry `json:"data"`
+			Head   string           `json:"head"`
+			Paging restPaging       `json:"paging"`
+		}
+		if err := c.getJSON(pageSuffix(suffix, start), &page); err != nil {
+			return "", err
+		}
+
+		// Keep reading from the same block while paging
+		if head == "" {
+			head = page.Head
+			suffix = fmt.Sprintf("%s&head=%s", suffix, head)
+		}
+
+		for i := range page.Data {
+			if !fn(&page.Data[i]) {
+				return head, nil
+			}
+		}
+
+		if page.Paging.NextPosition == "" {
+			return head, nil
+		}
+		start = page.Paging.NextPosition
+	}
+}
+
+// resolveBlock returns the id of a block referenced either by its id or by its number
+func (c *CookiejarClient) resolveBlock(ref string) (string, error) {
+	if _, err := hex.DecodeString(ref); err == nil && len(ref) == 128 {
+		return ref, nil
+	}
+
+	num, err := strconv.ParseUint(ref, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid block %q, use a block id or number", ref)
+	}
+
+	id := ""
+	if err := c.listBlocks(func(b *restBlock) bool {
+		if b.Header.BlockNum == num {
+			id = b.HeaderSignature
+		}
+		return b.Header.BlockNum > num
+	}); err != nil {
+		return "", err
+	}
+
+	if id == "" {
+		return "", fmt.Errorf("Block %d not found", num)
+	}
+
+	return id, nil
+}
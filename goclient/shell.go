@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// shell is an interactive session which keeps a single client for many commands
+type shell struct {
+	client  *CookiejarClient
+	profile string
+	key     string
+	jar     string
+}
+
+// prompt returns the prompt showing the current profile, key and jar
+func (s *shell) prompt() string {
+	if s.jar != "" {
+		return fmt.Sprintf("cookiejar(%s:%s jar:%s)> ", s.profile, s.key, s.jar)
+	}
+
+	return fmt.Sprintf("cookiejar(%s:%s)> ", s.profile, s.key)
+}
+
+// completer returns the tab completion for the shell's commands, key names and jars
+func (s *shell) completer() *readline.PrefixCompleter {
+	keys := func(string) []string { return localKeyNames() }
+
+	items := []readline.PrefixCompleterInterface{
+		readline.PcItem("use",
+			readline.PcItem("jar", readline.PcItemDynamic(keys)),
+			readline.PcItem("key", readline.PcItemDynamic(keys)),
+		),
+		readline.PcItem("help"),
+		readline.PcItem("exit"),
+	}
+	for _, name := range commandNames {
+		switch name {
+		case "history", "watch":
+			items = append(items, readline.PcItem(name, readline.PcItem("--jar", readline.PcItemDynamic(keys))))
+		case "bake", "eat", "clear":
+			items = append(items, readline.PcItem(name, readline.PcItem("--dry-run")))
+		default:
+			items = append(items, readline.PcItem(name))
+		}
+	}
+
+	return readline.NewPrefixCompleter(items...)
+}
+
+// use switches the key or jar of the session
+func (s *shell) use(args []string) error {
+	if len(args) != 2 {
+		return usageError{"use requires jar or key and a name"}
+	}
+
+	switch args[0] {
+	case "key":
+		if err := s.client.useKey(args[1]); err != nil {
+			return err
+		}
+		s.key = args[1]
+	case "jar":
+		if err := s.client.useJar(args[1]); err != nil {
+			return err
+		}
+		s.jar = args[1]
+	default:
+		return usageError{fmt.Sprintf("Cannot use %q, only jar or key", args[0])}
+	}
+
+	return nil
+}
+
+// runShell starts an interactive prompt which executes commands until exit or EOF
+func runShell(client *CookiejarClient, profile *Profile, profileName string) error {
+	s := &shell{
+		client:  client,
+		profile: profileName,
+		key:     profile.Key,
+		jar:     profile.Jar,
+	}
+
+	// The history is kept next to the configuration, whose directory may not exist yet
+	historyFile := filepath.Join(filepath.Dir(configPath()), "shell_history")
+	if err := os.MkdirAll(filepath.Dir(historyFile), 0700); err != nil {
+		return fmt.Errorf("Failed to create history directory: %v", err)
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          s.prompt(),
+		HistoryFile:     historyFile,
+		AutoComplete:    s.completer(),
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "exit", "quit":
+			return nil
+		case "help":
+			printHelp("")
+			fmt.Println("use jar <name>\nuse key <name>\nexit")
+		case "use":
+			if err := s.use(args[1:]); err != nil {
+				fmt.Println(err)
+			}
+			rl.SetPrompt(s.prompt())
+		default:
+			if err := runCommand(client, args); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
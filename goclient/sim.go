@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cookiejar"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/sawtooth-sdk-go/processor"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+)
+
+// simStore is the simulated network as persisted between invocations
+type simStore struct {
+	BlockNum uint64                  `json:"block_num"`
+	BlockID  string                  `json:"block_id"`
+	State    map[string][]byte       `json:"state"`
+	Batches  map[string]*batchStatus `json:"batches"`
+}
+
+// simTransport executes the batches locally with the processor's handler instead of sending them to a network.
+// Every accepted batch is committed in a block of its own and the state is saved after every block.
+type simTransport struct {
+	file     string
+	receipts io.Writer
+	mu       sync.Mutex
+	store    *simStore
+	executor *cookiejar.Executor
+}
+
+// simulationPath returns the default location of the simulated network
+func simulationPath() string {
+	return path.Join(path.Dir(configPath()), "simulation.json")
+}
+
+// newSimTransport loads the simulated network from file, starting a new one when the file doesn't exist.
+// The receipts of the executed transactions are written to receipts.
+func newSimTransport(file string, receipts io.Writer) (*simTransport, error) {
+	if file == "" {
+		file = simulationPath()
+	}
+
+	store := &simStore{}
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read simulation: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, store); err != nil {
+			return nil, fmt.Errorf("Failed to parse simulation %s: %v", file, err)
+		}
+	}
+	if store.State == nil {
+		store.State = map[string][]byte{}
+	}
+	if store.Batches == nil {
+		store.Batches = map[string]*batchStatus{}
+	}
+
+	return &simTransport{
+		file:     file,
+		receipts: receipts,
+		store:    store,
+		executor: cookiejar.NewExecutor(cookiejar.NewCookiejarHandler(), store.State),
+	}, nil
+}
+
+// save writes the simulated network to its file, replacing the previous one at once
+func (t *simTransport) save() error {
+	b, err := json.MarshalIndent(t.store, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(t.file), 0700); err != nil {
+		return fmt.Errorf("Failed to create simulation directory: %v", err)
+	}
+	tmp := t.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("Failed to write simulation: %v", err)
+	}
+
+	return os.Rename(tmp, t.file)
+}
+
+// newRestReceipt converts a receipt of the executor into the form returned by the REST API
+func newRestReceipt(r *cookiejar.Receipt) restReceipt {
+	receipt := restReceipt{
+		TransactionID: r.TransactionID,
+		StateChanges:  []restStateChange{},
+		Events:        []restEvent{},
+		Data:          []string{},
+	}
+	for _, change := range r.StateChanges {
+		changeType := "SET"
+		if change.Type == transaction_receipt_pb2.StateChange_DELETE {
+			changeType = "DELETE"
+		}
+		receipt.StateChanges = append(receipt.StateChanges, restStateChange{
+			Type:    changeType,
+			Address: change.Address,
+			Value:   base64.StdEncoding.EncodeToString(change.Value),
+		})
+	}
+	for _, event := range r.Events {
+		e := restEvent{
+			EventType:  event.EventType,
+			Attributes: []restEventAttribute{},
+			Data:       base64.StdEncoding.EncodeToString(event.Data),
+		}
+		for _, a := range event.Attributes {
+			e.Attributes = append(e.Attributes, restEventAttribute{a.Key, a.Value})
+		}
+		receipt.Events = append(receipt.Events, e)
+	}
+	for _, data := range r.Data {
+		receipt.Data = append(receipt.Data, base64.StdEncoding.EncodeToString(data))
+	}
+
+	return receipt
+}
+
+func (t *simTransport) submitBatches(batches []*batch_pb2.Batch) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	enc := json.NewEncoder(t.receipts)
+	for _, batch := range batches {
+		// Like the validator, a batch is only committed once
+		if status, ok := t.store.Batches[batch.HeaderSignature]; ok && status.Status == "COMMITTED" {
+			continue
+		}
+
+		receipts, err := t.executor.ExecuteBatch(batch.Transactions)
+		if err != nil {
+			// A validator would retry transactions failing with an internal error forever,
+			// the simulation rejects them right away
+			message := err.Error()
+			if _, ok := err.(*processor.InternalError); ok {
+				message = fmt.Sprintf("Internal error: %s", message)
+			}
+			t.store.Batches[batch.HeaderSignature] = &batchStatus{
+				ID:     batch.HeaderSignature,
+				Status: "INVALID",
+				InvalidTransactions: []invalidTransaction{
+					{ID: batch.Transactions[len(receipts)].HeaderSignature, Message: message},
+				},
+			}
+			continue
+		}
+
+		// Commit the batch in a new block
+		t.store.BlockNum++
+		t.store.BlockID = hexdigest(fmt.Sprintf("%s%d%s", t.store.BlockID, t.store.BlockNum, batch.HeaderSignature))
+		t.store.Batches[batch.HeaderSignature] = &batchStatus{ID: batch.HeaderSignature, Status: "COMMITTED"}
+
+		for _, r := range receipts {
+			if err := enc.Encode(newRestReceipt(r)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return t.save()
+}
+
+func (t *simTransport) batchStatuses(batchIDs []string, wait uint) (map[string]*batchStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := map[string]*batchStatus{}
+	for _, id := range batchIDs {
+		if status, ok := t.store.Batches[id]; ok {
+			statuses[id] = status
+		} else {
+			statuses[id] = &batchStatus{ID: id, Status: "UNKNOWN"}
+		}
+	}
+
+	return statuses, nil
+}
+
+func (t *simTransport) getState(address, head string) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Only the state of the latest block is kept
+	if head != "" && !strings.EqualFold(head, t.store.BlockID) {
+		return nil, fmt.Errorf("The simulation only keeps the state of the latest block")
+	}
+
+	data, ok := t.store.State[address]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return data, nil
+}
+
+func (t *simTransport) close() {}
@@ -0,0 +1,342 @@
+// Package cookiejarasync submits cookiejar operations without blocking. An Aggregator coalesces the operations
+// submitted within a window into shared batches and returns a Future per operation, which is resolved once its batch
+// is committed or rejected.
+package cookiejarasync
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+)
+
+const (
+	// DefaultMaxSize is the maximum amount of operations per batch when the options don't set one
+	DefaultMaxSize = 100
+	// DefaultTimeout is how long a batch may stay pending when the options don't set a timeout
+	DefaultTimeout = time.Minute
+	// DefaultPollInterval is the time between two batch status requests when the options don't set one
+	DefaultPollInterval = 250 * time.Millisecond
+)
+
+// ErrClosed resolves the operations submitted after the aggregator was closed
+var ErrClosed = fmt.Errorf("aggregator is closed")
+
+// InvalidTransaction describes why a transaction in a batch was rejected
+type InvalidTransaction struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// BatchStatus is the status of a submitted batch, as returned by the REST API's /batch_statuses
+type BatchStatus struct {
+	ID                  string               `json:"id"`
+	Status              string               `json:"status"`
+	InvalidTransactions []InvalidTransaction `json:"invalid_transactions"`
+}
+
+// Client builds, signs and submits the transactions of an aggregator
+type Client interface {
+	// NewTransaction returns the signed transaction of a bake, eat or clear operation
+	NewTransaction(action string, amount int) (*transaction_pb2.Transaction, error)
+	// NewBatch returns the signed batch of the transactions
+	NewBatch(transactions []*transaction_pb2.Transaction) (*batch_pb2.Batch, error)
+	// SubmitBatches sends the batches to the network
+	SubmitBatches(batches []*batch_pb2.Batch) error
+	// BatchStatuses returns the current status of the batches without waiting
+	BatchStatuses(batchIDs []string) (map[string]*BatchStatus, error)
+}
+
+// Options configures an aggregator
+type Options struct {
+	// Window is how long operations are collected before they are submitted. 0 submits every operation in its own
+	// batch.
+	Window time.Duration
+	// MaxSize submits the collected operations as soon as there are that many. Default DefaultMaxSize.
+	MaxSize int
+	// Timeout resolves the operations of a batch with an error when it isn't committed or rejected in time. Default
+	// DefaultTimeout.
+	Timeout time.Duration
+	// PollInterval is the time between two batch status requests. Default DefaultPollInterval.
+	PollInterval time.Duration
+	// Logf logs the failed status requests. Defaults to the standard logger.
+	Logf func(format string, args ...interface{})
+}
+
+// Future is the handle of an asynchronously submitted operation. It's resolved once the batch holding the
+// operation is committed or rejected, or when it couldn't be submitted at all.
+type Future struct {
+	done   chan struct{}
+	status *BatchStatus
+	err    error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(status *BatchStatus, err error) {
+	f.status, f.err = status, err
+	close(f.done)
+}
+
+// Done returns a channel which is closed once the operation is resolved
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the operation is resolved and returns the status of its batch. The error is set when the batch
+// couldn't be submitted or timed out, a rejected batch has the status INVALID.
+func (f *Future) Wait() (*BatchStatus, error) {
+	<-f.done
+	return f.status, f.err
+}
+
+// operation is an operation waiting to be put in a batch
+type operation struct {
+	transaction *transaction_pb2.Transaction
+	future      *Future
+}
+
+// pendingBatch is a submitted batch whose status isn't known yet
+type pendingBatch struct {
+	futures   []*Future
+	submitted time.Time
+}
+
+// Aggregator coalesces the operations submitted within a window into shared batches, and resolves the futures of
+// all submitted batches with a single status request per poll. A batch is atomic: when one of its operations is
+// invalid, for instance eating more cookies than the jar holds, all operations of the batch are rejected.
+type Aggregator struct {
+	client Client
+	opts   Options
+
+	mu       sync.Mutex
+	queue    []operation
+	timer    *time.Timer
+	pending  map[string]*pendingBatch // batch id -> futures of its operations
+	closed   bool
+	flushing sync.WaitGroup
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAggregator starts an aggregator submitting the operations with the client
+func NewAggregator(client Client, opts Options) *Aggregator {
+	if opts.MaxSize < 1 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+
+	a := &Aggregator{
+		client:  client,
+		opts:    opts,
+		pending: map[string]*pendingBatch{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.track()
+
+	return a
+}
+
+// Bake submits baking amount cookies
+func (a *Aggregator) Bake(amount int) *Future {
+	return a.Submit("bake", amount)
+}
+
+// Eat submits eating amount cookies
+func (a *Aggregator) Eat(amount int) *Future {
+	return a.Submit("eat", amount)
+}
+
+// Clear submits emptying the jar
+func (a *Aggregator) Clear() *Future {
+	return a.Submit("clear", 0)
+}
+
+// Submit queues an operation and returns its future without waiting for it to be submitted
+func (a *Aggregator) Submit(action string, amount int) *Future {
+	f := newFuture()
+	transaction, err := a.client.NewTransaction(action, amount)
+	if err != nil {
+		f.resolve(nil, err)
+		return f
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		f.resolve(nil, ErrClosed)
+		return f
+	}
+
+	a.queue = append(a.queue, operation{transaction, f})
+	if a.opts.Window <= 0 || len(a.queue) >= a.opts.MaxSize {
+		ops := a.takeQueue()
+		a.mu.Unlock()
+		a.flush(ops)
+		return f
+	}
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.opts.Window, a.flushQueue)
+	}
+	a.mu.Unlock()
+
+	return f
+}
+
+// takeQueue empties the queue and returns its operations. The lock must be held.
+func (a *Aggregator) takeQueue() []operation {
+	ops := a.queue
+	a.queue = nil
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	if len(ops) > 0 {
+		a.flushing.Add(1)
+	}
+
+	return ops
+}
+
+// flushQueue submits the queued operations once the window passed
+func (a *Aggregator) flushQueue() {
+	a.mu.Lock()
+	ops := a.takeQueue()
+	a.mu.Unlock()
+
+	a.flush(ops)
+}
+
+// flush submits the operations in a single batch and hands it to the tracker
+func (a *Aggregator) flush(ops []operation) {
+	if len(ops) == 0 {
+		return
+	}
+	defer a.flushing.Done()
+
+	transactions := make([]*transaction_pb2.Transaction, 0, len(ops))
+	futures := make([]*Future, 0, len(ops))
+	for _, op := range ops {
+		transactions = append(transactions, op.transaction)
+		futures = append(futures, op.future)
+	}
+
+	batch, err := a.client.NewBatch(transactions)
+	if err == nil {
+		err = a.client.SubmitBatches([]*batch_pb2.Batch{batch})
+	}
+	if err != nil {
+		for _, f := range futures {
+			f.resolve(nil, err)
+		}
+		return
+	}
+
+	a.mu.Lock()
+	a.pending[batch.HeaderSignature] = &pendingBatch{futures, time.Now()}
+	a.mu.Unlock()
+}
+
+// outstanding returns the amount of submitted batches which aren't resolved yet
+func (a *Aggregator) outstanding() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.pending)
+}
+
+// poll requests the status of all pending batches at once and resolves the futures of the finished ones
+func (a *Aggregator) poll() error {
+	a.mu.Lock()
+	ids := make([]string, 0, len(a.pending))
+	for id := range a.pending {
+		ids = append(ids, id)
+	}
+	a.mu.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Batches still time out when their status can't be read
+	statuses, err := a.client.BatchStatuses(ids)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, id := range ids {
+		batch := a.pending[id]
+		status, ok := statuses[id]
+
+		var resolveErr error
+		if ok && (status.Status == "COMMITTED" || status.Status == "INVALID") {
+			// The batch is finished
+		} else if time.Since(batch.submitted) > a.opts.Timeout {
+			status, resolveErr = nil, fmt.Errorf("timeout")
+		} else {
+			continue
+		}
+
+		for _, f := range batch.futures {
+			f.resolve(status, resolveErr)
+		}
+		delete(a.pending, id)
+	}
+
+	return err
+}
+
+// track polls the pending batches until the aggregator is closed and all of them are resolved
+func (a *Aggregator) track() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.opts.PollInterval)
+	defer ticker.Stop()
+
+	stop := a.stop
+	for {
+		select {
+		case <-stop:
+			stop = nil
+		case <-ticker.C:
+			if err := a.poll(); err != nil {
+				a.opts.Logf("Failed to read batch statuses: %v", err)
+			}
+		}
+
+		if stop == nil && a.outstanding() == 0 {
+			return
+		}
+	}
+}
+
+// Close submits the queued operations and waits until all futures are resolved
+func (a *Aggregator) Close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		<-a.done
+		return
+	}
+	a.closed = true
+	ops := a.takeQueue()
+	a.mu.Unlock()
+
+	a.flush(ops)
+	a.flushing.Wait()
+	close(a.stop)
+	<-a.done
+}
@@ -0,0 +1,182 @@
+package cookiejarasync
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+)
+
+// fakeClient records the submitted batches and answers the status requests with status
+type fakeClient struct {
+	mu        sync.Mutex
+	batches   [][]string // actions of the transactions of every submitted batch
+	status    string
+	submitErr error
+}
+
+func (c *fakeClient) NewTransaction(action string, amount int) (*transaction_pb2.Transaction, error) {
+	if amount < 0 {
+		return nil, fmt.Errorf("negative amount")
+	}
+	return &transaction_pb2.Transaction{HeaderSignature: fmt.Sprintf("%s,%d", action, amount)}, nil
+}
+
+func (c *fakeClient) NewBatch(transactions []*transaction_pb2.Transaction) (*batch_pb2.Batch, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var actions []string
+	for _, t := range transactions {
+		actions = append(actions, t.HeaderSignature)
+	}
+	c.batches = append(c.batches, actions)
+
+	return &batch_pb2.Batch{Transactions: transactions, HeaderSignature: fmt.Sprintf("batch-%d", len(c.batches))}, nil
+}
+
+func (c *fakeClient) SubmitBatches(batches []*batch_pb2.Batch) error {
+	return c.submitErr
+}
+
+func (c *fakeClient) BatchStatuses(batchIDs []string) (map[string]*BatchStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := map[string]*BatchStatus{}
+	for _, id := range batchIDs {
+		statuses[id] = &BatchStatus{ID: id, Status: c.status}
+	}
+
+	return statuses, nil
+}
+
+func (c *fakeClient) submitted() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]string{}, c.batches...)
+}
+
+// wait returns the result of the future, failing the test if it isn't resolved within a second
+func wait(t *testing.T, f *Future) (*BatchStatus, error) {
+	t.Helper()
+	select {
+	case <-f.Done():
+	case <-time.After(time.Second):
+		t.Fatal("future not resolved")
+	}
+	return f.Wait()
+}
+
+func newTestAggregator(client Client, window time.Duration, maxSize int) *Aggregator {
+	return NewAggregator(client, Options{
+		Window:       window,
+		MaxSize:      maxSize,
+		Timeout:      100 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+		Logf:         func(string, ...interface{}) {},
+	})
+}
+
+func TestWindowBatchesOperations(t *testing.T) {
+	client := &fakeClient{status: "COMMITTED"}
+	a := newTestAggregator(client, 50*time.Millisecond, 10)
+	defer a.Close()
+
+	futures := []*Future{a.Bake(3), a.Eat(1), a.Clear()}
+	if n := len(client.submitted()); n != 0 {
+		t.Fatalf("submitted %d batches before the window passed", n)
+	}
+	for _, f := range futures {
+		status, err := wait(t, f)
+		if err != nil || status.ID != "batch-1" || status.Status != "COMMITTED" {
+			t.Fatalf("got %+v, %v, want batch-1 COMMITTED", status, err)
+		}
+	}
+
+	batches := client.submitted()
+	if len(batches) != 1 || len(batches[0]) != 3 || batches[0][0] != "bake,3" || batches[0][2] != "clear,0" {
+		t.Fatalf("got batches %v, want one batch of the 3 operations in order", batches)
+	}
+}
+
+func TestMaxSizeFlushesBeforeWindow(t *testing.T) {
+	client := &fakeClient{status: "COMMITTED"}
+	a := newTestAggregator(client, time.Hour, 2)
+	defer a.Close()
+
+	for i := 1; i <= 4; i++ {
+		a.Bake(i)
+	}
+	if batches := client.submitted(); len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 2 {
+		t.Fatalf("got batches %v, want 2 batches of 2 operations", batches)
+	}
+
+	// The fifth operation waits for the window, until the aggregator is closed
+	f := a.Bake(5)
+	if n := len(client.submitted()); n != 2 {
+		t.Fatalf("submitted %d batches, want 2", n)
+	}
+	a.Close()
+	if status, err := wait(t, f); err != nil || status.ID != "batch-3" {
+		t.Fatalf("got %+v, %v, want batch-3", status, err)
+	}
+}
+
+func TestZeroWindowSubmitsEachOperation(t *testing.T) {
+	client := &fakeClient{status: "COMMITTED"}
+	a := newTestAggregator(client, 0, 10)
+	defer a.Close()
+
+	a.Bake(1)
+	a.Bake(2)
+	if n := len(client.submitted()); n != 2 {
+		t.Fatalf("submitted %d batches, want 2", n)
+	}
+}
+
+func TestFutureResolvesInvalidBatch(t *testing.T) {
+	client := &fakeClient{status: "INVALID"}
+	a := newTestAggregator(client, 0, 10)
+	defer a.Close()
+
+	status, err := wait(t, a.Eat(5))
+	if err != nil || status.Status != "INVALID" {
+		t.Fatalf("got %+v, %v, want INVALID", status, err)
+	}
+}
+
+func TestFutureResolvesSubmitError(t *testing.T) {
+	client := &fakeClient{submitErr: fmt.Errorf("unavailable")}
+	a := newTestAggregator(client, 0, 10)
+	defer a.Close()
+
+	if status, err := wait(t, a.Bake(1)); err == nil || err.Error() != "unavailable" || status != nil {
+		t.Fatalf("got %+v, %v, want the submit error", status, err)
+	}
+	if _, err := wait(t, a.Bake(-1)); err == nil {
+		t.Fatal("expected the transaction error")
+	}
+}
+
+func TestFutureTimesOut(t *testing.T) {
+	client := &fakeClient{status: "PENDING"}
+	a := newTestAggregator(client, 0, 10)
+	defer a.Close()
+
+	if status, err := wait(t, a.Bake(1)); err == nil || status != nil {
+		t.Fatalf("got %+v, %v, want a timeout", status, err)
+	}
+}
+
+func TestSubmitAfterClose(t *testing.T) {
+	a := newTestAggregator(&fakeClient{status: "COMMITTED"}, 0, 10)
+	a.Close()
+
+	if _, err := wait(t, a.Bake(1)); err != ErrClosed {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+}
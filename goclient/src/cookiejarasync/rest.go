@@ -0,0 +1,169 @@
+package cookiejarasync
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+const (
+	// FamilyName is the name of the cookiejar transaction family
+	FamilyName = "cookiejar"
+	// FamilyVersion is the family version of the transactions built by a RESTClient
+	FamilyVersion = "1.0"
+	// requestTimeout bounds every request of a RESTClient
+	requestTimeout = 30 * time.Second
+)
+
+// hexdigest returns the sha512 hash of the input as a hex string
+func hexdigest(str string) string {
+	hash := sha512.Sum512([]byte(str))
+	return hex.EncodeToString(hash[:])
+}
+
+// RESTClient is a Client which signs the transactions and batches of the signer's jar and talks to a REST API
+type RESTClient struct {
+	url     string
+	signer  *signing.Signer
+	address string
+	http    *http.Client
+}
+
+// NewRESTClient returns a client submitting to the REST API at the url, e.g. http://rest-api:8008, with the
+// transactions and batches signed by the signer, who owns the jar
+func NewRESTClient(url string, signer *signing.Signer) *RESTClient {
+	return &RESTClient{
+		url:     strings.TrimSuffix(url, "/"),
+		signer:  signer,
+		address: hexdigest(FamilyName)[:6] + hexdigest(signer.GetPublicKey().AsHex())[:64],
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// NewTransaction returns the signed transaction of a bake, eat or clear operation on the signer's jar
+func (c *RESTClient) NewTransaction(action string, amount int) (*transaction_pb2.Transaction, error) {
+	payload := strings.Join([]string{action, strconv.Itoa(amount)}, ",")
+	publicKey := c.signer.GetPublicKey().AsHex()
+
+	header, err := proto.Marshal(&transaction_pb2.TransactionHeader{
+		SignerPublicKey:  publicKey,
+		FamilyName:       FamilyName,
+		FamilyVersion:    FamilyVersion,
+		Inputs:           []string{c.address},
+		Outputs:          []string{c.address},
+		PayloadSha512:    hexdigest(payload),
+		BatcherPublicKey: publicKey,
+		Nonce:            strconv.Itoa(rand.Int()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize transaction header: %v", err)
+	}
+
+	return &transaction_pb2.Transaction{
+		Header:          header,
+		HeaderSignature: hex.EncodeToString(c.signer.Sign(header)),
+		Payload:         []byte(payload),
+	}, nil
+}
+
+// NewBatch returns the batch of the transactions, signed by the signer
+func (c *RESTClient) NewBatch(transactions []*transaction_pb2.Transaction) (*batch_pb2.Batch, error) {
+	ids := make([]string, 0, len(transactions))
+	for _, t := range transactions {
+		ids = append(ids, t.HeaderSignature)
+	}
+
+	header, err := proto.Marshal(&batch_pb2.BatchHeader{
+		SignerPublicKey: c.signer.GetPublicKey().AsHex(),
+		TransactionIds:  ids,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to serialize batch header: %v", err)
+	}
+
+	return &batch_pb2.Batch{
+		Header:          header,
+		Transactions:    transactions,
+		HeaderSignature: hex.EncodeToString(c.signer.Sign(header)),
+	}, nil
+}
+
+// SubmitBatches posts the batches to the REST API's /batches
+func (c *RESTClient) SubmitBatches(batches []*batch_pb2.Batch) error {
+	batchList, err := proto.Marshal(&batch_pb2.BatchList{Batches: batches})
+	if err != nil {
+		return fmt.Errorf("Unable to serialize batch list: %v", err)
+	}
+
+	_, err = c.post("batches", "application/octet-stream", batchList)
+	return err
+}
+
+// BatchStatuses posts the ids of all batches to the REST API's /batch_statuses at once, without waiting
+func (c *RESTClient) BatchStatuses(batchIDs []string) (map[string]*BatchStatus, error) {
+	ids, err := json.Marshal(batchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.post("batch_statuses", "application/json", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []*BatchStatus `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("Invalid batch statuses: %v", err)
+	}
+
+	statuses := map[string]*BatchStatus{}
+	for _, s := range response.Data {
+		statuses[s.ID] = s
+	}
+
+	return statuses, nil
+}
+
+// post sends the data to the REST API and returns the body of a successful response
+func (c *RESTClient) post(suffix, contentType string, data []byte) ([]byte, error) {
+	response, err := c.http.Post(c.url+"/"+suffix, contentType, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to REST API: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response: %v", err)
+	}
+	if response.StatusCode >= 400 {
+		var restError struct {
+			Error struct {
+				Title   string `json:"title"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &restError); err == nil && restError.Error.Message != "" {
+			return nil, fmt.Errorf("Error %d: %s: %s", response.StatusCode, restError.Error.Title,
+				restError.Error.Message)
+		}
+		return nil, fmt.Errorf("Error %d: %s", response.StatusCode, response.Status)
+	}
+
+	return body, nil
+}
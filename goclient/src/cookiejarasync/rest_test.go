@@ -0,0 +1,170 @@
+package cookiejarasync
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+// restServer is a REST API which verifies the signatures of the submitted batches and commits them
+type restServer struct {
+	t       *testing.T
+	context signing.Context
+	mu      sync.Mutex
+	batches map[string][]string // payloads of the transactions of every submitted batch
+	polls   [][]string
+}
+
+func (s *restServer) verify(signature string, header []byte, publicKey string) {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		s.t.Errorf("invalid signature %q", signature)
+		return
+	}
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || !s.context.Verify(sig, header, signing.NewSecp256k1PublicKey(key)) {
+		s.t.Errorf("signature %.16s doesn't match the header", signature)
+	}
+}
+
+func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || r.Method != "POST" {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/batches":
+		var list batch_pb2.BatchList
+		if err := proto.Unmarshal(body, &list); err != nil {
+			s.t.Error(err)
+			return
+		}
+		for _, b := range list.Batches {
+			var header batch_pb2.BatchHeader
+			if err := proto.Unmarshal(b.Header, &header); err != nil {
+				s.t.Error(err)
+				return
+			}
+			s.verify(b.HeaderSignature, b.Header, header.SignerPublicKey)
+
+			var payloads []string
+			for i, tx := range b.Transactions {
+				txHeader := &transaction_pb2.TransactionHeader{}
+				if err := proto.Unmarshal(tx.Header, txHeader); err != nil {
+					s.t.Error(err)
+					return
+				}
+				s.verify(tx.HeaderSignature, tx.Header, txHeader.SignerPublicKey)
+				if header.TransactionIds[i] != tx.HeaderSignature || txHeader.PayloadSha512 != hexdigest(string(tx.Payload)) {
+					s.t.Errorf("transaction %d of batch %.16s doesn't match its header", i, b.HeaderSignature)
+				}
+				address := "a4d219" + hexdigest(txHeader.SignerPublicKey)[:64]
+				if txHeader.FamilyName != "cookiejar" || txHeader.Outputs[0] != address {
+					s.t.Errorf("got transaction header %+v, want the signer's jar", txHeader)
+				}
+				payloads = append(payloads, string(tx.Payload))
+			}
+			s.batches[b.HeaderSignature] = payloads
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"link": "http://rest-api/batch_statuses"}`))
+	case "/batch_statuses":
+		var ids []string
+		if err := json.Unmarshal(body, &ids); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			s.t.Errorf("got batch ids %s, want a JSON list", body)
+		}
+		s.polls = append(s.polls, ids)
+
+		statuses := []BatchStatus{}
+		for _, id := range ids {
+			if _, ok := s.batches[id]; ok {
+				statuses = append(statuses, BatchStatus{ID: id, Status: "COMMITTED"})
+			} else {
+				statuses = append(statuses, BatchStatus{ID: id, Status: "UNKNOWN"})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": statuses})
+	default:
+		s.t.Errorf("unexpected request %s", r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func newTestSigner() *signing.Signer {
+	context := signing.NewSecp256k1Context()
+	return signing.NewCryptoFactory(context).NewSigner(context.NewRandomPrivateKey())
+}
+
+func TestRESTClientSubmitsSignedBatches(t *testing.T) {
+	rest := &restServer{t: t, context: signing.NewSecp256k1Context(), batches: map[string][]string{}}
+	server := httptest.NewServer(rest)
+	defer server.Close()
+
+	aggregator := newTestAggregator(NewRESTClient(server.URL+"/", newTestSigner()), time.Hour, 3)
+	futures := []*Future{aggregator.Bake(5), aggregator.Eat(2), aggregator.Clear()}
+	for _, f := range futures {
+		status, err := wait(t, f)
+		if err != nil || status.Status != "COMMITTED" {
+			t.Fatalf("got %+v, %v, want the batch committed", status, err)
+		}
+	}
+	aggregator.Close()
+
+	rest.mu.Lock()
+	defer rest.mu.Unlock()
+	if len(rest.batches) != 1 {
+		t.Fatalf("got %d batches, want 1", len(rest.batches))
+	}
+	for id, payloads := range rest.batches {
+		if strings.Join(payloads, ";") != "bake,5;eat,2;clear,0" {
+			t.Errorf("got payloads %v", payloads)
+		}
+		// The batch status is read with a single request for all pending batches
+		if len(rest.polls) == 0 || len(rest.polls[0]) != 1 || rest.polls[0][0] != id {
+			t.Errorf("got status requests %v, want %.16s", rest.polls, id)
+		}
+	}
+}
+
+func TestRESTClientReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"code": 34, "title": "No Batches Submitted", "message": "The protobuf BatchList ` +
+			`you submitted was empty"}}`))
+	}))
+	defer server.Close()
+
+	client := NewRESTClient(server.URL, newTestSigner())
+	err := client.SubmitBatches(nil)
+	if err == nil || !strings.Contains(err.Error(), "No Batches Submitted") {
+		t.Errorf("got %v, want the REST API's error", err)
+	}
+
+	// The futures of a rejected submission are resolved with the error
+	aggregator := newTestAggregator(client, 0, 1)
+	defer aggregator.Close()
+	if _, err := wait(t, aggregator.Bake(1)); err == nil || !strings.Contains(err.Error(), "was empty") {
+		t.Errorf("got %v, want the REST API's error", err)
+	}
+
+	if _, err := client.BatchStatuses([]string{"batch"}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("got %v, want the REST API's error", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+)
+
+// transport carries the client's batches and state reads to the Sawtooth network
+type transport interface {
+	// submitBatches sends the batches to the network
+	submitBatches(batches []*batch_pb2.Batch) error
+	// batchStatuses returns the status of the batches, waiting up to wait seconds for them to be committed
+	batchStatuses(batchIDs []string, wait uint) (map[string]*batchStatus, error)
+	// getState returns the data stored at the address in the state of the head block, or the current state if head is empty
+	getState(address, head string) ([]byte, error)
+	// close releases the transport's connections
+	close()
+}
+
+// invalidTransaction describes why a transaction in a batch was rejected
+type invalidTransaction struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// batchStatus is the status of a submitted batch
+type batchStatus struct {
+	ID                  string               `json:"id"`
+	Status              string               `json:"status"`
+	InvalidTransactions []invalidTransaction `json:"invalid_transactions"`
+}
+
+// String returns the status of the batch, including the reasons why its transactions were rejected
+func (s *batchStatus) String() string {
+	str := fmt.Sprintf("batch %s: %s", s.ID, s.Status)
+	if len(s.InvalidTransactions) > 0 {
+		messages := make([]string, 0, len(s.InvalidTransactions))
+		for _, t := range s.InvalidTransactions {
+			messages = append(messages, t.Message)
+		}
+		str = fmt.Sprintf("%s (%s)", str, strings.Join(messages, "; "))
+	}
+
+	return str
+}
+
+// restTransport talks to the network via the REST API
+type restTransport struct {
+	client *CookiejarClient
+}
+
+// submitBatches sends the batches to the healthiest REST API. When it fails over to another REST API, the batches
+// the previous one may already have accepted are looked up first so that only the unknown ones are resubmitted.
+func (t *restTransport) submitBatches(batches []*batch_pb2.Batch) error {
+	c := t.client
+
+	var err error
+	for i, ep := range c.orderedEndpoints() {
+		pending := batches
+		if i > 0 {
+			if pending, err = t.unknownBatches(ep, batches); err == nil && len(pending) == 0 {
+				return nil
+			}
+		}
+
+		if err == nil {
+			var batchList []byte
+			if batchList, err = marshalBatchList(pending); err != nil {
+				return err
+			}
+			_, err = c.sendRequestTo(ep.url, "batches", "application/octet-stream", batchList)
+		}
+		if _, ok := err.(*unavailableError); !ok {
+			return err
+		}
+
+		c.endpoints.markDown(ep)
+		logger.Warnf("REST API %s unavailable: %v", ep.url, err)
+	}
+
+	return err
+}
+
+// unknownBatches returns the batches the REST API at ep doesn't know about
+func (t *restTransport) unknownBatches(ep *endpoint, batches []*batch_pb2.Batch) ([]*batch_pb2.Batch, error) {
+	send := func(suffix, contentType string, data []byte) ([]byte, error) {
+		return t.client.sendRequestTo(ep.url, suffix, contentType, data)
+	}
+
+	ids := make([]string, 0, len(batches))
+	for _, batch := range batches {
+		ids = append(ids, batch.HeaderSignature)
+	}
+	statuses, err := fetchBatchStatuses(send, ids, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []*batch_pb2.Batch
+	for _, batch := range batches {
+		if status, ok := statuses[batch.HeaderSignature]; !ok || status.Status == "UNKNOWN" {
+			unknown = append(unknown, batch)
+		}
+	}
+
+	return unknown, nil
+}
+
+func (t *restTransport) batchStatuses(batchIDs []string, wait uint) (map[string]*batchStatus, error) {
+	return t.client.getBatchStatuses(batchIDs, wait)
+}
+
+func (t *restTransport) getState(address, head string) ([]byte, error) {
+	suffix := fmt.Sprintf("state/%s", address)
+	if head != "" {
+		suffix = fmt.Sprintf("%s?head=%s", suffix, head)
+	}
+
+	var response struct {
+		Data string `json:"data"`
+	}
+	if err := t.client.getJSON(suffix, &response); err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(response.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Decoding error: %v", err)
+	}
+
+	return data, nil
+}
+
+func (t *restTransport) close() {}
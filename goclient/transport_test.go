@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+)
+
+// fakeRESTAPI records the batches submitted to it and answers the status requests from known
+type fakeRESTAPI struct {
+	mu        sync.Mutex
+	down      bool              // whether it can't reach its validator
+	known     map[string]string // status of the batches it knows
+	submitted []string
+}
+
+func (api *fakeRESTAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.down {
+		http.Error(w, "validator unreachable", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/batches":
+		list := &batch_pb2.BatchList{}
+		if err := proto.Unmarshal(body, list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, batch := range list.Batches {
+			api.submitted = append(api.submitted, batch.HeaderSignature)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"link": ""}`))
+	case "/batch_statuses":
+		var ids []string
+		json.Unmarshal(body, &ids)
+		var data []batchStatus
+		for _, id := range ids {
+			status, ok := api.known[id]
+			if !ok {
+				status = "UNKNOWN"
+			}
+			data = append(data, batchStatus{ID: id, Status: status})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSubmitBatchesFailsOver(t *testing.T) {
+	batches := []*batch_pb2.Batch{{HeaderSignature: "b1"}, {HeaderSignature: "b2"}}
+
+	cases := []struct {
+		name      string
+		first     *fakeRESTAPI
+		second    *fakeRESTAPI
+		submitted [2][]string // batches each REST API received
+		err       bool
+	}{
+		{"first up", &fakeRESTAPI{}, &fakeRESTAPI{}, [2][]string{{"b1", "b2"}, nil}, false},
+		{"first down", &fakeRESTAPI{down: true}, &fakeRESTAPI{}, [2][]string{nil, {"b1", "b2"}}, false},
+		{"some known", &fakeRESTAPI{down: true}, &fakeRESTAPI{known: map[string]string{"b1": "PENDING"}},
+			[2][]string{nil, {"b2"}}, false},
+		{"all known", &fakeRESTAPI{down: true},
+			&fakeRESTAPI{known: map[string]string{"b1": "COMMITTED", "b2": "PENDING"}}, [2][]string{nil, nil}, false},
+		{"all down", &fakeRESTAPI{down: true}, &fakeRESTAPI{down: true}, [2][]string{nil, nil}, true},
+	}
+	for _, c := range cases {
+		first, second := httptest.NewServer(c.first), httptest.NewServer(c.second)
+		client := newTestClient(first.URL, second.URL)
+		err := client.transport.submitBatches(batches)
+		first.Close()
+		second.Close()
+
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+		got := [2][]string{c.first.submitted, c.second.submitted}
+		if !reflect.DeepEqual(got, c.submitted) {
+			t.Errorf("%s: got submissions %v, want %v", c.name, got, c.submitted)
+		}
+		if healthy := client.endpoints.list[0].healthy; healthy == c.first.down {
+			t.Errorf("%s: got the first REST API healthy %v", c.name, healthy)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+// verifyFinding is a problem found in a committed batch or transaction
+type verifyFinding struct {
+	Block         uint64 `json:"block"`
+	BatchID       string `json:"batch_id"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	Severity      string `json:"severity"` // error or warning
+	Message       string `json:"message"`
+}
+
+// verifyReport is the summary of a verification
+type verifyReport struct {
+	Batches      int             `json:"batches"`
+	Transactions int             `json:"transactions"`
+	Errors       int             `json:"errors"`
+	Warnings     int             `json:"warnings"`
+	Findings     []verifyFinding `json:"findings"`
+}
+
+// verifier checks the batches and transactions of a block
+type verifier struct {
+	client  *CookiejarClient
+	context signing.Context
+	report  *verifyReport
+}
+
+func (v *verifier) add(block uint64, batchID, transactionID, severity, format string, args ...interface{}) {
+	v.report.Findings = append(v.report.Findings, verifyFinding{
+		Block:         block,
+		BatchID:       batchID,
+		TransactionID: transactionID,
+		Severity:      severity,
+		Message:       fmt.Sprintf(format, args...),
+	})
+	if severity == "error" {
+		v.report.Errors++
+	} else {
+		v.report.Warnings++
+	}
+}
+
+// verifySignature returns whether the hex encoded signature of the message verifies against the public key
+func (v *verifier) verifySignature(signature string, message []byte, publicKey string) error {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != 33 {
+		return fmt.Errorf("malformed public key %q", publicKey)
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != 64 {
+		return fmt.Errorf("malformed signature %q", signature)
+	}
+
+	if !v.context.Verify(sig, message, signing.NewSecp256k1PublicKey(key)) {
+		return fmt.Errorf("signature doesn't verify against %s", publicKey)
+	}
+
+	return nil
+}
+
+// coveredBy returns whether the address is covered by one of the prefixes
+func coveredBy(address string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(address, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyTransaction checks a single cookiejar transaction of a batch
+func (v *verifier) verifyTransaction(block uint64, batch *restBatch, t *restTransaction) {
+	add := func(severity, format string, args ...interface{}) {
+		v.add(block, batch.HeaderSignature, t.HeaderSignature, severity, format, args...)
+	}
+	header := t.Header
+
+	// The header signature, over the raw header unless the REST API expanded it: serializing the decoded header again
+	// only gives the signed bytes if the signer used a canonical protobuf encoding
+	rawHeader := t.RawHeader
+	var err error
+	if rawHeader == nil {
+		rawHeader, err = proto.Marshal(header.toProto())
+	}
+	if err != nil {
+		add("error", "unable to serialize header: %v", err)
+	} else if err := v.verifySignature(t.HeaderSignature, rawHeader, header.SignerPublicKey); err != nil {
+		add("error", "transaction %v", err)
+	}
+
+	// The payload hash
+	payload, err := base64.StdEncoding.DecodeString(t.Payload)
+	if err != nil {
+		add("error", "payload isn't valid base64: %v", err)
+		return
+	}
+	if digest := hexdigest(string(payload)); !strings.EqualFold(digest, header.PayloadSha512) {
+		add("error", "payload_sha512 %s doesn't match the payload's hash %s", header.PayloadSha512, digest)
+	}
+
+	// The payload itself
+	action, amount, err := parsePayload(string(payload))
+	if err != nil {
+		add("error", "malformed payload %q: %v", payload, err)
+	} else if action != "bake" && action != "eat" && action != "clear" {
+		add("error", "unknown action %q", action)
+	} else if amount < 0 {
+		add("warning", "negative amount in %q", payload)
+	}
+
+	// The jar the payload acts on must be declared
+	address := v.client.getJarAddress(header.SignerPublicKey)
+	if !coveredBy(address, header.Inputs) {
+		add("error", "inputs don't include the jar address %s", address)
+	}
+	if !coveredBy(address, header.Outputs) {
+		add("error", "outputs don't include the jar address %s", address)
+	}
+	for _, a := range append(append([]string{}, header.Inputs...), header.Outputs...) {
+		if !strings.HasPrefix(a, v.client.getPrefix()) {
+			add("warning", "declares address %s outside the cookiejar namespace", a)
+		}
+	}
+
+	if header.BatcherPublicKey != batch.Header.SignerPublicKey {
+		add("warning", "batcher public key %s differs from the batch signer %s", header.BatcherPublicKey,
+			batch.Header.SignerPublicKey)
+	}
+	if header.FamilyVersion != v.client.familyVersion {
+		add("warning", "unexpected family version %q, the profile uses %q", header.FamilyVersion, v.client.familyVersion)
+	}
+}
+
+// verifyBatch checks a batch holding cookiejar transactions and all of its cookiejar transactions
+func (v *verifier) verifyBatch(block uint64, batch *restBatch) {
+	rawHeader := batch.RawHeader
+	var err error
+	if rawHeader == nil {
+		rawHeader, err = proto.Marshal(&batch_pb2.BatchHeader{
+			SignerPublicKey: batch.Header.SignerPublicKey,
+			TransactionIds:  batch.Header.TransactionIDs,
+		})
+	}
+	if err != nil {
+		v.add(block, batch.HeaderSignature, "", "error", "unable to serialize batch header: %v", err)
+	} else if err := v.verifySignature(batch.HeaderSignature, rawHeader, batch.Header.SignerPublicKey); err != nil {
+		v.add(block, batch.HeaderSignature, "", "error", "batch %v", err)
+	}
+
+	// The batch header must list exactly the batch's transactions in order
+	ids := make([]string, 0, len(batch.Transactions))
+	for _, t := range batch.Transactions {
+		ids = append(ids, t.HeaderSignature)
+	}
+	if strings.Join(ids, ",") != strings.Join(batch.Header.TransactionIDs, ",") {
+		v.add(block, batch.HeaderSignature, "", "error", "transaction_ids don't match the batch's transactions")
+	}
+
+	for i := range batch.Transactions {
+		if batch.Transactions[i].Header.FamilyName == familyName {
+			v.report.Transactions++
+			v.verifyTransaction(block, batch, &batch.Transactions[i])
+		}
+	}
+}
+
+// verify checks every committed batch holding cookiejar transactions, starting at block sinceBlock
+func (c *CookiejarClient) verify(sinceBlock uint64) (*verifyReport, error) {
+	v := &verifier{
+		client:  c,
+		context: signing.NewSecp256k1Context(),
+		report:  &verifyReport{Findings: []verifyFinding{}},
+	}
+
+	if err := c.listBlocks(func(b *restBlock) bool {
+		if b.Header.BlockNum < sinceBlock {
+			return false
+		}
+
+		for i := range b.Batches {
+			batch := &b.Batches[i]
+			for _, t := range batch.Transactions {
+				if t.Header.FamilyName == familyName {
+					v.report.Batches++
+					v.verifyBatch(b.Header.BlockNum, batch)
+					break
+				}
+			}
+		}
+
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	return v.report, nil
+}
+
+// writeText writes the verification report in a human readable form
+func (r *verifyReport) writeText(w io.Writer) error {
+	if len(r.Findings) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "BLOCK\tBATCH\tTRANSACTION\tSEVERITY\tMESSAGE")
+		for _, f := range r.Findings {
+			fmt.Fprintf(tw, "%d\t%.16s\t%.16s\t%s\t%s\n", f.Block, f.BatchID, f.TransactionID, f.Severity, f.Message)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "Verified %d batches and %d cookiejar transactions: %d errors, %d warnings\n",
+		r.Batches, r.Transactions, r.Errors, r.Warnings)
+	return err
+}
+
+// cmdVerify executes the verify command
+func cmdVerify(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	sinceBlock := flags.Uint64("since-block", 0, "first block to verify")
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("Invalid format %q, use text or json", *format)
+	}
+
+	report, err := client.verify(*sinceBlock)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.writeText(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.Errors > 0 {
+		return fmt.Errorf("Found %d errors", report.Errors)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/signing"
+)
+
+func TestVerifyUsesTheRawHeaders(t *testing.T) {
+	context := signing.NewSecp256k1Context()
+	signer := signing.NewCryptoFactory(context).NewSigner(context.NewRandomPrivateKey())
+	publicKey := signer.GetPublicKey().AsHex()
+	client := newTestClient()
+	address := client.getJarAddress(publicKey)
+	payload := []byte("bake,3")
+
+	// A valid encoding which isn't the canonical one, with the signer before the family
+	first, _ := proto.Marshal(&transaction_pb2.TransactionHeader{SignerPublicKey: publicKey})
+	rest, _ := proto.Marshal(&transaction_pb2.TransactionHeader{
+		BatcherPublicKey: publicKey,
+		FamilyName:       familyName,
+		FamilyVersion:    defaultFamilyVersion,
+		Inputs:           []string{address},
+		Outputs:          []string{address},
+		PayloadSha512:    hexdigest(string(payload)),
+	})
+	transactionHeader := append(first, rest...)
+	transactionID := hex.EncodeToString(signer.Sign(transactionHeader))
+	batchHeader, _ := proto.Marshal(&batch_pb2.BatchHeader{SignerPublicKey: publicKey,
+		TransactionIds: []string{transactionID}})
+	batchID := hex.EncodeToString(signer.Sign(batchHeader))
+
+	cases := []struct {
+		name   string
+		header func(raw []byte, decoded proto.Message) interface{}
+		errors int
+	}{
+		{"raw headers", func(raw []byte, decoded proto.Message) interface{} {
+			return base64.StdEncoding.EncodeToString(raw)
+		}, 0},
+		// Serializing the expanded header again gives other bytes
+		{"expanded headers", func(raw []byte, decoded proto.Message) interface{} {
+			if header, ok := decoded.(*transaction_pb2.TransactionHeader); ok {
+				return map[string]interface{}{"signer_public_key": header.SignerPublicKey,
+					"batcher_public_key": header.BatcherPublicKey, "family_name": header.FamilyName,
+					"family_version": header.FamilyVersion, "inputs": header.Inputs, "outputs": header.Outputs,
+					"payload_sha512": header.PayloadSha512}
+			}
+			return map[string]interface{}{"signer_public_key": publicKey, "transaction_ids": []string{transactionID}}
+		}, 1},
+	}
+	for _, c := range cases {
+		decoded := &transaction_pb2.TransactionHeader{}
+		proto.Unmarshal(transactionHeader, decoded)
+		block := map[string]interface{}{
+			"header":           map[string]interface{}{"block_num": "1"},
+			"header_signature": "block1",
+			"batches": []interface{}{map[string]interface{}{
+				"header":           c.header(batchHeader, &batch_pb2.BatchHeader{}),
+				"header_signature": batchID,
+				"transactions": []interface{}{map[string]interface{}{
+					"header":           c.header(transactionHeader, decoded),
+					"header_signature": transactionID,
+					"payload":          base64.StdEncoding.EncodeToString(payload),
+				}},
+			}},
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(t, w, map[string]interface{}{"data": []interface{}{block}})
+		}))
+
+		client := newTestClient(server.URL)
+		report, err := client.verify(0)
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if report.Batches != 1 || report.Transactions != 1 || report.Errors != c.errors || report.Warnings != 0 {
+			t.Errorf("%s: got %+v, want %d errors", c.name, report, c.errors)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	watchMinBackoff = time.Second
+	watchMaxBackoff = 30 * time.Second
+)
+
+// restStateChange is a single state change sent over the subscriptions websocket
+type restStateChange struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	Value   string `json:"value"` // base64 encoded
+}
+
+// restStateDelta is a message sent over the subscriptions websocket for every committed block
+type restStateDelta struct {
+	BlockID         string            `json:"block_id"`
+	BlockNum        json.RawMessage   `json:"block_num"`
+	PreviousBlockID string            `json:"previous_block_id"`
+	StateChanges    []restStateChange `json:"state_changes"`
+	Error           *struct {
+		Code    int    `json:"code"`
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// blockNum returns the block number of the delta, which can be sent either as a number or a string
+func (d *restStateDelta) blockNum() uint64 {
+	num, _ := strconv.ParseUint(strings.Trim(string(d.BlockNum), `"`), 10, 64)
+	return num
+}
+
+// balanceUpdate is the new balance of a jar after a block was committed
+type balanceUpdate struct {
+	Block   uint64 `json:"block"`
+	BlockID string `json:"block_id"`
+	Address string `json:"address"`
+	Owner   string `json:"owner,omitempty"`
+	Balance int    `json:"balance"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// websocketURL returns the URL of the REST API's subscriptions websocket
+func (c *CookiejarClient) websocketURL() string {
+	return "ws" + strings.TrimPrefix(c.baseURL(), "http") + "/subscriptions"
+}
+
+// subscribe opens the subscriptions websocket and subscribes to all changes under the address prefix,
+// resuming after lastBlockID when it's set
+func (c *CookiejarClient) subscribe(prefix, lastBlockID string) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	if t, ok := c.http.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = t.TLSClientConfig
+	}
+
+	header := http.Header{}
+	if auth := c.authHeader(); auth != "" {
+		header.Set("Authorization", auth)
+	}
+
+	conn, _, err := dialer.Dial(c.websocketURL(), header)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to REST API: %v", err)
+	}
+
+	request := map[string]interface{}{
+		"action":           "subscribe",
+		"address_prefixes": []string{prefix},
+	}
+	if lastBlockID != "" {
+		request["last_known_block_id"] = lastBlockID
+	}
+	if err := conn.WriteJSON(request); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to subscribe: %v", err)
+	}
+
+	return conn, nil
+}
+
+// watch streams the balance updates of all jars under the address prefix to fn, until stop is closed.
+// Lost connections are reestablished and resubscribed from the last received block.
+func (c *CookiejarClient) watch(prefix string, stop <-chan struct{}, fn func(balanceUpdate) error) error {
+	owners := c.localJars()
+	lastBlockID := ""
+	backoff := watchMinBackoff
+
+	for {
+		conn, err := c.subscribe(prefix, lastBlockID)
+		if err == nil {
+			// Unsubscribe and close the connection once we're asked to stop
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+					conn.WriteJSON(map[string]string{"action": "unsubscribe"})
+					conn.Close()
+				case <-done:
+				}
+			}()
+
+			for {
+				var delta restStateDelta
+				if err = conn.ReadJSON(&delta); err != nil {
+					break
+				}
+				if delta.Error != nil {
+					err = fmt.Errorf("Subscription error %d: %s", delta.Error.Code, delta.Error.Message)
+					break
+				}
+				backoff = watchMinBackoff
+
+				for _, change := range delta.StateChanges {
+					update := balanceUpdate{
+						Block:   delta.blockNum(),
+						BlockID: delta.BlockID,
+						Address: change.Address,
+						Owner:   owners[change.Address],
+						Deleted: change.Type == "DELETE",
+					}
+					if !update.Deleted {
+						if value, err := base64.StdEncoding.DecodeString(change.Value); err == nil {
+							update.Balance, _ = strconv.Atoi(string(value))
+						}
+					}
+
+					if err := fn(update); err != nil {
+						close(done)
+						conn.Close()
+						return err
+					}
+				}
+				if delta.BlockID != "" {
+					lastBlockID = delta.BlockID
+				}
+			}
+
+			close(done)
+			conn.Close()
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		logger.Warnf("Subscription lost (%v), reconnecting in %v", err, backoff)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > watchMaxBackoff {
+			backoff = watchMaxBackoff
+		}
+	}
+}
+
+// cmdWatch executes the watch command
+func cmdWatch(client *CookiejarClient, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	jar := flags.String("jar", "", "only watch this jar, either a key name or a public key (default: all jars)")
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("Invalid format %q, use text or json", *format)
+	}
+
+	prefix := client.getPrefix()
+	if *jar != "" {
+		publicKey, err := getPublicKey(*jar)
+		if err != nil {
+			return err
+		}
+		prefix = client.getJarAddress(publicKey)
+	}
+
+	// Stop watching on interrupt. The goroutine also returns with watch, so none is left behind in the shell.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	defer close(done)
+	go func() {
+		select {
+		case <-signals:
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	return client.watch(prefix, stop, func(u balanceUpdate) error {
+		if *format == "json" {
+			return enc.Encode(u)
+		}
+
+		jar := u.Owner
+		if jar == "" {
+			jar = u.Address
+		}
+		if u.Deleted {
+			fmt.Printf("block %d: %s deleted\n", u.Block, jar)
+		} else {
+			fmt.Printf("block %d: %s now holds %d cookies\n", u.Block, jar, u.Balance)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWatchResubscribesFromTheLastBlock(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	// The block number is a string in the first delta and a number in the second one
+	deltas := []map[string]interface{}{
+		{"block_id": "block1", "block_num": "1", "state_changes": []restStateChange{
+			{Type: "SET", Address: "a4d21901", Value: encode("5")},
+			{Type: "DELETE", Address: "a4d21902"},
+		}},
+		{"block_id": "block2", "block_num": 2, "state_changes": []restStateChange{
+			{Type: "SET", Address: "a4d21901", Value: encode("3")},
+		}},
+	}
+
+	subscriptions := make(chan map[string]interface{}, 2)
+	unsubscribed := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subscriptions" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		var request map[string]interface{}
+		if err := conn.ReadJSON(&request); err != nil {
+			t.Error(err)
+			return
+		}
+		subscriptions <- request
+		_, resumed := request["last_known_block_id"]
+		delta := deltas[0]
+		if resumed {
+			delta = deltas[1]
+		}
+		if err := conn.WriteJSON(delta); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// The first connection drops after a block, the resumed one waits for the unsubscription
+		if !resumed {
+			return
+		}
+		if err := conn.ReadJSON(&request); err == nil && request["action"] == "unsubscribe" {
+			close(unsubscribed)
+		}
+	}))
+	defer server.Close()
+	c := newTestClient(server.URL)
+
+	stop := make(chan struct{})
+	var updates []balanceUpdate
+	done := make(chan error)
+	go func() {
+		done <- c.watch("a4d219", stop, func(u balanceUpdate) error {
+			updates = append(updates, u)
+			if len(updates) == 3 {
+				close(stop)
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch didn't stop")
+	}
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Error("not unsubscribed")
+	}
+
+	first, second := <-subscriptions, <-subscriptions
+	if _, ok := first["last_known_block_id"]; ok || !reflect.DeepEqual(first["address_prefixes"],
+		[]interface{}{"a4d219"}) {
+		t.Errorf("got first subscription %v, want the prefix from the head", first)
+	}
+	if second["last_known_block_id"] != "block1" {
+		t.Errorf("got second subscription %v, want it to resume after block1", second)
+	}
+
+	want := []balanceUpdate{
+		{Block: 1, BlockID: "block1", Address: "a4d21901", Balance: 5},
+		{Block: 1, BlockID: "block1", Address: "a4d21902", Deleted: true},
+		{Block: 2, BlockID: "block2", Address: "a4d21901", Balance: 3},
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("got %+v, want %+v", updates, want)
+	}
+}
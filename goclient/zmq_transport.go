@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/messaging"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/batch_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/block_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_batch_submit_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_block_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_list_control_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_state_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/network_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// errTimeout is returned when the validator doesn't answer a request in time
+var errTimeout = errors.New("Timed out waiting for the validator")
+
+// validatorConnection is the connection to the validator's component endpoint
+type validatorConnection interface {
+	SendNewMsg(t validator_pb2.Message_MessageType, c []byte) (string, error)
+	SendMsg(t validator_pb2.Message_MessageType, c []byte, corrId string) error
+	RecvMsg() (string, *validator_pb2.Message, error)
+	Close()
+	// poll waits up to timeout for a message and returns whether one can be received
+	poll(timeout time.Duration) (bool, error)
+}
+
+// zmqConnection is a connection to the validator over a ZMQ DEALER socket
+type zmqConnection struct {
+	*messaging.ZmqConnection
+	poller *zmq.Poller
+}
+
+func (c *zmqConnection) poll(timeout time.Duration) (bool, error) {
+	polled, err := c.poller.Poll(timeout)
+	return len(polled) > 0, err
+}
+
+// zmqTransport talks directly to the validator's component endpoint, bypassing the REST API
+type zmqTransport struct {
+	mu         sync.Mutex // ZMQ sockets must not be used by several goroutines at once
+	context    *zmq.Context
+	connection validatorConnection
+	timeout    time.Duration // 0 waits forever
+}
+
+// newZmqTransport connects to the validator at the provided url, e.g. tcp://validator:4004. Requests time out after
+// timeout, unless it's 0.
+func newZmqTransport(url string, timeout time.Duration) (*zmqTransport, error) {
+	zmqContext, err := zmq.NewContext()
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := messaging.NewConnection(zmqContext, zmq.DEALER, url, false)
+	if err != nil {
+		zmqContext.Term()
+		return nil, fmt.Errorf("Failed to connect to validator: %v", err)
+	}
+	poller := zmq.NewPoller()
+	poller.Add(connection.Socket(), zmq.POLLIN)
+
+	return &zmqTransport{
+		context:    zmqContext,
+		connection: &zmqConnection{ZmqConnection: connection, poller: poller},
+		timeout:    timeout,
+	}, nil
+}
+
+// request sends a request to the validator and decodes the response of the expected type into response
+func (t *zmqTransport) request(requestType validator_pb2.Message_MessageType, request proto.Message,
+	responseType validator_pb2.Message_MessageType, response proto.Message) error {
+	return t.requestWaiting(0, requestType, request, responseType, response)
+}
+
+// requestWaiting is request for requests which the validator may hold for up to wait seconds, on top of the
+// timeout. The pings of the validator are answered meanwhile, and responses to earlier requests which timed out
+// are dropped.
+func (t *zmqTransport) requestWaiting(wait uint, requestType validator_pb2.Message_MessageType, request proto.Message,
+	responseType validator_pb2.Message_MessageType, response proto.Message) error {
+	serializedRequest, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	corrId, err := t.connection.SendNewMsg(requestType, serializedRequest)
+	if err != nil {
+		return fmt.Errorf("Failed to send request to validator: %v", err)
+	}
+
+	// Without a timeout, poll in rounds of a second
+	var deadline time.Time
+	if t.timeout > 0 {
+		deadline = time.Now().Add(t.timeout + time.Duration(wait)*time.Second)
+	}
+	for {
+		remaining := time.Second
+		if !deadline.IsZero() {
+			if remaining = time.Until(deadline); remaining <= 0 {
+				return errTimeout
+			}
+		}
+
+		ready, err := t.connection.poll(remaining)
+		if err != nil {
+			return fmt.Errorf("Failed to receive response from validator: %v", err)
+		}
+		if !ready {
+			continue
+		}
+
+		_, message, err := t.connection.RecvMsg()
+		if err != nil {
+			return fmt.Errorf("Failed to receive response from validator: %v", err)
+		}
+		if message.MessageType == validator_pb2.Message_PING_REQUEST {
+			if err := t.answerPing(message); err != nil {
+				return err
+			}
+			continue
+		}
+		if message.CorrelationId != corrId {
+			logger.Debugf("Dropping %v message %s while waiting for %s", message.MessageType, message.CorrelationId,
+				corrId)
+			continue
+		}
+		if message.MessageType != responseType {
+			return fmt.Errorf("Unexpected response of type %v", message.MessageType)
+		}
+
+		return proto.Unmarshal(message.Content, response)
+	}
+}
+
+// answerPing answers the ping request of the validator, which drops connections not answering them
+func (t *zmqTransport) answerPing(message *validator_pb2.Message) error {
+	data, err := proto.Marshal(&network_pb2.PingResponse{})
+	if err != nil {
+		return err
+	}
+
+	return t.connection.SendMsg(validator_pb2.Message_PING_RESPONSE, data, message.CorrelationId)
+}
+
+func (t *zmqTransport) submitBatches(batches []*batch_pb2.Batch) error {
+	response := client_batch_submit_pb2.ClientBatchSubmitResponse{}
+	if err := t.request(
+		validator_pb2.Message_CLIENT_BATCH_SUBMIT_REQUEST,
+		&client_batch_submit_pb2.ClientBatchSubmitRequest{Batches: batches},
+		validator_pb2.Message_CLIENT_BATCH_SUBMIT_RESPONSE,
+		&response,
+	); err != nil {
+		return err
+	}
+
+	if response.Status != client_batch_submit_pb2.ClientBatchSubmitResponse_OK {
+		return fmt.Errorf("Batch submission failed: %v", response.Status)
+	}
+
+	return nil
+}
+
+func (t *zmqTransport) batchStatuses(batchIDs []string, wait uint) (map[string]*batchStatus, error) {
+	response := client_batch_submit_pb2.ClientBatchStatusResponse{}
+	if err := t.requestWaiting(
+		wait,
+		validator_pb2.Message_CLIENT_BATCH_STATUS_REQUEST,
+		&client_batch_submit_pb2.ClientBatchStatusRequest{
+			BatchIds: batchIDs,
+			Wait:     wait > 0,
+			Timeout:  uint32(wait),
+		},
+		validator_pb2.Message_CLIENT_BATCH_STATUS_RESPONSE,
+		&response,
+	); err != nil {
+		return nil, err
+	}
+
+	if response.Status != client_batch_submit_pb2.ClientBatchStatusResponse_OK {
+		return nil, fmt.Errorf("Batch status request failed: %v", response.Status)
+	}
+
+	statuses := map[string]*batchStatus{}
+	for _, s := range response.BatchStatuses {
+		status := &batchStatus{ID: s.BatchId, Status: s.Status.String()}
+		for _, invalid := range s.InvalidTransactions {
+			status.InvalidTransactions = append(status.InvalidTransactions, invalidTransaction{
+				ID:      invalid.TransactionId,
+				Message: invalid.Message,
+			})
+		}
+		statuses[s.BatchId] = status
+	}
+
+	return statuses, nil
+}
+
+// getBlock returns the block with the provided id, or the chain head if id is empty
+func (t *zmqTransport) getBlock(id string) (*block_pb2.Block, error) {
+	if id != "" {
+		response := client_block_pb2.ClientBlockGetResponse{}
+		if err := t.request(
+			validator_pb2.Message_CLIENT_BLOCK_GET_BY_ID_REQUEST,
+			&client_block_pb2.ClientBlockGetByIdRequest{BlockId: id},
+			validator_pb2.Message_CLIENT_BLOCK_GET_RESPONSE,
+			&response,
+		); err != nil {
+			return nil, err
+		}
+
+		if response.Status == client_block_pb2.ClientBlockGetResponse_NO_RESOURCE {
+			return nil, errNotFound
+		} else if response.Status != client_block_pb2.ClientBlockGetResponse_OK {
+			return nil, fmt.Errorf("Block request failed: %v", response.Status)
+		}
+
+		return response.Block, nil
+	}
+
+	// The first block of a list is the chain head
+	response := client_block_pb2.ClientBlockListResponse{}
+	if err := t.request(
+		validator_pb2.Message_CLIENT_BLOCK_LIST_REQUEST,
+		&client_block_pb2.ClientBlockListRequest{
+			Paging: &client_list_control_pb2.ClientPagingControls{Limit: 1},
+		},
+		validator_pb2.Message_CLIENT_BLOCK_LIST_RESPONSE,
+		&response,
+	); err != nil {
+		return nil, err
+	}
+
+	if response.Status != client_block_pb2.ClientBlockListResponse_OK || len(response.Blocks) == 0 {
+		return nil, fmt.Errorf("Block list request failed: %v", response.Status)
+	}
+
+	return response.Blocks[0], nil
+}
+
+func (t *zmqTransport) getState(address, head string) ([]byte, error) {
+	// State is read by state root, so get it from the requested block
+	block, err := t.getBlock(head)
+	if err != nil {
+		return nil, err
+	}
+
+	header := block_pb2.BlockHeader{}
+	if err := proto.Unmarshal(block.Header, &header); err != nil {
+		return nil, fmt.Errorf("Failed to decode block header: %v", err)
+	}
+
+	response := client_state_pb2.ClientStateGetResponse{}
+	if err := t.request(
+		validator_pb2.Message_CLIENT_STATE_GET_REQUEST,
+		&client_state_pb2.ClientStateGetRequest{
+			StateRoot: header.StateRootHash,
+			Address:   address,
+		},
+		validator_pb2.Message_CLIENT_STATE_GET_RESPONSE,
+		&response,
+	); err != nil {
+		return nil, err
+	}
+
+	switch response.Status {
+	case client_state_pb2.ClientStateGetResponse_OK:
+		return response.Value, nil
+	case client_state_pb2.ClientStateGetResponse_NO_RESOURCE:
+		return nil, errNotFound
+	default:
+		return nil, fmt.Errorf("State request failed: %v", response.Status)
+	}
+}
+
+func (t *zmqTransport) close() {
+	t.connection.Close()
+	t.context.Term()
+}
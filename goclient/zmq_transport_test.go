@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/client_batch_submit_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+)
+
+// fakeValidator is a connection to a validator which sends the queued messages
+type fakeValidator struct {
+	incoming []*validator_pb2.Message
+	sent     []*validator_pb2.Message
+	corrId   int
+}
+
+func (v *fakeValidator) SendNewMsg(t validator_pb2.Message_MessageType, c []byte) (string, error) {
+	v.corrId++
+	corrId := strconv.Itoa(v.corrId)
+
+	return corrId, v.SendMsg(t, c, corrId)
+}
+
+func (v *fakeValidator) SendMsg(t validator_pb2.Message_MessageType, c []byte, corrId string) error {
+	v.sent = append(v.sent, &validator_pb2.Message{MessageType: t, Content: c, CorrelationId: corrId})
+	return nil
+}
+
+func (v *fakeValidator) RecvMsg() (string, *validator_pb2.Message, error) {
+	message := v.incoming[0]
+	v.incoming = v.incoming[1:]
+
+	return "", message, nil
+}
+
+func (v *fakeValidator) Close() {}
+
+func (v *fakeValidator) poll(timeout time.Duration) (bool, error) {
+	if len(v.incoming) == 0 {
+		time.Sleep(timeout)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// statusResponse returns a batch status response to the request with the correlation id
+func statusResponse(t *testing.T, corrId string, batchID string) *validator_pb2.Message {
+	data, err := proto.Marshal(&client_batch_submit_pb2.ClientBatchStatusResponse{
+		Status: client_batch_submit_pb2.ClientBatchStatusResponse_OK,
+		BatchStatuses: []*client_batch_submit_pb2.ClientBatchStatus{
+			{BatchId: batchID, Status: client_batch_submit_pb2.ClientBatchStatus_COMMITTED},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &validator_pb2.Message{MessageType: validator_pb2.Message_CLIENT_BATCH_STATUS_RESPONSE,
+		CorrelationId: corrId, Content: data}
+}
+
+func TestZmqRequestAnswersPings(t *testing.T) {
+	v := &fakeValidator{}
+	transport := &zmqTransport{connection: v, timeout: time.Second}
+
+	// A late response to an earlier request and a ping arrive before the response
+	v.incoming = []*validator_pb2.Message{
+		statusResponse(t, "0", "old"),
+		{MessageType: validator_pb2.Message_PING_REQUEST, CorrelationId: "ping"},
+		statusResponse(t, "1", "b1"),
+	}
+	statuses, err := transport.batchStatuses([]string{"b1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses["b1"].Status != "COMMITTED" {
+		t.Fatalf("got %v, want b1 committed", statuses)
+	}
+
+	if len(v.sent) != 2 || v.sent[1].MessageType != validator_pb2.Message_PING_RESPONSE ||
+		v.sent[1].CorrelationId != "ping" {
+		t.Fatalf("got %v, want the request and the response to the ping", v.sent)
+	}
+}
+
+func TestZmqRequestTimesOut(t *testing.T) {
+	v := &fakeValidator{}
+	transport := &zmqTransport{connection: v, timeout: 20 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := transport.batchStatuses([]string{"b1"}, 0); err != errTimeout {
+		t.Fatalf("got %v, want errTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timed out after %v", elapsed)
+	}
+
+	// The transport is still usable and drops the response to the request which timed out
+	v.incoming = []*validator_pb2.Message{statusResponse(t, "1", "b1"), statusResponse(t, "2", "b2")}
+	statuses, err := transport.batchStatuses([]string{"b2"}, 0)
+	if err != nil || statuses["b2"] == nil || statuses["b1"] != nil {
+		t.Fatalf("got %v, %v, want the status of b2", statuses, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cookiejar"
 	"os"
 	"strconv"
 	"strings"
@@ -12,7 +13,6 @@ import (
 
 //const defaultURL = "http://localhost:4004"
 const defaultURL = "tcp://validator:4004"
-const familyName = "cookiejar"
 const version = "1.0"
 
 func main() {
@@ -68,7 +68,7 @@ func main() {
 		processor.SetThreadCount(threads)
 	}
 
-	processor.AddHandler(NewCookiejarHandler()) // Add the handler
+	processor.AddHandler(cookiejar.NewCookiejarHandler()) // Add the handler
 	processor.ShutdownOnSignal(syscall.SIGINT, syscall.SIGTERM)
 
 	if err := processor.Start(); err != nil {
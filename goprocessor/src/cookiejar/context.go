@@ -0,0 +1,181 @@
+package cookiejar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/state_context_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+)
+
+// stateReader reads a single state entry
+type stateReader interface {
+	get(address string) ([]byte, bool)
+}
+
+// stateMap is a state without pending changes
+type stateMap map[string][]byte
+
+func (s stateMap) get(address string) ([]byte, bool) {
+	value, ok := s[address]
+	return value, ok
+}
+
+// overlayState holds changes on top of another state. A nil value marks a deleted entry.
+type overlayState struct {
+	base    stateReader
+	changes map[string][]byte
+}
+
+func (s *overlayState) get(address string) ([]byte, bool) {
+	if value, ok := s.changes[address]; ok {
+		return value, value != nil
+	}
+
+	return s.base.get(address)
+}
+
+// authorized returns whether the address is covered by one of the prefixes of a transaction's inputs or outputs
+func authorized(address string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(address, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidatorContext is the state a validator exposes to a transaction processor while it applies a single
+// transaction. It answers the processor's state, event and receipt requests without changing the underlying state.
+type ValidatorContext struct {
+	header  *transaction_pb2.TransactionHeader
+	state   *overlayState
+	receipt *Receipt
+}
+
+// NewValidatorContext returns the context of a transaction applied to the provided state
+func NewValidatorContext(header *transaction_pb2.TransactionHeader, transactionID string, state map[string][]byte) *ValidatorContext {
+	return newValidatorContext(header, transactionID, stateMap(state))
+}
+
+func newValidatorContext(header *transaction_pb2.TransactionHeader, transactionID string, base stateReader) *ValidatorContext {
+	return &ValidatorContext{
+		header:  header,
+		state:   &overlayState{base: base, changes: map[string][]byte{}},
+		receipt: &Receipt{TransactionID: transactionID},
+	}
+}
+
+// Receipt returns the receipt of the transaction, with the state changes in a deterministic order
+func (c *ValidatorContext) Receipt() *Receipt {
+	addresses := make([]string, 0, len(c.state.changes))
+	for address := range c.state.changes {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	receipt := *c.receipt
+	receipt.StateChanges = nil
+	for _, address := range addresses {
+		value := c.state.changes[address]
+		change := &transaction_receipt_pb2.StateChange{Address: address, Value: value, Type: transaction_receipt_pb2.StateChange_SET}
+		if value == nil {
+			change.Type = transaction_receipt_pb2.StateChange_DELETE
+		}
+		receipt.StateChanges = append(receipt.StateChanges, change)
+	}
+
+	return &receipt
+}
+
+// Handle answers a request of the processor and returns the type and content of the response
+func (c *ValidatorContext) Handle(t validator_pb2.Message_MessageType, content []byte) (validator_pb2.Message_MessageType, proto.Message, error) {
+	switch t {
+	case validator_pb2.Message_TP_STATE_GET_REQUEST:
+		request := &state_context_pb2.TpStateGetRequest{}
+		if err := proto.Unmarshal(content, request); err != nil {
+			return 0, nil, err
+		}
+
+		response := &state_context_pb2.TpStateGetResponse{Status: state_context_pb2.TpStateGetResponse_OK}
+		for _, address := range request.Addresses {
+			if !authorized(address, c.header.Inputs) {
+				response = &state_context_pb2.TpStateGetResponse{Status: state_context_pb2.TpStateGetResponse_AUTHORIZATION_ERROR}
+				break
+			}
+			// Like the validator, only the addresses holding data are returned
+			if value, ok := c.state.get(address); ok {
+				response.Entries = append(response.Entries, &state_context_pb2.TpStateEntry{Address: address, Data: value})
+			}
+		}
+
+		return validator_pb2.Message_TP_STATE_GET_RESPONSE, response, nil
+	case validator_pb2.Message_TP_STATE_SET_REQUEST:
+		request := &state_context_pb2.TpStateSetRequest{}
+		if err := proto.Unmarshal(content, request); err != nil {
+			return 0, nil, err
+		}
+
+		for _, entry := range request.Entries {
+			if !authorized(entry.Address, c.header.Outputs) {
+				return validator_pb2.Message_TP_STATE_SET_RESPONSE,
+					&state_context_pb2.TpStateSetResponse{Status: state_context_pb2.TpStateSetResponse_AUTHORIZATION_ERROR}, nil
+			}
+		}
+
+		response := &state_context_pb2.TpStateSetResponse{Status: state_context_pb2.TpStateSetResponse_OK}
+		for _, entry := range request.Entries {
+			c.state.changes[entry.Address] = append([]byte{}, entry.Data...)
+			response.Addresses = append(response.Addresses, entry.Address)
+		}
+
+		return validator_pb2.Message_TP_STATE_SET_RESPONSE, response, nil
+	case validator_pb2.Message_TP_STATE_DELETE_REQUEST:
+		request := &state_context_pb2.TpStateDeleteRequest{}
+		if err := proto.Unmarshal(content, request); err != nil {
+			return 0, nil, err
+		}
+
+		for _, address := range request.Addresses {
+			if !authorized(address, c.header.Outputs) {
+				return validator_pb2.Message_TP_STATE_DELETE_RESPONSE,
+					&state_context_pb2.TpStateDeleteResponse{Status: state_context_pb2.TpStateDeleteResponse_AUTHORIZATION_ERROR}, nil
+			}
+		}
+
+		response := &state_context_pb2.TpStateDeleteResponse{Status: state_context_pb2.TpStateDeleteResponse_OK}
+		for _, address := range request.Addresses {
+			if _, ok := c.state.get(address); ok {
+				c.state.changes[address] = nil
+				response.Addresses = append(response.Addresses, address)
+			}
+		}
+
+		return validator_pb2.Message_TP_STATE_DELETE_RESPONSE, response, nil
+	case validator_pb2.Message_TP_EVENT_ADD_REQUEST:
+		request := &state_context_pb2.TpEventAddRequest{}
+		if err := proto.Unmarshal(content, request); err != nil {
+			return 0, nil, err
+		}
+		c.receipt.Events = append(c.receipt.Events, request.Event)
+
+		return validator_pb2.Message_TP_EVENT_ADD_RESPONSE,
+			&state_context_pb2.TpEventAddResponse{Status: state_context_pb2.TpEventAddResponse_OK}, nil
+	case validator_pb2.Message_TP_RECEIPT_ADD_DATA_REQUEST:
+		request := &state_context_pb2.TpReceiptAddDataRequest{}
+		if err := proto.Unmarshal(content, request); err != nil {
+			return 0, nil, err
+		}
+		c.receipt.Data = append(c.receipt.Data, request.Data)
+
+		return validator_pb2.Message_TP_RECEIPT_ADD_DATA_RESPONSE,
+			&state_context_pb2.TpReceiptAddDataResponse{Status: state_context_pb2.TpReceiptAddDataResponse_OK}, nil
+	}
+
+	return 0, nil, fmt.Errorf("Unexpected message type %v", t)
+}
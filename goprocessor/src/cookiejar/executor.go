@@ -0,0 +1,216 @@
+package cookiejar
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/messaging"
+	"github.com/hyperledger/sawtooth-sdk-go/processor"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/events_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/processor_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	transaction_receipt_pb2 "github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_receipt_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// Receipt is the outcome of a transaction executed by an Executor, as a validator would store it
+type Receipt struct {
+	TransactionID string
+	StateChanges  []*transaction_receipt_pb2.StateChange
+	Events        []*events_pb2.Event
+	Data          [][]byte
+}
+
+// Executor runs transactions through a handler against an in-memory state, answering the handler's state, event
+// and receipt requests the way a validator does. Reads and writes are checked against the transaction's inputs
+// and outputs.
+type Executor struct {
+	Handler processor.TransactionHandler
+	State   map[string][]byte
+}
+
+// NewExecutor returns an executor for the handler, starting from the provided state
+func NewExecutor(handler processor.TransactionHandler, state map[string][]byte) *Executor {
+	if state == nil {
+		state = map[string][]byte{}
+	}
+
+	return &Executor{
+		Handler: handler,
+		State:   state,
+	}
+}
+
+// Execute applies a single transaction. The state is only updated when the handler accepts the transaction, else
+// the handler's error is returned, which is either a *processor.InvalidTransactionError or a *processor.InternalError.
+func (e *Executor) Execute(transaction *transaction_pb2.Transaction) (*Receipt, error) {
+	receipts, err := e.ExecuteBatch([]*transaction_pb2.Transaction{transaction})
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts[0], nil
+}
+
+// ExecuteBatch applies the transactions of a batch in order. Like a batch on the network, either all transactions
+// are applied or, when one of them fails, none of them. On failure the receipts of the transactions preceding the
+// failed one are returned along with its error.
+func (e *Executor) ExecuteBatch(transactions []*transaction_pb2.Transaction) ([]*Receipt, error) {
+	pending := &overlayState{base: stateMap(e.State), changes: map[string][]byte{}}
+
+	receipts := make([]*Receipt, 0, len(transactions))
+	for _, t := range transactions {
+		receipt, err := e.apply(pending, t)
+		if err != nil {
+			return receipts, err
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	for address, value := range pending.changes {
+		if value == nil {
+			delete(e.State, address)
+		} else {
+			e.State[address] = value
+		}
+	}
+
+	return receipts, nil
+}
+
+// handles returns whether the handler processes the family and version of the transaction
+func (e *Executor) handles(header *transaction_pb2.TransactionHeader) bool {
+	if header.FamilyName != e.Handler.FamilyName() {
+		return false
+	}
+	for _, version := range e.Handler.FamilyVersions() {
+		if version == header.FamilyVersion {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply runs a single transaction on top of the pending changes of its batch
+func (e *Executor) apply(pending *overlayState, t *transaction_pb2.Transaction) (*Receipt, error) {
+	header := &transaction_pb2.TransactionHeader{}
+	if err := proto.Unmarshal(t.Header, header); err != nil {
+		return nil, &processor.InvalidTransactionError{Msg: fmt.Sprintf("Couldn't decode transaction header: %v", err)}
+	}
+	if !e.handles(header) {
+		return nil, &processor.InvalidTransactionError{
+			Msg: fmt.Sprintf("No handler for family %q version %q", header.FamilyName, header.FamilyVersion),
+		}
+	}
+
+	connection := &executorConnection{
+		context:  newValidatorContext(header, t.HeaderSignature, pending),
+		messages: map[string]*validator_pb2.Message{},
+	}
+	request := &processor_pb2.TpProcessRequest{
+		Header:    header,
+		Payload:   t.Payload,
+		Signature: t.HeaderSignature,
+		ContextId: t.HeaderSignature,
+	}
+
+	if err := e.Handler.Apply(request, processor.NewContext(connection, request.ContextId)); err != nil {
+		return nil, err
+	}
+
+	receipt := connection.context.Receipt()
+	for _, change := range receipt.StateChanges {
+		if change.Type == transaction_receipt_pb2.StateChange_DELETE {
+			pending.changes[change.Address] = nil
+		} else {
+			pending.changes[change.Address] = change.Value
+		}
+	}
+
+	return receipt, nil
+}
+
+// executorConnection passes the messages a handler's context sends to the validator to a ValidatorContext
+type executorConnection struct {
+	context  *ValidatorContext
+	mu       sync.Mutex
+	messages map[string]*validator_pb2.Message
+	nextID   int
+}
+
+func (c *executorConnection) SendNewMsg(t validator_pb2.Message_MessageType, content []byte) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	corrID := strconv.Itoa(c.nextID)
+
+	responseType, response, err := c.context.Handle(t, content)
+	if err != nil {
+		return "", err
+	}
+	data, err := proto.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+	c.messages[corrID] = &validator_pb2.Message{MessageType: responseType, CorrelationId: corrID, Content: data}
+
+	return corrID, nil
+}
+
+func (c *executorConnection) RecvMsgWithId(corrID string) (string, *validator_pb2.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg, ok := c.messages[corrID]
+	if !ok {
+		return "", nil, fmt.Errorf("No response for message %s", corrID)
+	}
+	delete(c.messages, corrID)
+
+	return "", msg, nil
+}
+
+func (c *executorConnection) SendNewMsgTo(id string, t validator_pb2.Message_MessageType, content []byte) (string, error) {
+	return c.SendNewMsg(t, content)
+}
+
+func (c *executorConnection) SendData(id string, data []byte) error {
+	return fmt.Errorf("Not supported by the executor")
+}
+
+func (c *executorConnection) SendMsg(t validator_pb2.Message_MessageType, content []byte, corrID string) error {
+	return fmt.Errorf("Not supported by the executor")
+}
+
+func (c *executorConnection) SendMsgTo(id string, t validator_pb2.Message_MessageType, content []byte, corrID string) error {
+	return fmt.Errorf("Not supported by the executor")
+}
+
+func (c *executorConnection) RecvData() (string, []byte, error) {
+	return "", nil, fmt.Errorf("Not supported by the executor")
+}
+
+func (c *executorConnection) RecvMsg() (string, *validator_pb2.Message, error) {
+	return "", nil, fmt.Errorf("Not supported by the executor")
+}
+
+func (c *executorConnection) Close() {}
+
+func (c *executorConnection) Socket() *zmq.Socket {
+	return nil
+}
+
+func (c *executorConnection) Monitor(zmq.Event) (*zmq.Socket, error) {
+	return nil, fmt.Errorf("Not supported by the executor")
+}
+
+func (c *executorConnection) Identity() string {
+	return ""
+}
+
+var _ messaging.Connection = (*executorConnection)(nil)
@@ -0,0 +1,153 @@
+package cookiejar
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/sawtooth-sdk-go/processor"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/state_context_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/transaction_pb2"
+	"github.com/hyperledger/sawtooth-sdk-go/protobuf/validator_pb2"
+)
+
+// transaction returns a cookiejar transaction of the signer, reading and writing the whole namespace
+func transaction(t *testing.T, signer, payload string) *transaction_pb2.Transaction {
+	namespace := Hexdigest(FamilyName)[:6]
+	header, err := proto.Marshal(&transaction_pb2.TransactionHeader{
+		FamilyName:      FamilyName,
+		FamilyVersion:   "1.0",
+		SignerPublicKey: signer,
+		Inputs:          []string{namespace},
+		Outputs:         []string{namespace},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &transaction_pb2.Transaction{Header: header, HeaderSignature: signer + ":" + payload, Payload: []byte(payload)}
+}
+
+func TestExecutorAppliesTheActions(t *testing.T) {
+	handler := NewCookiejarHandler()
+	e := NewExecutor(handler, nil)
+	address := handler.getAddress("alice")
+
+	cases := []struct {
+		payload string
+		balance string
+		event   string
+	}{
+		{"bake,5", "5", "cookiejar/bake"},
+		{"eat,2", "3", "cookiejar/eat"},
+		{"clear,0", "0", ""},
+	}
+	for _, c := range cases {
+		receipt, err := e.Execute(transaction(t, "alice", c.payload))
+		if err != nil {
+			t.Fatalf("%s: %v", c.payload, err)
+		}
+		if got := string(e.State[address]); got != c.balance {
+			t.Fatalf("%s: got balance %q, want %q", c.payload, got, c.balance)
+		}
+		if len(receipt.StateChanges) != 1 || receipt.StateChanges[0].Address != address {
+			t.Fatalf("%s: got changes %v, want the jar of alice", c.payload, receipt.StateChanges)
+		}
+		if c.event == "" && len(receipt.Events) != 0 || c.event != "" &&
+			(len(receipt.Events) != 1 || receipt.Events[0].EventType != c.event) {
+			t.Fatalf("%s: got events %v, want %q", c.payload, receipt.Events, c.event)
+		}
+	}
+}
+
+func TestExecutorFailsOnMissingJars(t *testing.T) {
+	for _, payload := range []string{"eat,1", "clear,0"} {
+		e := NewExecutor(NewCookiejarHandler(), nil)
+		_, err := e.Execute(transaction(t, "alice", payload))
+		if _, ok := err.(*processor.InvalidTransactionError); !ok {
+			t.Fatalf("%s: got %v, want an invalid transaction", payload, err)
+		}
+		if len(e.State) != 0 {
+			t.Fatalf("%s: got state %v, want it untouched", payload, e.State)
+		}
+	}
+
+	// A payload without an amount is invalid rather than a crash
+	e := NewExecutor(NewCookiejarHandler(), nil)
+	if _, err := e.Execute(transaction(t, "alice", "bake")); err == nil {
+		t.Fatal("got no error for a payload without an amount")
+	} else if _, ok := err.(*processor.InvalidTransactionError); !ok {
+		t.Fatalf("got %v, want an invalid transaction", err)
+	}
+
+	// Eating more than the jar holds is invalid
+	e = NewExecutor(NewCookiejarHandler(), nil)
+	if _, err := e.Execute(transaction(t, "alice", "bake,1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Execute(transaction(t, "alice", "eat,2")); err == nil {
+		t.Fatal("got no error eating too many cookies")
+	} else if _, ok := err.(*processor.InvalidTransactionError); !ok {
+		t.Fatalf("got %v, want an invalid transaction", err)
+	}
+}
+
+func TestExecutorBatchesAreAtomic(t *testing.T) {
+	handler := NewCookiejarHandler()
+	e := NewExecutor(handler, nil)
+
+	// The eat sees the bake of the same batch
+	receipts, err := e.ExecuteBatch([]*transaction_pb2.Transaction{
+		transaction(t, "alice", "bake,3"), transaction(t, "alice", "eat,1")})
+	if err != nil || len(receipts) != 2 {
+		t.Fatalf("got %v, %v, want both receipts", receipts, err)
+	}
+	if got := string(e.State[handler.getAddress("alice")]); got != "2" {
+		t.Fatalf("got balance %q, want 2", got)
+	}
+
+	// A failing transaction discards the changes of the ones before it
+	receipts, err = e.ExecuteBatch([]*transaction_pb2.Transaction{
+		transaction(t, "alice", "bake,3"), transaction(t, "bob", "clear,0")})
+	if err == nil || len(receipts) != 1 {
+		t.Fatalf("got %v, %v, want the receipt of the bake and the error of the clear", receipts, err)
+	}
+	if got := string(e.State[handler.getAddress("alice")]); got != "2" || len(e.State) != 1 {
+		t.Fatalf("got state %v, want it untouched", e.State)
+	}
+}
+
+func TestValidatorContextChecksInputsAndOutputs(t *testing.T) {
+	header := &transaction_pb2.TransactionHeader{Inputs: []string{"aa"}, Outputs: []string{"aa"}}
+	c := NewValidatorContext(header, "t", map[string][]byte{"aa01": []byte("1"), "bb01": []byte("2")})
+
+	get := func(address string) *state_context_pb2.TpStateGetResponse {
+		content, _ := proto.Marshal(&state_context_pb2.TpStateGetRequest{Addresses: []string{address}})
+		_, response, err := c.Handle(validator_pb2.Message_TP_STATE_GET_REQUEST, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.(*state_context_pb2.TpStateGetResponse)
+	}
+	if r := get("aa01"); r.Status != state_context_pb2.TpStateGetResponse_OK || len(r.Entries) != 1 {
+		t.Fatalf("got %v, want the entry", r)
+	}
+	if r := get("aa02"); r.Status != state_context_pb2.TpStateGetResponse_OK || len(r.Entries) != 0 {
+		t.Fatalf("got %v, want no entry for an empty address", r)
+	}
+	if r := get("bb01"); r.Status != state_context_pb2.TpStateGetResponse_AUTHORIZATION_ERROR {
+		t.Fatalf("got %v, want an authorization error outside of the inputs", r)
+	}
+
+	content, _ := proto.Marshal(&state_context_pb2.TpStateSetRequest{
+		Entries: []*state_context_pb2.TpStateEntry{{Address: "bb01", Data: []byte("3")}}})
+	_, response, err := c.Handle(validator_pb2.Message_TP_STATE_SET_REQUEST, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := response.(*state_context_pb2.TpStateSetResponse); r.Status != state_context_pb2.TpStateSetResponse_AUTHORIZATION_ERROR {
+		t.Fatalf("got %v, want an authorization error outside of the outputs", r)
+	}
+	if changes := c.Receipt().StateChanges; len(changes) != 0 {
+		t.Fatalf("got changes %v, want none", changes)
+	}
+}
@@ -1,4 +1,6 @@
-package main
+// Package cookiejar holds the transaction handler of the cookiejar transaction family. It's shared by the
+// processor and by the client, which runs the same rules locally to simulate transactions.
+package cookiejar
 
 import (
 	"crypto/sha512"
@@ -13,6 +15,9 @@ import (
 	"github.com/hyperledger/sawtooth-sdk-go/protobuf/processor_pb2"
 )
 
+// FamilyName is the name of the cookiejar transaction family
+const FamilyName = "cookiejar"
+
 var logger *logging.Logger = logging.Get()
 
 // Hexdigest returns a sha512 hash of the input as a string
@@ -37,7 +42,7 @@ func (h *CookiejarHandler) getAddress(name string) string {
 
 // FamilyName returns the name of the transaction family this handler processes
 func (h *CookiejarHandler) FamilyName() string {
-	return familyName
+	return FamilyName
 }
 
 // FamilyVersions return the versions of the transaction processor this handler can process
@@ -61,10 +66,13 @@ func (h *CookiejarHandler) Apply(r *processor_pb2.TpProcessRequest, ctx *process
 	// Field 0 represents the requested action
 	// Field 1 represents the amount
 	payloadList := strings.Split(string(r.GetPayload()), ",")
+	if len(payloadList) < 2 {
+		return &processor.InvalidTransactionError{Msg: fmt.Sprintf("Invalid payload: '%s'", r.GetPayload())}
+	}
 	action := payloadList[0]
 	amount, err := strconv.Atoi(payloadList[1]) // Convert to int
 	if err != nil {
-		return &processor.InternalError{Msg: fmt.Sprintf("Couldn't parse amount : %v", err)}
+		return &processor.InvalidTransactionError{Msg: fmt.Sprintf("Couldn't parse amount : %v", err)}
 	}
 
 	logger.Debugf("Action: %s, Amount: %d\n", action, amount)
@@ -121,7 +129,7 @@ func (h *CookiejarHandler) bake(ctx *processor.Context, amount int, fromKey stri
 	// Launch an event
 	if err := ctx.AddEvent(
 		"cookiejar/bake",
-		[]processor.Attribute{processor.Attribute{"cookies-baked", strconv.Itoa(amount)}},
+		[]processor.Attribute{processor.Attribute{Key: "cookies-baked", Value: strconv.Itoa(amount)}},
 		nil,
 	); err != nil {
 		return err
@@ -147,7 +155,7 @@ func (h *CookiejarHandler) eat(ctx *processor.Context, amount int, fromKey strin
 	if !ok {
 		// The address doesn't exist, so we'll return with an error
 		logger.Errorf("No cookie jar with the key %s", address)
-		return &processor.InternalError{Msg: "Invalid cookie jar"}
+		return &processor.InvalidTransactionError{Msg: "Invalid cookie jar"}
 	}
 
 	cookies, _ := strconv.Atoi(string(c)) // convert to int
@@ -174,7 +182,7 @@ func (h *CookiejarHandler) eat(ctx *processor.Context, amount int, fromKey strin
 	// Launch an event
 	if err := ctx.AddEvent(
 		"cookiejar/eat",
-		[]processor.Attribute{processor.Attribute{"cookies-ate", strconv.Itoa(amount)}},
+		[]processor.Attribute{processor.Attribute{Key: "cookies-ate", Value: strconv.Itoa(amount)}},
 		nil,
 	); err != nil {
 		return &processor.InternalError{Msg: fmt.Sprintf("Couldn't publish event: %v", err)}
@@ -199,7 +207,7 @@ func (h *CookiejarHandler) empty(ctx *processor.Context, fromKey string) error {
 	_, ok := state[address]
 	if !ok {
 		log.Printf("No cookie jar with the key %s", address)
-		return &processor.InternalError{
+		return &processor.InvalidTransactionError{
 			Msg: "Invalid cookie jar",
 		}
 	}
@@ -216,6 +224,6 @@ func (h *CookiejarHandler) empty(ctx *processor.Context, fromKey string) error {
 // NewCookiejarHandler returns an initialized CookiejarHandler
 func NewCookiejarHandler() *CookiejarHandler {
 	return &CookiejarHandler{
-		namespace: Hexdigest(familyName)[:6],
+		namespace: Hexdigest(FamilyName)[:6],
 	}
 }
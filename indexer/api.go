@@ -0,0 +1,370 @@
+package main
+
+import (
+	"cookiejarevents"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLimit is the maximum amount of items returned by a list
+const maxLimit = 1000
+
+// jar is a cookie jar as returned by the API
+type jar struct {
+	Address  string `json:"address"`
+	Owner    string `json:"owner,omitempty"`
+	Balance  *int64 `json:"balance"`
+	Data     string `json:"data,omitempty"`
+	BlockNum uint64 `json:"block_num"`
+	BlockID  string `json:"block_id"`
+}
+
+// transaction is an indexed cookiejar transaction as returned by the API
+type transaction struct {
+	TransactionID string     `json:"transaction_id"`
+	BlockNum      uint64     `json:"block_num"`
+	BlockID       string     `json:"block_id"`
+	BatchID       string     `json:"batch_id"`
+	Signer        string     `json:"signer"`
+	Address       string     `json:"address"`
+	Action        string     `json:"action"`
+	Amount        int64      `json:"amount"`
+	Balance       *int64     `json:"balance,omitempty"`    // balance of the jar at the end of the block
+	BlockTime     *time.Time `json:"block_time,omitempty"` // time the block was built at, if known
+}
+
+// leader is an entry of a leaderboard
+type leader struct {
+	Owner        string `json:"owner"`
+	Address      string `json:"address"`
+	Total        int64  `json:"total"`
+	Transactions int64  `json:"transactions"`
+}
+
+// actionStats aggregates the transactions of an action
+type actionStats struct {
+	Transactions int64 `json:"transactions"`
+	Total        int64 `json:"total"`
+}
+
+// stats are the aggregates of the index
+type stats struct {
+	HeadBlockNum uint64                  `json:"head_block_num"`
+	HeadBlockID  string                  `json:"head_block_id"`
+	Blocks       int64                   `json:"blocks"`
+	Jars         int64                   `json:"jars"`
+	Cookies      int64                   `json:"cookies"`
+	Actions      map[string]*actionStats `json:"actions"`
+}
+
+// httpError is an error with the status code to respond with
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+// api serves the index over HTTP
+type api struct {
+	store *store
+}
+
+// newAPI returns the handler of the API
+func newAPI(s *store) http.Handler {
+	a := &api{store: s}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jars", a.handle(a.listJars))
+	mux.HandleFunc("/jars/", a.handle(a.getJar))
+	mux.HandleFunc("/transactions", a.handle(a.listTransactions))
+	mux.HandleFunc("/leaderboard", a.handle(a.leaderboard))
+	mux.HandleFunc("/stats", a.handle(a.stats))
+
+	return mux
+}
+
+// handle wraps an endpoint, which returns the value to encode as JSON
+func (a *api) handle(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Only GET is supported"})
+			return
+		}
+
+		v, err := fn(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if e, ok := err.(*httpError); ok {
+				status = e.status
+			} else {
+				logger.Printf("%s %s failed: %v", r.Method, r.URL, err)
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": v})
+	}
+}
+
+// paging returns the limit and offset query parameters
+func paging(r *http.Request, defaultLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxLimit {
+			return 0, 0, &httpError{http.StatusBadRequest, fmt.Sprintf("Invalid limit %q, use 1 to %d", v, maxLimit)}
+		}
+		limit = l
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return 0, 0, &httpError{http.StatusBadRequest, fmt.Sprintf("Invalid offset %q", v)}
+		}
+		offset = o
+	}
+
+	return limit, offset, nil
+}
+
+// parseSince parses a point in time, either RFC 3339 or a duration before now such as 168h
+func parseSince(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().UTC().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, &httpError{http.StatusBadRequest, fmt.Sprintf("Invalid since %q, use a duration or RFC 3339", v)}
+	}
+
+	return t.UTC(), nil
+}
+
+// resolveAddress returns the address of a jar referenced either by its address or by its owner's public key
+func resolveAddress(ref string) string {
+	if strings.HasPrefix(ref, cookiejarevents.Namespace) && len(ref) == 70 {
+		return ref
+	}
+
+	return cookiejarevents.JarAddress(ref)
+}
+
+// scanJar reads a jar from a row
+func scanJar(scan func(...interface{}) error) (*jar, error) {
+	j := &jar{}
+	var balance sql.NullInt64
+	if err := scan(&j.Address, &j.Owner, &balance, &j.Data, &j.BlockNum, &j.BlockID); err != nil {
+		return nil, err
+	}
+	if balance.Valid {
+		j.Balance = &balance.Int64
+	}
+
+	return j, nil
+}
+
+// listJars handles GET /jars?limit=&offset=, ordered by balance
+func (a *api) listJars(r *http.Request) (interface{}, error) {
+	limit, offset, err := paging(r, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.store.db.Query(`SELECT address, owner, balance, data, block_num, block_id FROM jars
+		ORDER BY balance DESC, address LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jars := []*jar{}
+	for rows.Next() {
+		j, err := scanJar(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jars = append(jars, j)
+	}
+
+	return jars, rows.Err()
+}
+
+// getJar handles GET /jars/<address or owner> and GET /jars/<address or owner>/history
+func (a *api) getJar(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jars/"), "/"), "/")
+	address := resolveAddress(parts[0])
+
+	switch {
+	case len(parts) == 1:
+		j, err := scanJar(a.store.db.QueryRow(`SELECT address, owner, balance, data, block_num, block_id FROM jars
+			WHERE address = ?`, address).Scan)
+		if err == sql.ErrNoRows {
+			return nil, &httpError{http.StatusNotFound, fmt.Sprintf("Jar %s not found", parts[0])}
+		}
+		return j, err
+	case len(parts) == 2 && parts[1] == "history":
+		return a.queryTransactions(r, "t.address = ?", address)
+	default:
+		return nil, &httpError{http.StatusNotFound, "Not found"}
+	}
+}
+
+// listTransactions handles GET /transactions?action=&signer=&since=&limit=&offset=
+func (a *api) listTransactions(r *http.Request) (interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	q := r.URL.Query()
+	if v := q.Get("action"); v != "" {
+		conditions = append(conditions, "t.action = ?")
+		args = append(args, v)
+	}
+	if v := q.Get("signer"); v != "" {
+		conditions = append(conditions, "t.signer = ?")
+		args = append(args, v)
+	}
+	since, err := parseSince(q.Get("since"))
+	if err != nil {
+		return nil, err
+	}
+	if !since.IsZero() {
+		conditions = append(conditions, "t.block_time >= ?")
+		args = append(args, since.Unix())
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "1 = 1")
+	}
+
+	return a.queryTransactions(r, strings.Join(conditions, " AND "), args...)
+}
+
+// queryTransactions returns the transactions matching the condition, newest first, with the balance of their jar at
+// the end of their block
+func (a *api) queryTransactions(r *http.Request, condition string, args ...interface{}) (interface{}, error) {
+	limit, offset, err := paging(r, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.store.db.Query(`SELECT t.transaction_id, t.block_num, t.block_id, t.batch_id, t.signer, t.address,
+			t.action, t.amount, s.balance, t.block_time
+		FROM transactions t LEFT JOIN jar_states s ON s.address = t.address AND s.block_id = t.block_id
+		WHERE `+condition+`
+		ORDER BY t.block_num DESC, t.position DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []*transaction{}
+	for rows.Next() {
+		t := &transaction{}
+		var balance, blockTime sql.NullInt64
+		if err := rows.Scan(&t.TransactionID, &t.BlockNum, &t.BlockID, &t.BatchID, &t.Signer, &t.Address, &t.Action,
+			&t.Amount, &balance, &blockTime); err != nil {
+			return nil, err
+		}
+		if blockTime.Valid {
+			bt := time.Unix(blockTime.Int64, 0).UTC()
+			t.BlockTime = &bt
+		}
+		if balance.Valid {
+			t.Balance = &balance.Int64
+		}
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// leaderboard handles GET /leaderboard?action=bake&since=168h&limit=10, the owners with the highest total amount.
+// Without since, transactions of blocks without a time count too.
+func (a *api) leaderboard(r *http.Request) (interface{}, error) {
+	limit, _, err := paging(r, 10)
+	if err != nil {
+		return nil, err
+	}
+	action := r.URL.Query().Get("action")
+	if action == "" {
+		action = "bake"
+	}
+	if action != "bake" && action != "eat" {
+		return nil, &httpError{http.StatusBadRequest, fmt.Sprintf("Invalid action %q, use bake or eat", action)}
+	}
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		return nil, err
+	}
+
+	condition := "1 = 1"
+	args := []interface{}{action}
+	if !since.IsZero() {
+		condition = "block_time >= ?"
+		args = append(args, since.Unix())
+	}
+	rows, err := a.store.db.Query(`SELECT signer, address, SUM(amount) AS total, COUNT(*) FROM transactions
+		WHERE action = ? AND `+condition+`
+		GROUP BY signer, address ORDER BY total DESC, signer LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaders := []*leader{}
+	for rows.Next() {
+		l := &leader{}
+		if err := rows.Scan(&l.Owner, &l.Address, &l.Total, &l.Transactions); err != nil {
+			return nil, err
+		}
+		leaders = append(leaders, l)
+	}
+
+	return leaders, rows.Err()
+}
+
+// stats handles GET /stats, the aggregates of the index
+func (a *api) stats(r *http.Request) (interface{}, error) {
+	s := &stats{Actions: map[string]*actionStats{}}
+
+	err := a.store.db.QueryRow(`SELECT block_num, block_id FROM blocks ORDER BY block_num DESC LIMIT 1`).
+		Scan(&s.HeadBlockNum, &s.HeadBlockID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err := a.store.db.QueryRow(`SELECT COUNT(*) FROM blocks`).Scan(&s.Blocks); err != nil {
+		return nil, err
+	}
+	if err := a.store.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(balance), 0) FROM jars`).
+		Scan(&s.Jars, &s.Cookies); err != nil {
+		return nil, err
+	}
+
+	rows, err := a.store.db.Query(`SELECT action, COUNT(*), SUM(amount) FROM transactions GROUP BY action`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var action string
+		as := &actionStats{}
+		if err := rows.Scan(&action, &as.Transactions, &as.Total); err != nil {
+			return nil, err
+		}
+		s.Actions[action] = as
+	}
+
+	return s, rows.Err()
+}
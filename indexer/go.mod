@@ -0,0 +1,39 @@
+module cookiejar-indexer
+
+go 1.20
+
+require (
+	cookiejarevents v0.0.0
+	modernc.org/sqlite v1.29.6
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/pebbe/zmq4 v1.2.5 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/satori/go.uuid v1.2.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
+)
+
+// The SQLite driver only works with the libc version it was generated with
+require (
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/hyperledger/sawtooth-sdk-go v0.1.4 // indirect
+	modernc.org/libc v1.41.0 // indirect
+)
+
+// The event library lives in this repository and the SDK's protobuf packages are generated, see the Dockerfile
+replace (
+	cookiejarevents => ../events/go/src/cookiejarevents
+	github.com/hyperledger/sawtooth-sdk-go => ../sawtooth-sdk-go
+)
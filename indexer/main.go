@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"cookiejarevents"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var logger = log.New(os.Stderr, "", log.LstdFlags)
+
+// indexer writes the events of the subscription into the store
+type indexer struct {
+	store *store
+	rest  *restClient
+}
+
+// retry calls fn until it succeeds or the context is cancelled, with an exponential backoff
+func retry(ctx context.Context, what string, fn func() error) error {
+	delay := time.Second
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		logger.Printf("Failed to %s: %v, retrying in %v", what, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+}
+
+// index stores a committed block or removes an orphaned one. Failures are retried until the context is cancelled,
+// since the blocks must be indexed in order.
+func (ix *indexer) index(ctx context.Context, event *cookiejarevents.CookiejarEvent) error {
+	what := fmt.Sprintf("index %s of block %d %s", event.Type, event.BlockNum, event.BlockID)
+	return retry(ctx, what, func() error {
+		if event.Type == cookiejarevents.BlockRollback {
+			return ix.store.rollbackBlock(event.BlockID)
+		}
+		block, err := ix.rest.getBlock(event.BlockID)
+		if err != nil {
+			return err
+		}
+		return ix.store.indexBlock(event, block)
+	})
+}
+
+// indexGenesis stores the genesis block with the jars of its state, and returns it to resume the subscription from.
+// The validator then replays the events of every later block, so an empty index catches up with the whole chain.
+func (ix *indexer) indexGenesis(ctx context.Context) ([]cookiejarevents.BlockRef, error) {
+	var ref cookiejarevents.BlockRef
+	err := retry(ctx, "index the genesis block", func() error {
+		block, err := ix.rest.getGenesis()
+		if err != nil {
+			return err
+		}
+		changes, err := ix.rest.getJarStates(block.HeaderSignature)
+		if err != nil {
+			return err
+		}
+
+		event := &cookiejarevents.CookiejarEvent{
+			Type:            cookiejarevents.BlockCommit,
+			BlockID:         block.HeaderSignature,
+			PreviousBlockID: block.Header.PreviousBlockID,
+			StateRootHash:   block.Header.StateRootHash,
+			Changes:         changes,
+		}
+		if err := ix.store.indexBlock(event, block); err != nil {
+			return err
+		}
+		ref = cookiejarevents.BlockRef{BlockID: event.BlockID, PreviousBlockID: event.PreviousBlockID}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Indexed the genesis block %s, catching up with the chain", ref.BlockID)
+
+	return []cookiejarevents.BlockRef{ref}, nil
+}
+
+func main() {
+	dbFile := flag.String("db", "cookiejar-index.db", "SQLite database file")
+	validator := flag.String("connect", "", "validator endpoint to subscribe to (default: VALIDATOR_URL or tcp://validator:4004)")
+	restURL := flag.String("rest-url", "http://rest-api:8008", "REST API URL the blocks' transactions are read from")
+	bind := flag.String("bind", ":8080", "address the HTTP API listens on")
+	flag.Parse()
+
+	s, err := openStore(*dbFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer s.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		logger.Printf("Received %v, stopping", sig)
+		cancel()
+	}()
+
+	// The subscription resumes after the blocks of the database, so a crash never skips a block. An empty database
+	// starts from the genesis block.
+	ix := &indexer{store: s, rest: newRestClient(*restURL)}
+	resume, err := s.resume()
+	if err == nil && len(resume) == 0 {
+		resume, err = ix.indexGenesis(ctx)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	events, err := cookiejarevents.Subscribe(ctx, cookiejarevents.Options{
+		ValidatorURL: *validator,
+		Resume:       resume,
+		BlockTimes:   true,
+		Logf:         logger.Printf,
+		ManualAck:    true,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Addr: *bind, Handler: newAPI(s)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Printf("HTTP API failed: %v", err)
+			cancel()
+		}
+	}()
+	logger.Printf("Serving the API on %s", *bind)
+
+	failed := false
+	for event := range events {
+		if event.Type == cookiejarevents.SubscriptionError {
+			logger.Printf("Subscription failed: %v", event.Err)
+			failed = true
+			break
+		}
+		if err := ix.index(ctx, &event); err != nil {
+			break
+		}
+		event.Ack()
+	}
+	cancel()
+
+	// Drain the subscription until it's unsubscribed
+	for range events {
+	}
+	shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+	server.Shutdown(shutdown)
+	done()
+
+	if failed {
+		s.close()
+		os.Exit(1)
+	}
+}
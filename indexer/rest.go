@@ -0,0 +1,192 @@
+package main
+
+import (
+	"cookiejarevents"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	familyName = "cookiejar"
+	// restPageSize is the amount of items requested per page when paging through a list
+	restPageSize = 100
+)
+
+// restTransactionHeader is the decoded header of a transaction as returned by the REST API
+type restTransactionHeader struct {
+	FamilyName      string `json:"family_name"`
+	SignerPublicKey string `json:"signer_public_key"`
+}
+
+// restTransaction is a transaction as returned by the REST API
+type restTransaction struct {
+	Header          restTransactionHeader `json:"header"`
+	HeaderSignature string                `json:"header_signature"`
+	Payload         string                `json:"payload"` // base64 encoded
+}
+
+// restBatch is a batch as returned by the REST API
+type restBatch struct {
+	HeaderSignature string            `json:"header_signature"`
+	Transactions    []restTransaction `json:"transactions"`
+}
+
+// restBlockHeader is the decoded header of a block as returned by the REST API
+type restBlockHeader struct {
+	BlockNum        string `json:"block_num"` // uint64 values are encoded as strings
+	PreviousBlockID string `json:"previous_block_id"`
+	StateRootHash   string `json:"state_root_hash"`
+}
+
+// restBlock is a block as returned by the REST API
+type restBlock struct {
+	Header          restBlockHeader `json:"header"`
+	HeaderSignature string          `json:"header_signature"`
+	Batches         []restBatch     `json:"batches"`
+}
+
+// restStateEntry is an entry of the state as returned by the REST API
+type restStateEntry struct {
+	Address string `json:"address"`
+	Data    string `json:"data"` // base64 encoded
+}
+
+// restPaging is the paging element of a REST API list response
+type restPaging struct {
+	NextPosition string `json:"next_position"`
+}
+
+// decodePayload returns the payload of a transaction as a string
+func (t *restTransaction) decodePayload() (string, error) {
+	b, err := base64.StdEncoding.DecodeString(t.Payload)
+	if err != nil {
+		return "", fmt.Errorf("Decoding error: %v", err)
+	}
+
+	return string(b), nil
+}
+
+// restClient reads the blocks from the REST API, since the events don't carry the transactions
+type restClient struct {
+	url  string
+	http *http.Client
+}
+
+// newRestClient returns a client of the REST API at the provided URL
+func newRestClient(url string) *restClient {
+	return &restClient{
+		url:  strings.TrimSuffix(url, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// getJSON sends a GET request to the REST API and decodes the JSON response into v
+func (c *restClient) getJSON(suffix string, v interface{}) error {
+	res, err := c.http.Get(fmt.Sprintf("%s/%s", c.url, suffix))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("REST API responded with %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("Failed to decode %s: %v", suffix, err)
+	}
+
+	return nil
+}
+
+// pageSuffix adds the paging parameters to a URL suffix
+func pageSuffix(suffix, start string) string {
+	sep := "?"
+	if strings.Contains(suffix, "?") {
+		sep = "&"
+	}
+
+	suffix = fmt.Sprintf("%s%slimit=%d", suffix, sep, restPageSize)
+	if start != "" {
+		suffix = fmt.Sprintf("%s&start=%s", suffix, url.QueryEscape(start))
+	}
+
+	return suffix
+}
+
+// getBlock returns the block with the provided id
+func (c *restClient) getBlock(id string) (*restBlock, error) {
+	var body struct {
+		Data restBlock `json:"data"`
+	}
+	if err := c.getJSON(fmt.Sprintf("blocks/%s", id), &body); err != nil {
+		return nil, err
+	}
+
+	return &body.Data, nil
+}
+
+// getGenesis returns the genesis block, paging through the chain from its head
+func (c *restClient) getGenesis() (*restBlock, error) {
+	start := ""
+	for {
+		var page struct {
+			Data   []restBlock `json:"data"`
+			Paging restPaging  `json:"paging"`
+		}
+		if err := c.getJSON(pageSuffix("blocks", start), &page); err != nil {
+			return nil, err
+		}
+
+		for i := range page.Data {
+			if page.Data[i].Header.BlockNum == "0" {
+				return &page.Data[i], nil
+			}
+		}
+
+		if page.Paging.NextPosition == "" {
+			return nil, fmt.Errorf("The chain has no genesis block")
+		}
+		start = page.Paging.NextPosition
+	}
+}
+
+// getJarStates returns the jars in the state of the provided block
+func (c *restClient) getJarStates(head string) ([]cookiejarevents.JarChange, error) {
+	suffix := fmt.Sprintf("state?address=%s&head=%s", cookiejarevents.Namespace, head)
+	changes := []cookiejarevents.JarChange{}
+	start := ""
+	for {
+		var page struct {
+			Data   []restStateEntry `json:"data"`
+			Paging restPaging       `json:"paging"`
+		}
+		if err := c.getJSON(pageSuffix(suffix, start), &page); err != nil {
+			return nil, err
+		}
+
+		for _, e := range page.Data {
+			b, err := base64.StdEncoding.DecodeString(e.Data)
+			if err != nil {
+				return nil, fmt.Errorf("Decoding error: %v", err)
+			}
+			change := cookiejarevents.JarChange{Address: e.Address}
+			if balance, err := strconv.Atoi(string(b)); err == nil {
+				change.Balance = &balance
+			} else {
+				change.Data = string(b)
+			}
+			changes = append(changes, change)
+		}
+
+		if page.Paging.NextPosition == "" {
+			return changes, nil
+		}
+		start = page.Paging.NextPosition
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"cookiejarevents"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pagedServer serves the pages of a REST API list, keyed by the start parameter
+func pagedServer(t *testing.T, path string, pages map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path || r.URL.Query().Get("limit") == "" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		page, ok := pages[r.URL.Query().Get("start")]
+		if !ok {
+			http.Error(w, "unknown start", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func page(next string, data interface{}) map[string]interface{} {
+	return map[string]interface{}{"data": data, "paging": map[string]string{"next_position": next}}
+}
+
+func TestGetGenesisPagesToTheFirstBlock(t *testing.T) {
+	block := func(num, id string) restBlock {
+		return restBlock{HeaderSignature: id, Header: restBlockHeader{BlockNum: num}}
+	}
+	server := pagedServer(t, "/blocks", map[string]interface{}{
+		"":   page("b1", []restBlock{block("3", "b3"), block("2", "b2")}),
+		"b1": page("", []restBlock{block("1", "b1"), block("0", "b0")}),
+	})
+
+	genesis, err := newRestClient(server.URL + "/").getGenesis()
+	if err != nil || genesis.HeaderSignature != "b0" {
+		t.Fatalf("got %+v, %v, want block b0", genesis, err)
+	}
+}
+
+func TestGetGenesisWithoutGenesis(t *testing.T) {
+	server := pagedServer(t, "/blocks", map[string]interface{}{
+		"": page("", []restBlock{{HeaderSignature: "b1", Header: restBlockHeader{BlockNum: "1"}}}),
+	})
+
+	if _, err := newRestClient(server.URL).getGenesis(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestGetJarStates(t *testing.T) {
+	entry := func(address, data string) restStateEntry {
+		return restStateEntry{Address: address, Data: base64.StdEncoding.EncodeToString([]byte(data))}
+	}
+	pages := map[string]interface{}{
+		"":   page("p2", []restStateEntry{entry("a1", "3")}),
+		"p2": page("", []restStateEntry{entry("a2", "not a count")}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("address") != cookiejarevents.Namespace || q.Get("head") != "b0" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode(pages[q.Get("start")])
+	}))
+	defer server.Close()
+
+	changes, err := newRestClient(server.URL).getJarStates("b0")
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("got %+v, %v, want 2 jars", changes, err)
+	}
+	if changes[0].Address != "a1" || changes[0].Balance == nil || *changes[0].Balance != 3 {
+		t.Fatalf("got %+v, want a1 with 3 cookies", changes[0])
+	}
+	if changes[1].Balance != nil || changes[1].Data != "not a count" {
+		t.Fatalf("got %+v, want the raw value of a2", changes[1])
+	}
+}
+
+func TestGetJSONFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := newRestClient(server.URL).getBlock("b0"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestPageSuffix(t *testing.T) {
+	if got := pageSuffix("blocks", ""); got != "blocks?limit=100" {
+		t.Errorf("got %s", got)
+	}
+	if got := pageSuffix("state?address=a4d219", "x y"); got != "state?address=a4d219&limit=100&start=x+y" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"cookiejarevents"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// resumeDepth is the number of indexed blocks the subscription resumes from
+	resumeDepth = 50
+	// schemaVersion is stored in the user_version of the database. An index of another version is rebuilt from the
+	// chain.
+	schemaVersion = 2
+)
+
+// schema creates the tables of the index. Balances are stored per block in jar_states, so the jars changed by a
+// block orphaned by a fork can be restored to their previous balance. The block times come from the BlockInfo
+// transactions and are NULL on networks without them.
+const schema = `
+CREATE TABLE IF NOT EXISTS blocks (
+	block_id          TEXT PRIMARY KEY,
+	block_num         INTEGER NOT NULL,
+	previous_block_id TEXT NOT NULL,
+	state_root_hash   TEXT NOT NULL,
+	block_time        INTEGER -- unix time
+);
+CREATE INDEX IF NOT EXISTS blocks_num ON blocks (block_num);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	transaction_id TEXT PRIMARY KEY,
+	block_id       TEXT NOT NULL,
+	block_num      INTEGER NOT NULL,
+	batch_id       TEXT NOT NULL,
+	position       INTEGER NOT NULL,
+	signer         TEXT NOT NULL,
+	address        TEXT NOT NULL,
+	action         TEXT NOT NULL,
+	amount         INTEGER NOT NULL,
+	block_time     INTEGER -- unix time of the block
+);
+CREATE INDEX IF NOT EXISTS transactions_block ON transactions (block_id);
+CREATE INDEX IF NOT EXISTS transactions_address ON transactions (address, block_num);
+CREATE INDEX IF NOT EXISTS transactions_action ON transactions (action, block_time);
+
+CREATE TABLE IF NOT EXISTS jar_states (
+	address   TEXT NOT NULL,
+	block_id  TEXT NOT NULL,
+	block_num INTEGER NOT NULL,
+	balance   INTEGER,
+	data      TEXT NOT NULL,
+	deleted   BOOLEAN NOT NULL,
+	PRIMARY KEY (address, block_id)
+);
+CREATE INDEX IF NOT EXISTS jar_states_block ON jar_states (block_id);
+
+CREATE TABLE IF NOT EXISTS jars (
+	address   TEXT PRIMARY KEY,
+	owner     TEXT NOT NULL DEFAULT '',
+	balance   INTEGER,
+	data      TEXT NOT NULL,
+	block_num INTEGER NOT NULL,
+	block_id  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jars_owner ON jars (owner);
+`
+
+// store is the SQL database holding the index
+type store struct {
+	db *sql.DB
+}
+
+// openStore opens the SQLite database and creates the tables if needed. The tables of an index of another schema
+// version are dropped, so it's rebuilt from the genesis block.
+func openStore(file string) (*store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", file))
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to create schema: %v", err)
+	}
+
+	return &store{db: db}, nil
+}
+
+// migrate creates the tables of the current schema version
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version == schemaVersion {
+		_, err := db.Exec(schema)
+		return err
+	}
+
+	if version != 0 {
+		logger.Printf("Rebuilding the index of schema version %d", version)
+	}
+	for _, table := range []string{"blocks", "transactions", "jar_states", "jars"} {
+		if _, err := db.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion))
+
+	return err
+}
+
+// close closes the database
+func (s *store) close() error {
+	return s.db.Close()
+}
+
+// resume returns the last indexed blocks, oldest first
+func (s *store) resume() ([]cookiejarevents.BlockRef, error) {
+	rows, err := s.db.Query(`SELECT block_num, block_id, previous_block_id FROM blocks
+		ORDER BY block_num DESC LIMIT ?`, resumeDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []cookiejarevents.BlockRef
+	for rows.Next() {
+		var b cookiejarevents.BlockRef
+		if err := rows.Scan(&b.BlockNum, &b.BlockID, &b.PreviousBlockID); err != nil {
+			return nil, err
+		}
+		blocks = append([]cookiejarevents.BlockRef{b}, blocks...)
+	}
+
+	return blocks, rows.Err()
+}
+
+// parsePayload returns the action and amount of a cookiejar payload. The payload of a committed transaction was
+// accepted by the processor, so errors only come from unexpected payloads such as a clear without amount.
+func parsePayload(payload string) (string, int) {
+	fields := strings.Split(payload, ",")
+	amount := 0
+	if len(fields) > 1 {
+		amount, _ = strconv.Atoi(fields[1])
+	}
+
+	return fields[0], amount
+}
+
+// indexBlock stores the committed block, its cookiejar transactions and the jar balances it changed
+func (s *store) indexBlock(event *cookiejarevents.CookiejarEvent, block *restBlock) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var blockTime interface{}
+	if event.Timestamp > 0 {
+		blockTime = event.Timestamp
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO blocks (block_id, block_num, previous_block_id, state_root_hash, block_time)
+		VALUES (?, ?, ?, ?, ?)`, event.BlockID, event.BlockNum, event.PreviousBlockID, event.StateRootHash,
+		blockTime); err != nil {
+		return err
+	}
+
+	// The signers of the transactions own the jars they changed
+	owners := map[string]string{}
+	position := 0
+	for _, batch := range block.Batches {
+		for _, t := range batch.Transactions {
+			if t.Header.FamilyName != familyName {
+				continue
+			}
+			payload, err := t.decodePayload()
+			if err != nil {
+				return err
+			}
+			action, amount := parsePayload(payload)
+			address := cookiejarevents.JarAddress(t.Header.SignerPublicKey)
+			owners[address] = t.Header.SignerPublicKey
+
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO transactions (transaction_id, block_id, block_num, batch_id,
+				position, signer, address, action, amount, block_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				t.HeaderSignature, event.BlockID, event.BlockNum, batch.HeaderSignature, position,
+				t.Header.SignerPublicKey, address, action, amount, blockTime); err != nil {
+				return err
+			}
+			position++
+		}
+	}
+
+	for _, change := range event.Changes {
+		var balance interface{}
+		if change.Balance != nil {
+			balance = *change.Balance
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO jar_states (address, block_id, block_num, balance, data, deleted)
+			VALUES (?, ?, ?, ?, ?, ?)`, change.Address, event.BlockID, event.BlockNum, balance, change.Data,
+			change.Deleted); err != nil {
+			return err
+		}
+
+		owner := owners[change.Address]
+		if owner == "" {
+			owner = change.Owner
+		}
+		if change.Deleted {
+			if _, err := tx.Exec(`DELETE FROM jars WHERE address = ?`, change.Address); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO jars (address, owner, balance, data, block_num, block_id)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (address) DO UPDATE SET
+				owner = CASE WHEN excluded.owner = '' THEN jars.owner ELSE excluded.owner END,
+				balance = excluded.balance, data = excluded.data,
+				block_num = excluded.block_num, block_id = excluded.block_id`,
+			change.Address, owner, balance, change.Data, event.BlockNum, event.BlockID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rollbackBlock removes a block orphaned by a fork and restores the jars it changed to their balance in the latest
+// remaining block
+func (s *store) rollbackBlock(blockID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT address FROM jar_states WHERE block_id = ?`, blockID)
+	if err != nil {
+		return err
+	}
+	var addresses []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			rows.Close()
+			return err
+		}
+		addresses = append(addresses, address)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"jar_states", "transactions", "blocks"} {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE block_id = ?`, table), blockID); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range addresses {
+		var balance sql.NullInt64
+		var data, previousBlockID string
+		var blockNum uint64
+		var deleted bool
+		err := tx.QueryRow(`SELECT balance, data, deleted, block_num, block_id FROM jar_states WHERE address = ?
+			ORDER BY block_num DESC LIMIT 1`, address).Scan(&balance, &data, &deleted, &blockNum, &previousBlockID)
+		if err == sql.ErrNoRows || (err == nil && deleted) {
+			_, err = tx.Exec(`DELETE FROM jars WHERE address = ?`, address)
+		} else if err == nil {
+			var b interface{}
+			if balance.Valid {
+				b = balance.Int64
+			}
+			_, err = tx.Exec(`INSERT INTO jars (address, owner, balance, data, block_num, block_id)
+				VALUES (?, COALESCE((SELECT signer FROM transactions WHERE address = ? LIMIT 1), ''), ?, ?, ?, ?)
+				ON CONFLICT (address) DO UPDATE SET
+					balance = excluded.balance, data = excluded.data,
+					block_num = excluded.block_num, block_id = excluded.block_id`,
+				address, address, b, data, blockNum, previousBlockID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"cookiejarevents"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSigner = "02a1633cafcc01ebfb6d78e39f687a1f0995c62fc95f51ead10a02ee0be551b5dc"
+
+func openTestStore(t *testing.T) *store {
+	t.Helper()
+	s, err := openStore(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+
+	return s
+}
+
+// commitEvent returns the commit event of a block changing the jar of testSigner to balance
+func commitEvent(num uint64, id, previous string, balance int) *cookiejarevents.CookiejarEvent {
+	return &cookiejarevents.CookiejarEvent{
+		Type:            cookiejarevents.BlockCommit,
+		BlockNum:        num,
+		BlockID:         id,
+		PreviousBlockID: previous,
+		StateRootHash:   "root-" + id,
+		Timestamp:       1500000000 + int64(num),
+		Changes:         []cookiejarevents.JarChange{{Address: cookiejarevents.JarAddress(testSigner), Balance: &balance}},
+	}
+}
+
+// blockOf returns the block of the event holding a transaction of testSigner per payload, and one of another family
+func blockOf(event *cookiejarevents.CookiejarEvent, payloads ...string) *restBlock {
+	batch := restBatch{HeaderSignature: "batch-" + event.BlockID}
+	batch.Transactions = append(batch.Transactions, restTransaction{
+		Header:          restTransactionHeader{FamilyName: "block_info", SignerPublicKey: "validator"},
+		HeaderSignature: "info-" + event.BlockID,
+	})
+	for i, p := range payloads {
+		batch.Transactions = append(batch.Transactions, restTransaction{
+			Header:          restTransactionHeader{FamilyName: familyName, SignerPublicKey: testSigner},
+			HeaderSignature: event.BlockID + "-" + string(rune('a'+i)),
+			Payload:         base64.StdEncoding.EncodeToString([]byte(p)),
+		})
+	}
+
+	return &restBlock{HeaderSignature: event.BlockID, Batches: []restBatch{batch}}
+}
+
+func mustIndex(t *testing.T, s *store, event *cookiejarevents.CookiejarEvent, payloads ...string) {
+	t.Helper()
+	if err := s.indexBlock(event, blockOf(event, payloads...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// jarBalance returns the balance of the jar of testSigner and whether the jar exists
+func jarBalance(t *testing.T, s *store) (int64, bool) {
+	t.Helper()
+	var owner string
+	var balance int64
+	err := s.db.QueryRow(`SELECT owner, balance FROM jars WHERE address = ?`,
+		cookiejarevents.JarAddress(testSigner)).Scan(&owner, &balance)
+	if err != nil {
+		return 0, false
+	}
+	if owner != testSigner {
+		t.Fatalf("got owner %q, want %q", owner, testSigner)
+	}
+
+	return balance, true
+}
+
+func resumedIds(t *testing.T, s *store) []string {
+	t.Helper()
+	blocks, err := s.resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, b := range blocks {
+		ids = append(ids, b.BlockID)
+	}
+
+	return ids
+}
+
+func TestIndexBlock(t *testing.T) {
+	s := openTestStore(t)
+	mustIndex(t, s, commitEvent(1, "a", "genesis", 2), "bake,3", "eat,1")
+
+	rows, err := s.db.Query(`SELECT action, amount, signer, block_time FROM transactions ORDER BY position`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var action, signer string
+		var amount int
+		var blockTime int64
+		if err := rows.Scan(&action, &amount, &signer, &blockTime); err != nil {
+			t.Fatal(err)
+		}
+		if signer != testSigner || blockTime != 1500000001 {
+			t.Fatalf("got signer %s at %d", signer, blockTime)
+		}
+		got = append(got, fmt.Sprintf("%s,%d", action, amount))
+	}
+	if !reflect.DeepEqual(got, []string{"bake,3", "eat,1"}) {
+		t.Fatalf("got transactions %v, want only the cookiejar ones", got)
+	}
+
+	if balance, ok := jarBalance(t, s); !ok || balance != 2 {
+		t.Fatalf("got balance %d, %v, want 2", balance, ok)
+	}
+	if ids := resumedIds(t, s); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestIndexBlockWithoutTimestamp(t *testing.T) {
+	s := openTestStore(t)
+	event := commitEvent(1, "a", "genesis", 3)
+	event.Timestamp = 0
+	mustIndex(t, s, event, "bake,3")
+
+	var blockTime interface{}
+	if err := s.db.QueryRow(`SELECT block_time FROM blocks`).Scan(&blockTime); err != nil || blockTime != nil {
+		t.Fatalf("got %v, %v, want a NULL block time", blockTime, err)
+	}
+}
+
+func TestRollbackRestoresPreviousBalance(t *testing.T) {
+	s := openTestStore(t)
+	mustIndex(t, s, commitEvent(1, "a", "genesis", 3), "bake,3")
+	mustIndex(t, s, commitEvent(2, "b", "a", 5), "bake,2")
+
+	if err := s.rollbackBlock("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance, ok := jarBalance(t, s); !ok || balance != 3 {
+		t.Fatalf("got balance %d, %v, want the balance of block a", balance, ok)
+	}
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM transactions WHERE block_id = 'b'`).Scan(&count); err != nil ||
+		count != 0 {
+		t.Fatalf("got %d transactions of the orphaned block, %v", count, err)
+	}
+	if ids := resumedIds(t, s); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Fatalf("got %v", ids)
+	}
+}
+
+func TestRollbackRemovesNewJar(t *testing.T) {
+	s := openTestStore(t)
+	mustIndex(t, s, commitEvent(1, "a", "genesis", 3), "bake,3")
+
+	if err := s.rollbackBlock("a"); err != nil {
+		t.Fatal(err)
+	}
+	if balance, ok := jarBalance(t, s); ok {
+		t.Fatalf("got balance %d, want the jar removed", balance)
+	}
+}
+
+func TestRollbackRestoresDeletedJar(t *testing.T) {
+	s := openTestStore(t)
+	mustIndex(t, s, commitEvent(1, "a", "genesis", 3), "bake,3")
+	deleted := commitEvent(2, "b", "a", 0)
+	deleted.Changes[0].Balance = nil
+	deleted.Changes[0].Deleted = true
+	mustIndex(t, s, deleted, "clear")
+
+	if _, ok := jarBalance(t, s); ok {
+		t.Fatal("got the deleted jar")
+	}
+	if err := s.rollbackBlock("b"); err != nil {
+		t.Fatal(err)
+	}
+	if balance, ok := jarBalance(t, s); !ok || balance != 3 {
+		t.Fatalf("got balance %d, %v, want the jar restored", balance, ok)
+	}
+}
+
+func TestMigrateRebuildsOlderSchema(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index.db")
+	s, err := openStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustIndex(t, s, commitEvent(1, "a", "genesis", 3), "bake,3")
+	s.close()
+
+	// Reopening an index of the current version keeps it
+	if s, err = openStore(file); err != nil {
+		t.Fatal(err)
+	}
+	if ids := resumedIds(t, s); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Fatalf("got %v, want the index kept", ids)
+	}
+
+	if _, err := s.db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(s.db); err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	if ids := resumedIds(t, s); len(ids) != 0 {
+		t.Fatalf("got %v, want an empty index", ids)
+	}
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != schemaVersion {
+		t.Fatalf("got version %d, %v, want %d", version, err, schemaVersion)
+	}
+}
+
+func TestParsePayload(t *testing.T) {
+	for payload, want := range map[string]string{"bake,3": "bake,3", "eat,10": "eat,10", "clear": "clear,0"} {
+		action, amount := parsePayload(payload)
+		if got := fmt.Sprintf("%s,%d", action, amount); got != want {
+			t.Errorf("%s: got %s, want %s", payload, got, want)
+		}
+	}
+}
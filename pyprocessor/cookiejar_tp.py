@@ -92,15 +92,22 @@ class CookieJarTransactionHandler(TransactionHandler):
         # It was serialized with CSV: action, value
         header = transaction.header
         payload_list = transaction.payload.decode().split(",")
+        if len(payload_list) < 2:
+            raise InvalidTransaction("Invalid payload: '{}'".format(
+                transaction.payload.decode()))
         action = payload_list[0]
-        amount = payload_list[1]
+        try:
+            amount = int(payload_list[1])
+        except ValueError:
+            raise InvalidTransaction("Couldn't parse amount: {}".format(
+                payload_list[1]))
 
         # Get the signer's public key, sent in the header from the client.
         from_key = header.signer_public_key
 
         # Perform the action.
         LOGGER.info("Action = %s.", action)
-        LOGGER.info("Amount = %s.", amount)
+        LOGGER.info("Amount = %d.", amount)
         if action == "bake":
             self._make_bake(context, amount, from_key)
         elif action == "eat":
@@ -108,7 +115,8 @@ class CookieJarTransactionHandler(TransactionHandler):
         elif action == "clear":
             self._empty_cookie_jar(context, amount, from_key)
         else:
-            LOGGER.info("Unhandled action. Action should be bake or eat")
+            LOGGER.info("Unhandled action. Action should be bake, eat or clear")
+            raise InvalidTransaction("Invalid Action: '{}'".format(action))
 
     @classmethod
     def _make_bake(cls, context, amount, from_key):
@@ -122,13 +130,13 @@ class CookieJarTransactionHandler(TransactionHandler):
         if state_entries == []:
             LOGGER.info('No previous cookies, creating new cookie jar %s.',
                         from_key)
-            new_count = int(amount)
+            new_count = amount
         else:
             try:
                 count = int(state_entries[0].data)
             except:
                 raise InternalError('Failed to load state data')
-            new_count = int(amount) + int(count)
+            new_count = amount + count
 
         state_data = str(new_count).encode('utf-8')
         addresses = context.set_state({cookiejar_address: state_data})
@@ -137,7 +145,7 @@ class CookieJarTransactionHandler(TransactionHandler):
             raise InternalError("State Error")
         context.add_event(
             event_type="cookiejar/bake",
-            attributes=[("cookies-baked", amount)])
+            attributes=[("cookies-baked", str(amount))])
 
     @classmethod
     def _make_eat(cls, context, amount, from_key):
@@ -147,20 +155,18 @@ class CookieJarTransactionHandler(TransactionHandler):
                     from_key, cookiejar_address)
 
         state_entries = context.get_state([cookiejar_address])
-        new_count = 0
-
         if state_entries == []:
             LOGGER.info('No cookie jar with the key %s.', from_key)
-        else:
-            try:
-                count = int(state_entries[0].data)
-            except:
-                raise InternalError('Failed to load state data')
-            if count < int(amount):
-                raise InvalidTransaction('Not enough cookies to eat. '
-                                         'The number should be <= %s.', count)
-            else:
-                new_count = count - int(amount)
+            raise InvalidTransaction('Invalid cookie jar')
+
+        try:
+            count = int(state_entries[0].data)
+        except:
+            raise InternalError('Failed to load state data')
+        if count < amount:
+            raise InvalidTransaction('Not enough cookies to eat. '
+                                     'The number should be <= %s.', count)
+        new_count = count - amount
 
         LOGGER.info('Eating %s cookies out of %d.', amount, count)
         state_data = str(new_count).encode('utf-8')
@@ -171,7 +177,7 @@ class CookieJarTransactionHandler(TransactionHandler):
             raise InternalError("State Error")
         context.add_event(
             event_type="cookiejar/eat",
-            attributes=[("cookies-ate", amount)])
+            attributes=[("cookies-ate", str(amount))])
 
     @classmethod
     def _empty_cookie_jar(cls, context, amount, from_key):
@@ -180,7 +186,7 @@ class CookieJarTransactionHandler(TransactionHandler):
         state_entries = context.get_state([cookie_jar_address])
         if state_entries == []:
             LOGGER.info('No cookie jar with the key %s.', from_key)
-            return
+            raise InvalidTransaction('Invalid cookie jar')
         else:
             state_data = str(0).encode('utf-8')
             addresses = context.set_state(